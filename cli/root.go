@@ -22,8 +22,13 @@ import (
 
 	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
 	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd/ca"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd/contacts"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd/fetch"
 	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd/keys"
 	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd/mail"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd/outbox"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd/shares"
 	"github.com/spf13/cobra"
 )
 
@@ -63,6 +68,12 @@ func init() {
 	rootCmd.AddCommand(subcmd.DecodeCmd)
 	rootCmd.AddCommand(mail.SendMailCmd)
 	rootCmd.AddCommand(keys.GenerateKeyCmd)
+	rootCmd.AddCommand(keys.KeysCmd)
+	rootCmd.AddCommand(ca.CACmd)
+	rootCmd.AddCommand(contacts.ContactsCmd)
+	rootCmd.AddCommand(outbox.OutboxCmd)
+	rootCmd.AddCommand(shares.SharesCmd)
+	rootCmd.AddCommand(fetch.FetchCmd)
 
 	rootCmd.Flags().BoolP("version", "v", false, "Version of CLI")
 }
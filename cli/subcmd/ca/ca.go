@@ -0,0 +1,273 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/ca"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	caDir     string
+	subject   string
+	email     string
+	name      string
+	pubkey    string
+	csrPath   string
+	days      int
+	output    string
+	force     bool
+	serialArg string
+)
+
+// CACmd groups the team certificate authority subcommands.
+var CACmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Team certificate authority binding public keys to email addresses.",
+}
+
+var initCmd = &cobra.Command{
+	Use:     "init",
+	Short:   "Create the team CA key and certificate.",
+	Example: "cryptix ca init --subject \"CN=Corp Team CA,O=Corp\"",
+	Run:     runInitCmd,
+}
+
+var issueCmd = &cobra.Command{
+	Use:     "issue",
+	Short:   "Issue a certificate binding a teammate's public key to their email address.",
+	Example: "cryptix ca issue --email alice@corp.com --pubkey <path/to/public.pem>\ncryptix ca issue --csr <path/to/alice.csr>",
+	Run:     runIssueCmd,
+}
+
+var revokeCmd = &cobra.Command{
+	Use:     "revoke <serial>",
+	Short:   "Revoke an issued certificate and refresh the CRL.",
+	Example: "cryptix ca revoke 3f2a9c...",
+	Args:    cobra.ExactArgs(1),
+	Run:     runRevokeCmd,
+}
+
+var crlCmd = &cobra.Command{
+	Use:   "crl",
+	Short: "Re-sign the certificate revocation list.",
+	Run:   runCrlCmd,
+}
+
+var bundleCmd = &cobra.Command{
+	Use:     "bundle",
+	Short:   "Export the trust bundle (CA certificate and CRL).",
+	Example: "cryptix ca bundle --output trust.pem",
+	Run:     runBundleCmd,
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the certificates issued by the CA.",
+	Run:   runListCmd,
+}
+
+func runInitCmd(cmd *cobra.Command, args []string) {
+	readFlags(cmd)
+
+	caSubject, err := crypt.ParseSubject(subject)
+	if err != nil {
+		utility.Error("Invalid subject: %s", err)
+		os.Exit(1)
+	}
+
+	authority, err := ca.Init(caDir, caSubject, time.Duration(days)*24*time.Hour, force)
+	if err != nil {
+		abort("CA initialisation", err)
+	}
+
+	utility.Success("Certificate authority created at: %s", authority.Dir)
+}
+
+func runIssueCmd(cmd *cobra.Command, args []string) {
+	readFlags(cmd)
+	authority := openAuthority()
+
+	var pub crypto.PublicKey
+	switch {
+	case csrPath != "":
+		csr := loadCSR(csrPath)
+		pub = csr.PublicKey
+		if email == "" && len(csr.EmailAddresses) > 0 {
+			email = csr.EmailAddresses[0]
+		}
+		if name == "" {
+			name = csr.Subject.CommonName
+		}
+	case pubkey != "":
+		keyBytes, err := os.ReadFile(filepath.Clean(pubkey))
+		if err != nil {
+			abort("Public key file loading", err)
+		}
+		pub, err = crypt.ParseKeyPEM(keyBytes)
+		if err != nil {
+			abort("Public key file loading", err)
+		}
+		if signer, ok := pub.(crypto.Signer); ok {
+			pub = signer.Public()
+		}
+	default:
+		utility.Error("No key given, use --pubkey <path/to/public.pem> or --csr <path/to/request.csr>")
+		os.Exit(1)
+	}
+
+	cert, err := authority.Issue(pub, email, name, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		abort("Certificate issuance", err)
+	}
+
+	if output != "" {
+		if err := crypt.WriteKeyFile(output, crypt.EncodeCertificatePEM(cert), 0644, force); err != nil {
+			abort("Certificate writing", err)
+		}
+	}
+
+	utility.Success("Issued certificate %s for %s", ca.SerialString(cert.SerialNumber), cert.EmailAddresses[0])
+}
+
+func runRevokeCmd(cmd *cobra.Command, args []string) {
+	readFlags(cmd)
+	authority := openAuthority()
+
+	entry, err := authority.Revoke(args[0])
+	if err != nil {
+		abort("Certificate revocation", err)
+	}
+
+	utility.Success("Revoked certificate %s for %s, CRL updated", entry.Serial, entry.Email)
+}
+
+func runCrlCmd(cmd *cobra.Command, args []string) {
+	readFlags(cmd)
+	authority := openAuthority()
+
+	if err := authority.WriteCRL(); err != nil {
+		abort("CRL signing", err)
+	}
+
+	utility.Success("CRL refreshed at: %s", filepath.Join(authority.Dir, "crl.pem"))
+}
+
+func runBundleCmd(cmd *cobra.Command, args []string) {
+	readFlags(cmd)
+	authority := openAuthority()
+
+	bundle, err := authority.Bundle()
+	if err != nil {
+		abort("Trust bundle export", err)
+	}
+
+	if output == "" {
+		fmt.Print(string(bundle))
+		return
+	}
+	if err := crypt.WriteKeyFile(output, bundle, 0644, force); err != nil {
+		abort("Trust bundle export", err)
+	}
+	utility.Success("Trust bundle exported to: %s", output)
+}
+
+func runListCmd(cmd *cobra.Command, args []string) {
+	readFlags(cmd)
+	authority := openAuthority()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tEMAIL\tEXPIRES\tSTATUS")
+	for _, entry := range authority.Index {
+		status := utility.Green("valid")
+		switch {
+		case entry.Revoked:
+			status = utility.Red("revoked")
+		case entry.NotAfter.Before(time.Now()):
+			status = utility.Yellow("expired")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Serial, entry.Email, entry.NotAfter.Format(time.DateOnly), status)
+	}
+	w.Flush()
+}
+
+func readFlags(cmd *cobra.Command) {
+	caDir, _ = cmd.Flags().GetString("dir")
+	subject, _ = cmd.Flags().GetString("subject")
+	email, _ = cmd.Flags().GetString("email")
+	name, _ = cmd.Flags().GetString("name")
+	pubkey, _ = cmd.Flags().GetString("pubkey")
+	csrPath, _ = cmd.Flags().GetString("csr")
+	days, _ = cmd.Flags().GetInt("days")
+	output, _ = cmd.Flags().GetString("output")
+	force, _ = cmd.Flags().GetBool("force")
+}
+
+func openAuthority() *ca.Authority {
+	authority, err := ca.Open(caDir)
+	if err != nil {
+		abort("CA loading", err)
+	}
+	return authority
+}
+
+func loadCSR(path string) *x509.CertificateRequest {
+	csrBytes, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		abort("Certificate request loading", err)
+	}
+
+	block, _ := pem.Decode(csrBytes)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		abort("Certificate request loading", fmt.Errorf("%s is not a PEM certificate request", path))
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		abort("Certificate request loading", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		abort("Certificate request loading", fmt.Errorf("invalid request signature: %w", err))
+	}
+	return csr
+}
+
+func abort(operation string, err error) {
+	utility.Error("%s", err)
+	utility.Info("Aborting operation: %s", utility.Red(operation))
+	logger.Logger.WithFields(logrus.Fields{"err": err}).Error(operation)
+	os.Exit(1)
+}
+
+func init() {
+	CACmd.PersistentFlags().StringVarP(&caDir, "dir", "", env.Vars.CA_DIR, "Directory holding the CA. [Default: CA_DIR]")
+
+	initCmd.Flags().StringVarP(&subject, "subject", "s", "CN=cryptix team CA", "Subject of the CA certificate. [Optional]")
+	initCmd.Flags().IntVarP(&days, "days", "d", 3650, "Number of days the CA certificate is valid. [Default: 3650]")
+	initCmd.Flags().BoolVarP(&force, "force", "f", false, "Replace an existing CA. [Optional]")
+
+	issueCmd.Flags().StringVarP(&email, "email", "e", "", "Email address to bind the key to, taken from the CSR when omitted.")
+	issueCmd.Flags().StringVarP(&name, "name", "n", "", "Common name of the certificate. [Default: email address]")
+	issueCmd.Flags().StringVarP(&pubkey, "pubkey", "k", "", "Public key file of the teammate.")
+	issueCmd.Flags().StringVarP(&csrPath, "csr", "", "", "Certificate request created with 'cryptix keys csr'.")
+	issueCmd.Flags().IntVarP(&days, "days", "d", 365, "Number of days the certificate is valid. [Default: 365]")
+	issueCmd.Flags().StringVarP(&output, "output", "o", "", "Also write the certificate to this file. [Optional]")
+	issueCmd.Flags().BoolVarP(&force, "force", "f", false, "Overwrite an existing output file. [Optional]")
+	issueCmd.MarkFlagsMutuallyExclusive("pubkey", "csr")
+
+	bundleCmd.Flags().StringVarP(&output, "output", "o", "", "File to write the trust bundle to. [Default: stdout]")
+	bundleCmd.Flags().BoolVarP(&force, "force", "f", false, "Overwrite an existing output file. [Optional]")
+
+	CACmd.AddCommand(initCmd, issueCmd, revokeCmd, crlCmd, bundleCmd, listCmd)
+}
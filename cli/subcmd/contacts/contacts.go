@@ -0,0 +1,172 @@
+package contacts
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/contacts"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	name   string
+	emails []string
+	pubkey string
+	trust  string
+)
+
+// ContactsCmd groups the address book subcommands.
+var ContactsCmd = &cobra.Command{
+	Use:   "contacts",
+	Short: "Address book mapping email addresses to public keys.",
+}
+
+var addCmd = &cobra.Command{
+	Use:     "add",
+	Short:   "Add a contact, or update the key and addresses of an existing one.",
+	Example: "cryptix contacts add --name Alice --email alice@corp.com --pubkey <path/to/alice.pem>\ncryptix contacts add --email alice@corp.com --pubkey <path/to/alice.pem> --trust verified",
+	Run:     runAddCmd,
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List contacts.",
+	Run:   runListCmd,
+}
+
+var removeCmd = &cobra.Command{
+	Use:     "remove <email|name>",
+	Aliases: []string{"rm"},
+	Short:   "Remove a contact.",
+	Args:    cobra.ExactArgs(1),
+	Run:     runRemoveCmd,
+}
+
+var showCmd = &cobra.Command{
+	Use:   "show <email|name>",
+	Short: "Show a contact and their public key.",
+	Args:  cobra.ExactArgs(1),
+	Run:   runShowCmd,
+}
+
+func runAddCmd(cmd *cobra.Command, args []string) {
+	name, _ = cmd.Flags().GetString("name")
+	emails, _ = cmd.Flags().GetStringSlice("email")
+	pubkey, _ = cmd.Flags().GetString("pubkey")
+	trust, _ = cmd.Flags().GetString("trust")
+
+	pubKey, err := crypt.LoadPublicKey(pubkey)
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("PubKey file loading"))
+		os.Exit(1)
+	}
+
+	store := openStore()
+	contact, previous, err := store.Add(name, emails, pubKey, strings.ToLower(trust))
+	if err != nil {
+		abort("Contact update", err)
+	}
+	if err := store.Save(); err != nil {
+		abort("Contact update", err)
+	}
+
+	if previous != "" {
+		utility.Warning("Replaced the key of %s: %s -> %s", contact.Name, previous, contact.Fingerprint)
+		logger.Logger.WithFields(logrus.Fields{
+			"contact":  contact.Name,
+			"previous": previous,
+			"current":  contact.Fingerprint,
+		}).Warn("Contact key replaced")
+	}
+	utility.Success("Contact %s saved (%s, %s)", contact.Name, contact.Fingerprint, contact.Trust)
+}
+
+func runListCmd(cmd *cobra.Command, args []string) {
+	store := openStore()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tEMAILS\tFINGERPRINT\tTRUST")
+	for _, contact := range store.Contacts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", contact.Name, strings.Join(contact.Emails, ","), contact.Fingerprint[:16], trustLabel(contact))
+	}
+	w.Flush()
+}
+
+func runRemoveCmd(cmd *cobra.Command, args []string) {
+	store := openStore()
+	contact, err := store.Remove(args[0])
+	if err != nil {
+		abort("Contact removal", err)
+	}
+	if err := store.Save(); err != nil {
+		abort("Contact removal", err)
+	}
+
+	utility.Success("Removed contact %s", contact.Name)
+}
+
+func runShowCmd(cmd *cobra.Command, args []string) {
+	store := openStore()
+	contact, err := store.Find(args[0])
+	if err != nil {
+		abort("Contact lookup", err)
+	}
+
+	fmt.Printf("Name:        %s\n", contact.Name)
+	fmt.Printf("Emails:      %s\n", strings.Join(contact.Emails, ", "))
+	fmt.Printf("Fingerprint: %s\n", contact.Fingerprint)
+	fmt.Printf("Trust:       %s\n", trustLabel(contact))
+	fmt.Printf("Added:       %s\n", contact.Added.Format(time.RFC1123))
+	if !contact.KeyChangedAt.IsZero() {
+		fmt.Printf("Key changed: %s\n", contact.KeyChangedAt.Format(time.RFC1123))
+	}
+	for _, previous := range contact.PreviousFingerprints {
+		fmt.Printf("Previous:    %s\n", previous)
+	}
+	fmt.Printf("\n%s", contact.PublicKey)
+}
+
+func trustLabel(contact *contacts.Contact) string {
+	switch {
+	case contact.KeyChanged():
+		return utility.Red("key changed")
+	case contact.Trust == contacts.TrustVerified:
+		return utility.Green(contact.Trust)
+	default:
+		return utility.Yellow(contact.Trust)
+	}
+}
+
+func openStore() *contacts.Store {
+	store, err := contacts.Open(env.Vars.CONFIG_DIR)
+	if err != nil {
+		abort("Contacts loading", err)
+	}
+	return store
+}
+
+func abort(operation string, err error) {
+	utility.Error("%s", err)
+	utility.Info("Aborting operation: %s", utility.Red(operation))
+	logger.Logger.WithFields(logrus.Fields{"err": err}).Error(operation)
+	os.Exit(1)
+}
+
+func init() {
+	addCmd.Flags().StringVarP(&name, "name", "n", "", "Name of the contact. [Default: first email address]")
+	addCmd.Flags().StringSliceVarP(&emails, "email", "e", nil, "Email address of the contact, repeat or comma separate for several. [*Required]")
+	addCmd.Flags().StringVarP(&pubkey, "pubkey", "k", "", "Public key file of the contact (PEM or JWK). [*Required]")
+	addCmd.Flags().StringVarP(&trust, "trust", "t", contacts.TrustUnverified, "Trust level: unverified, or verified once the fingerprint was confirmed. [Optional]")
+	addCmd.MarkFlagRequired("email")
+	addCmd.MarkFlagRequired("pubkey")
+
+	ContactsCmd.AddCommand(addCmd, listCmd, removeCmd, showCmd)
+}
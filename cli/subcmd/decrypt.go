@@ -4,11 +4,14 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package subcmd
 
 import (
+	"crypto/rsa"
+	"crypto/x509"
 	"os"
 	"path/filepath"
 
 	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
 	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/ca"
 	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
 	"github.com/Kshitiz-Mhto/cryptix/utility"
 	"github.com/sirupsen/logrus"
@@ -20,15 +23,20 @@ var (
 	sourcePath           string
 	outputMsgFileName    string
 	outputPath           string
+	certPath             string
 	DecryptedMsgFilePath string
 )
 
+// smimeExtension is the extension of decrypted S/MIME messages, which are MIME
+// entities rather than plain text.
+const smimeExtension = ".eml"
+
 // DecodeCmd represents the decode command.
 var DecodeCmd = &cobra.Command{
 	Use:     "decode",
 	Aliases: []string{"decrypt", "de"},
-	Short:   "Decrypt the encoded message from json file.",
-	Example: "cryptix decode --source <path/to/source_file> --name <file_name> --output <path/to/storge_dir> --prikey <path/to/private_key>",
+	Short:   "Decrypt the encoded message from json file, or an S/MIME .p7m message.",
+	Example: "cryptix decode --source <path/to/source_file> --name <file_name> --output <path/to/storge_dir> --prikey <path/to/private_key>\ncryptix decode --source smime.p7m --name message --prikey <path/to/private_key> --cert <path/to/certificate>",
 	Run:     runDecodeSecretsCmd,
 }
 
@@ -37,6 +45,7 @@ func runDecodeSecretsCmd(cmd *cobra.Command, args []string) {
 	sourcePath, _ = cmd.Flags().GetString("source")
 	outputMsgFileName, _ = cmd.Flags().GetString("name")
 	outputPath, _ = cmd.Flags().GetString("output")
+	certPath, _ = cmd.Flags().GetString("cert")
 
 	// Load private key.
 	privKey, err := crypt.LoadPrivateKey(privateKeyFilePath)
@@ -46,11 +55,18 @@ func runDecodeSecretsCmd(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	// Decrypt the hybrid-encrypted data.
-	plaintext, err := crypt.HybridDecryption(sourcePath, privKey)
-	if err != nil {
-		utility.Info("Aborting operation: %s", utility.Red("Decryption failed"))
-		os.Exit(1)
+	extension := env.Vars.TXT_FORMAT
+	var plaintext []byte
+	if smime := loadSMIMESource(sourcePath); smime != nil {
+		plaintext = decryptSMIME(smime, privKey)
+		extension = smimeExtension
+	} else {
+		// Decrypt the hybrid-encrypted data.
+		plaintext, err = crypt.HybridDecryption(sourcePath, privKey)
+		if err != nil {
+			utility.Info("Aborting operation: %s", utility.Red("Decryption failed"))
+			os.Exit(1)
+		}
 	}
 
 	absOutputPath, err := filepath.Abs(outputPath)
@@ -66,7 +82,7 @@ func runDecodeSecretsCmd(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	DecryptedMsgFilePath = filepath.Join(absOutputPath, outputMsgFileName+env.Vars.TXT_FORMAT)
+	DecryptedMsgFilePath = filepath.Join(absOutputPath, outputMsgFileName+extension)
 	if err = os.WriteFile(DecryptedMsgFilePath, plaintext, 0644); err != nil {
 		utility.Error("Failed to write output message: %v", err)
 		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Write failure")
@@ -75,11 +91,83 @@ func runDecodeSecretsCmd(cmd *cobra.Command, args []string) {
 
 }
 
+// loadSMIMESource returns the CMS structure in path when it holds an S/MIME message
+// (.p7m, PEM, or a saved mail), and nil for anything else.
+func loadSMIMESource(path string) []byte {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil
+	}
+	der, err := crypt.ExtractCMS(data)
+	if err != nil {
+		return nil
+	}
+	return der
+}
+
+// decryptSMIME opens an S/MIME message and checks the signature of a signed one.
+func decryptSMIME(der []byte, privKey *rsa.PrivateKey) []byte {
+	var cert *x509.Certificate
+	if certPath != "" {
+		var err error
+		if cert, err = crypt.LoadCertificate(certPath); err != nil {
+			utility.Info("Aborting operation: %s", utility.Red("Certificate loading"))
+			os.Exit(1)
+		}
+	}
+
+	entity, err := crypt.DecryptCMS(der, privKey, cert)
+	if err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("Decryption failed"))
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("S/MIME decryption failed")
+		os.Exit(1)
+	}
+	utility.Success("S/MIME message decrypted successfully!")
+	logger.Logger.Info("S/MIME message decrypted")
+
+	content, signature, signed, err := crypt.SignedEntity(entity)
+	if !signed {
+		return entity
+	}
+	if err == nil {
+		var signer *x509.Certificate
+		if signer, err = crypt.VerifyCMSDetached(signature, content); err == nil {
+			reportSigner(signer)
+			return entity
+		}
+	}
+	utility.Warning("The message signature does not verify: %s", err)
+	logger.Logger.WithFields(logrus.Fields{"err": err}).Warn("S/MIME signature invalid")
+	return entity
+}
+
+// reportSigner prints who signed a message and whether the team CA vouches for them.
+func reportSigner(signer *x509.Certificate) {
+	name := signer.Subject.CommonName
+	if len(signer.EmailAddresses) > 0 {
+		name = signer.EmailAddresses[0]
+	}
+
+	authority, err := ca.Open(env.Vars.CA_DIR)
+	if err == nil {
+		err = authority.Verify(signer)
+	}
+	if err != nil {
+		utility.Warning("Signature by %s is valid, but the certificate is not trusted: %s", name, err)
+		logger.Logger.WithFields(logrus.Fields{"signer": name, "err": err}).Warn("S/MIME signer not trusted")
+		return
+	}
+	utility.Success("Signature by %s is valid and certified by the team CA", name)
+	logger.Logger.WithFields(logrus.Fields{"signer": name}).Info("S/MIME signature verified")
+}
+
 func init() {
 	DecodeCmd.Flags().StringVarP(&privateKeyFilePath, "prikey", "k", "", "Specify private key file path. [*Required]")
 	DecodeCmd.Flags().StringVarP(&sourcePath, "source", "s", "", "Specify the source path file path containing encrypted data. [*Required]")
 	DecodeCmd.Flags().StringVarP(&outputMsgFileName, "name", "n", "", "Specify the filename for storing decrypted message with not extension. [*Required]")
 	DecodeCmd.Flags().StringVarP(&outputPath, "output", "o", ".", "Specify the path where you want to store file that stored decrypted message. Optional[]")
+	DecodeCmd.Flags().StringVarP(&certPath, "cert", "c", "", "Certificate of --prikey, to pick the matching recipient of an S/MIME message. [Optional]")
 
 	DecodeCmd.MarkFlagsRequiredTogether("prikey", "source", "name")
 }
@@ -4,11 +4,16 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package subcmd
 
 import (
+	"crypto/rsa"
+	"errors"
 	"os"
 
 	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
 	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/ca"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
 	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +22,7 @@ var (
 	pubkeyPath     string
 	outputFileName string
 	outputFilePath string
+	recipient      string
 )
 
 // EmbadeCmd represents the encode command
@@ -24,7 +30,7 @@ var EmbadeCmd = &cobra.Command{
 	Use:     "encrypt",
 	Aliases: []string{"encode", "en"},
 	Short:   "It helps to endcode the message and generate json file that cotain encrypted message and AES key.",
-	Example: "cryptix encode --message <message_content> --output <path/to/> --name <filename> --pubkey <path/to/public_key>",
+	Example: "cryptix encode --message <message_content> --output <path/to/> --name <filename> --pubkey <path/to/public_key>\ncryptix encode --message <message_content> --name <filename> --to alice@corp.com",
 	Run:     runEncodingSecretsCmd,
 }
 
@@ -33,13 +39,20 @@ func runEncodingSecretsCmd(cmd *cobra.Command, args []string) {
 	pubkeyPath, _ = cmd.Flags().GetString("pubkey")
 	outputFilePath, _ = cmd.Flags().GetString("output")
 	outputFileName, _ = cmd.Flags().GetString("name")
+	recipient, _ = cmd.Flags().GetString("to")
 
 	if msg == "" {
 		utility.Error("Message to be encrypted  is empty.")
 		logger.Logger.Fatal("Message to be encrypted is empty")
 	}
 
-	pubKey, err := crypt.LoadPublicKey(pubkeyPath)
+	var pubKey *rsa.PublicKey
+	var err error
+	if recipient != "" {
+		pubKey, err = ResolveRecipientKey(recipient)
+	} else {
+		pubKey, err = crypt.LoadPublicKey(pubkeyPath)
+	}
 	if err != nil {
 		utility.Info("Aborting operation process: %s", utility.Red("PubKey file loading"))
 		os.Exit(1)
@@ -60,11 +73,56 @@ func runEncodingSecretsCmd(cmd *cobra.Command, args []string) {
 	utility.Success("Encryption successful!!")
 }
 
+// ResolveCertifiedKey returns the public key of the certificate the team CA issued for
+// the email address. Keys that the CA did not certify, or has revoked, are refused.
+func ResolveCertifiedKey(email string) (*rsa.PublicKey, error) {
+	authority, err := ca.Open(env.Vars.CA_DIR)
+	if err != nil {
+		utility.Error("%s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"dir": env.Vars.CA_DIR,
+			"err": err,
+		}).Error("Failed to open certificate authority")
+		return nil, err
+	}
+
+	cert, err := authority.Lookup(email)
+	if err != nil {
+		utility.Error("%s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"email": email,
+			"err":   err,
+		}).Error("No certified key for recipient")
+		return nil, err
+	}
+
+	pubKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		utility.Error("certificate for %s does not hold an RSA encryption key", email)
+		logger.Logger.WithFields(logrus.Fields{
+			"email":  email,
+			"serial": ca.SerialString(cert.SerialNumber),
+		}).Error("Certified key is not an RSA key")
+		return nil, errors.New("certified key is not an RSA key")
+	}
+
+	utility.Success("Using key certified for %s (serial %s)", email, ca.SerialString(cert.SerialNumber))
+	logger.Logger.WithFields(logrus.Fields{
+		"email":  email,
+		"serial": ca.SerialString(cert.SerialNumber),
+	}).Info("Resolved certified recipient key")
+	return pubKey, nil
+}
+
 func init() {
 	EmbadeCmd.Flags().StringVarP(&msg, "message", "m", "", "Specify your message that will be encoded. [*Required]")
 	EmbadeCmd.Flags().StringVarP(&outputFilePath, "output", "o", ".", "Specify the directory where file will be located. [Default path: current directory]")
-	EmbadeCmd.Flags().StringVarP(&pubkeyPath, "pubkey", "k", "", "Specify your public key file path. [*Required]")
+	EmbadeCmd.Flags().StringVarP(&pubkeyPath, "pubkey", "k", "", "Specify your public key file path (PEM or JWK). [*Required unless --to]")
 	EmbadeCmd.Flags().StringVarP(&outputFileName, "name", "n", "", "Specify your output file name(dont include extension). [*Required]")
 
-	EmbadeCmd.MarkFlagsRequiredTogether("message", "pubkey", "name")
+	EmbadeCmd.Flags().StringVarP(&recipient, "to", "t", "", "Encrypt for the key of this contact or the key the team CA certified for the address. [Optional]")
+
+	EmbadeCmd.MarkFlagsRequiredTogether("message", "name")
+	EmbadeCmd.MarkFlagsOneRequired("pubkey", "to")
+	EmbadeCmd.MarkFlagsMutuallyExclusive("pubkey", "to")
 }
@@ -0,0 +1,152 @@
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd/mail"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	privateKeyFilePath string
+	digest             string
+	outPath            string
+	timeout            time.Duration
+)
+
+// FetchCmd downloads an envelope shared by 'send' and decrypts it in one step.
+var FetchCmd = &cobra.Command{
+	Use:     "fetch <link|saved.eml>",
+	Short:   "Download an uploaded envelope from its link or share email, check its SHA-256 and decrypt it.",
+	Example: "cryptix fetch share.eml --prikey <path/to/private_key> --out secret.txt\ncryptix fetch 'https://s3.example.com/bucket/cryptix/...#sha256=<hex>' --prikey <path/to/private_key>\ncryptix fetch file:///mnt/shared/envelope.json --prikey <path/to/private_key> --sha256 <hex>",
+	Args:    cobra.ExactArgs(1),
+	Run:     runFetchCmd,
+}
+
+func runFetchCmd(cmd *cobra.Command, args []string) {
+	privateKeyFilePath, _ = cmd.Flags().GetString("prikey")
+	digest, _ = cmd.Flags().GetString("sha256")
+	outPath, _ = cmd.Flags().GetString("out")
+	timeout, _ = cmd.Flags().GetDuration("timeout")
+
+	link, expected, err := resolveLink(args[0])
+	if err != nil {
+		abort("Share link", err)
+	}
+	if digest != "" {
+		expected = digest
+	}
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	if expected != "" && !mail.ValidDigest(expected) {
+		abort("SHA-256 check", fmt.Errorf("%q is not a SHA-256 in hex", expected))
+	}
+	if expected == "" {
+		utility.Warning("No SHA-256 in the link or email, the download cannot be checked before decrypting")
+	}
+
+	privKey, err := crypt.LoadPrivateKey(privateKeyFilePath)
+	if err != nil {
+		abort("Private key file loading", err)
+	}
+
+	fetcher, err := mail.NewFetcher(link)
+	if err != nil {
+		abort("Storage configuration", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	body, err := fetcher.Fetch(ctx, link)
+	if err != nil {
+		abort("Download", err)
+	}
+	defer body.Close()
+
+	// Decrypt while downloading; the hash covers every byte, including any the
+	// JSON decoder did not need.
+	hash := sha256.New()
+	tee := io.TeeReader(body, hash)
+	plaintext, decryptErr := crypt.DecryptEnvelope(tee, privKey)
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		abort("Download", err)
+	}
+
+	// A tampered or truncated download is reported as such rather than as a
+	// decryption failure, and nothing is written.
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if expected != "" && actual != expected {
+		logger.Logger.WithFields(logrus.Fields{"link": link, "expected": expected, "actual": actual}).Error("SHA-256 mismatch")
+		abort("SHA-256 check", fmt.Errorf("the download has SHA-256 %s, the share lists %s", actual, expected))
+	}
+	if decryptErr != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Decryption failed"))
+		os.Exit(1)
+	}
+	if expected != "" {
+		utility.Success("SHA-256 %s verified", actual)
+	}
+
+	if err := writePlaintext(plaintext); err != nil {
+		abort("Write failure", err)
+	}
+	logger.Logger.WithFields(logrus.Fields{"link": link, "bytes": len(plaintext)}).Info("Fetched and decrypted envelope")
+	if outPath != "" {
+		utility.Success("Decrypted message written to %s", outPath)
+	}
+}
+
+// resolveLink returns the download link and SHA-256 of arg, either a saved share
+// email or a link, whose #sha256= fragment is split off.
+func resolveLink(arg string) (string, string, error) {
+	info, err := os.Stat(filepath.Clean(arg))
+	if err == nil && !info.IsDir() {
+		data, err := os.ReadFile(filepath.Clean(arg))
+		if err != nil {
+			return "", "", err
+		}
+		return mail.ShareFromMessage(data)
+	}
+	if !strings.Contains(arg, "://") {
+		return "", "", errors.New("expected a link or a saved share email, " + arg + " is neither")
+	}
+	link, linkDigest := mail.SplitLinkDigest(arg)
+	return link, linkDigest, nil
+}
+
+// writePlaintext writes to --out, readable by the owner only, or to stdout.
+func writePlaintext(plaintext []byte) error {
+	if outPath == "" {
+		_, err := os.Stdout.Write(plaintext)
+		return err
+	}
+	return os.WriteFile(filepath.Clean(outPath), plaintext, 0600)
+}
+
+func abort(operation string, err error) {
+	utility.Error("%s", err)
+	utility.Info("Aborting operation: %s", utility.Red(operation))
+	logger.Logger.WithFields(logrus.Fields{"err": err}).Error(operation)
+	os.Exit(1)
+}
+
+func init() {
+	FetchCmd.Flags().StringVarP(&privateKeyFilePath, "prikey", "k", "", "Specify private key file path. [*Required]")
+	FetchCmd.Flags().StringVarP(&digest, "sha256", "", "", "Expected SHA-256 of the download, overriding the one in the link or email. [Optional]")
+	FetchCmd.Flags().StringVarP(&outPath, "out", "o", "", "File to write the decrypted message to. [Default: stdout]")
+	FetchCmd.Flags().DurationVarP(&timeout, "timeout", "", 30*time.Minute, "Give up the download after this long. [Default: 30m]")
+
+	FetchCmd.MarkFlagRequired("prikey")
+}
@@ -0,0 +1,128 @@
+package keys
+
+import (
+	"crypto"
+	"os"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/spf13/cobra"
+)
+
+var (
+	certSelfSigned bool
+	certKeyPath    string
+	certSubject    string
+	certEmails     []string
+	certDays       int
+	certOutput     string
+	certForce      bool
+)
+
+// CertCmd creates a certificate for a cryptix key so it can be shared through a PKI.
+var CertCmd = &cobra.Command{
+	Use:     "cert",
+	Short:   "Create a self-signed X.509 certificate for a cryptix key.",
+	Example: "cryptix keys cert --self-signed --key <path/to/private.pem> --subject \"CN=alice\" --email alice@corp.com --output alice.crt",
+	Run:     runCertCmd,
+}
+
+// CsrCmd creates a PKCS#10 certificate signing request for a cryptix key.
+var CsrCmd = &cobra.Command{
+	Use:     "csr",
+	Short:   "Create a certificate signing request for a cryptix key.",
+	Example: "cryptix keys csr --key <path/to/private.pem> --subject \"CN=alice,O=Corp\" --email alice@corp.com --output alice.csr",
+	Run:     runCsrCmd,
+}
+
+func runCertCmd(cmd *cobra.Command, args []string) {
+	certSelfSigned, _ = cmd.Flags().GetBool("self-signed")
+	readCertFlags(cmd)
+
+	if !certSelfSigned {
+		utility.Error("Only self-signed certificates can be created here, use --self-signed or request one with keys csr")
+		os.Exit(1)
+	}
+	if certDays <= 0 {
+		utility.Error("Certificate validity must be at least one day")
+		os.Exit(1)
+	}
+
+	signer, opts := loadCertInputs()
+	opts.Validity = time.Duration(certDays) * 24 * time.Hour
+
+	cert, err := crypt.NewSelfSignedCertificate(signer, opts)
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Certificate creation"))
+		os.Exit(1)
+	}
+
+	exportOutput, exportForce = defaultOutput(certOutput, "certificate.pem"), certForce
+	writeExportedFile(crypt.EncodeCertificatePEM(cert), "Certificate")
+}
+
+func runCsrCmd(cmd *cobra.Command, args []string) {
+	readCertFlags(cmd)
+
+	signer, opts := loadCertInputs()
+
+	csrPEM, err := crypt.NewCertificateRequest(signer, opts)
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Certificate request creation"))
+		os.Exit(1)
+	}
+
+	exportOutput, exportForce = defaultOutput(certOutput, "request.csr"), certForce
+	writeExportedFile(csrPEM, "Certificate request")
+}
+
+func readCertFlags(cmd *cobra.Command) {
+	certKeyPath, _ = cmd.Flags().GetString("key")
+	certSubject, _ = cmd.Flags().GetString("subject")
+	certEmails, _ = cmd.Flags().GetStringSlice("email")
+	certDays, _ = cmd.Flags().GetInt("days")
+	certOutput, _ = cmd.Flags().GetString("output")
+	certForce, _ = cmd.Flags().GetBool("force")
+}
+
+// loadCertInputs loads the key and parses the identity shared by cert and csr.
+func loadCertInputs() (crypto.Signer, crypt.CertificateOptions) {
+	subject, err := crypt.ParseSubject(certSubject)
+	if err != nil {
+		utility.Error("Invalid subject: %s", err)
+		os.Exit(1)
+	}
+
+	signer, err := crypt.LoadSigner(certKeyPath)
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Private key file loading"))
+		os.Exit(1)
+	}
+
+	return signer, crypt.CertificateOptions{
+		Subject:        subject,
+		EmailAddresses: certEmails,
+	}
+}
+
+func defaultOutput(output, fallback string) string {
+	if output == "" {
+		return fallback
+	}
+	return output
+}
+
+func init() {
+	CertCmd.Flags().BoolVarP(&certSelfSigned, "self-signed", "", false, "Create a self-signed certificate. [*Required]")
+	CertCmd.Flags().IntVarP(&certDays, "days", "d", 365, "Number of days the certificate is valid. [Default: 365]")
+
+	for _, c := range []*cobra.Command{CertCmd, CsrCmd} {
+		c.Flags().StringVarP(&certKeyPath, "key", "k", "private.pem", "Specify the private key generated by gen. [Default: private.pem]")
+		c.Flags().StringVarP(&certSubject, "subject", "s", "", "Specify the subject, e.g. \"CN=alice,O=Corp\". [*Required]")
+		c.Flags().StringSliceVarP(&certEmails, "email", "e", nil, "Email address to bind to the key, may be repeated. [Optional]")
+		c.Flags().StringVarP(&certOutput, "output", "o", "", "Specify the output file. [Optional]")
+		c.Flags().BoolVarP(&certForce, "force", "f", false, "Overwrite an existing output file. [Optional]")
+		c.MarkFlagRequired("subject")
+	}
+}
@@ -0,0 +1,212 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportFormat     string
+	exportP12        bool
+	exportPrivate    bool
+	exportKid        string
+	exportKeyPath    string
+	exportCertPath   string
+	exportCommonName string
+	exportPassword   string
+	exportOutput     string
+	exportLegacy     bool
+	exportForce      bool
+)
+
+// ExportKeyCmd converts a cryptix key pair into a format other tools can consume.
+var ExportKeyCmd = &cobra.Command{
+	Use:     "export",
+	Short:   "Export a key pair as a PKCS#12 (.p12/.pfx) bundle, a JWK or a JWKS document.",
+	Example: "cryptix keys export --p12 --key <path/to/private.pem> --password <password> --output <path/to/bundle.p12>\ncryptix keys export --format jwks --key <path/to/private.pem> --output <path/to/jwks.json>",
+	Run:     runExportKeyCmd,
+}
+
+func runExportKeyCmd(cmd *cobra.Command, args []string) {
+	exportFormat, _ = cmd.Flags().GetString("format")
+	exportP12, _ = cmd.Flags().GetBool("p12")
+	exportPrivate, _ = cmd.Flags().GetBool("private")
+	exportKid, _ = cmd.Flags().GetString("kid")
+	exportKeyPath, _ = cmd.Flags().GetString("key")
+	exportCertPath, _ = cmd.Flags().GetString("cert")
+	exportCommonName, _ = cmd.Flags().GetString("cn")
+	exportPassword, _ = cmd.Flags().GetString("password")
+	exportOutput, _ = cmd.Flags().GetString("output")
+	exportLegacy, _ = cmd.Flags().GetBool("legacy")
+	exportForce, _ = cmd.Flags().GetBool("force")
+
+	if exportP12 {
+		exportFormat = "p12"
+	}
+
+	var data []byte
+	switch exportFormat {
+	case "p12":
+		privKey, err := crypt.LoadPrivateKey(exportKeyPath)
+		if err != nil {
+			utility.Error("%s", err)
+			utility.Info("Aborting operation: %s", utility.Red("Private key file loading"))
+			os.Exit(1)
+		}
+		data = exportPKCS12(privKey)
+		if exportOutput == "" {
+			exportOutput = "cryptix.p12"
+		}
+	case "jwk", "jwks":
+		data = exportJWK()
+		if exportOutput == "" {
+			exportOutput = "cryptix." + exportFormat
+		}
+	default:
+		utility.Error("Unsupported export format %q, use p12, jwk or jwks", exportFormat)
+		os.Exit(1)
+	}
+
+	writeExportedFile(data, "Key")
+}
+
+// exportJWK converts the key into a JWK, or a JWKS holding that single key. Only the
+// public half is exported unless --private is given, as JWKS endpoints publish public keys.
+func exportJWK() []byte {
+	keyBytes, err := os.ReadFile(filepath.Clean(exportKeyPath))
+	if err != nil {
+		utility.Error("Failed to read key file: %s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"file": exportKeyPath,
+			"err":  err,
+		}).Error("Failed to read key file")
+		os.Exit(1)
+	}
+
+	key, err := crypt.ParseKeyPEM(keyBytes)
+	if err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("Key file loading"))
+		os.Exit(1)
+	}
+
+	if signer, ok := key.(crypto.Signer); ok && !exportPrivate {
+		key = signer.Public()
+	}
+
+	jwk, err := crypt.NewJWK(key, exportKid)
+	if err != nil {
+		utility.Error("failed to convert key to JWK: %v", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to convert key to JWK")
+		os.Exit(1)
+	}
+
+	var document interface{} = jwk
+	if exportFormat == "jwks" {
+		document = crypt.JWKS{Keys: []crypt.JWK{*jwk}}
+	}
+
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		utility.Error("failed to marshal JWK: %v", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Marshal JWK")
+		os.Exit(1)
+	}
+	return append(data, '\n')
+}
+
+// exportPKCS12 bundles the private key with its certificate. A PKCS#12 bundle must carry
+// a certificate, so one is self-signed for keys created by gen when --cert is not given.
+func exportPKCS12(privKey *rsa.PrivateKey) []byte {
+	var cert *x509.Certificate
+	var err error
+
+	if exportCertPath != "" {
+		cert, err = crypt.LoadCertificate(exportCertPath)
+		if err != nil {
+			utility.Info("Aborting operation: %s", utility.Red("Certificate loading"))
+			os.Exit(1)
+		}
+		certKey, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok || !certKey.Equal(&privKey.PublicKey) {
+			utility.Error("Certificate %s does not match the private key", exportCertPath)
+			logger.Logger.WithFields(logrus.Fields{
+				"cert": exportCertPath,
+				"key":  exportKeyPath,
+			}).Error("Certificate does not match the private key")
+			os.Exit(1)
+		}
+	} else {
+		cert, err = crypt.NewSelfSignedCertificate(privKey, crypt.CertificateOptions{
+			Subject:  pkix.Name{CommonName: exportCommonName},
+			Validity: 365 * 24 * time.Hour,
+		})
+		if err != nil {
+			utility.Info("Aborting operation: %s", utility.Red("Certificate creation"))
+			os.Exit(1)
+		}
+	}
+
+	data, err := crypt.ExportPKCS12(privKey, cert, nil, exportPassword, exportLegacy)
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("PKCS#12 encoding"))
+		os.Exit(1)
+	}
+	return data
+}
+
+// writeExportedFile stores exported key material, certificates or requests at the output path.
+func writeExportedFile(data []byte, label string) {
+	absolutePath, err := filepath.Abs(exportOutput)
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Absolute path retrieval"))
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Absolute path retrieval")
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(absolutePath), 0700); err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Directory creation"))
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Directory creation")
+		os.Exit(1)
+	}
+
+	if err := crypt.WriteKeyFile(absolutePath, data, 0600, exportForce); err != nil {
+		utility.Error("failed to write %s: %v", label, err)
+		logger.Logger.WithFields(logrus.Fields{
+			"path": absolutePath,
+			"err":  err,
+		}).Errorf("failed to write %s", label)
+		os.Exit(1)
+	}
+
+	utility.Success("%s exported successfully! at path: %s", label, absolutePath)
+	logger.Logger.WithFields(logrus.Fields{
+		"path": absolutePath,
+	}).Infof("%s exported successfully!", label)
+}
+
+func init() {
+	ExportKeyCmd.Flags().StringVarP(&exportFormat, "format", "F", "p12", "Export format: p12, jwk or jwks. [Default: p12]")
+	ExportKeyCmd.Flags().BoolVarP(&exportP12, "p12", "", false, "Shorthand for --format p12.")
+	ExportKeyCmd.Flags().BoolVarP(&exportPrivate, "private", "", false, "Include private key members in JWK output. [Optional]")
+	ExportKeyCmd.Flags().StringVarP(&exportKid, "kid", "", "", "Key ID for JWK output, defaults to the RFC 7638 thumbprint. [Optional]")
+	ExportKeyCmd.Flags().StringVarP(&exportKeyPath, "key", "k", "private.pem", "Specify the key file to export, a public key is enough for JWK output. [Default: private.pem]")
+	ExportKeyCmd.Flags().StringVarP(&exportCertPath, "cert", "c", "", "Specify a certificate for the key, a self-signed one is created otherwise. [Optional]")
+	ExportKeyCmd.Flags().StringVarP(&exportCommonName, "cn", "", "cryptix", "Common name of the self-signed certificate. [Optional]")
+	ExportKeyCmd.Flags().StringVarP(&exportPassword, "password", "p", "", "Specify the password protecting the bundle. [Optional]")
+	ExportKeyCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Specify the file the exported key is written to. [Default: cryptix.<format>]")
+	ExportKeyCmd.Flags().BoolVarP(&exportLegacy, "legacy", "", false, "Use legacy 3DES encryption for older Windows versions. [Optional]")
+	ExportKeyCmd.Flags().BoolVarP(&exportForce, "force", "f", false, "Overwrite an existing output file. [Optional]")
+}
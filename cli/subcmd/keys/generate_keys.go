@@ -1,37 +1,137 @@
 package keys
 
 import (
+	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
 	"github.com/Kshitiz-Mhto/cryptix/utility"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
-var path string
+var (
+	path      string
+	keyType   string
+	keyBits   int
+	keyName   string
+	keyFormat string
+	keyForce  bool
+)
+
+// KeyGenOptions describes the key pair produced by GenerateKeys.
+type KeyGenOptions struct {
+	// Path is the directory the key files are written to.
+	Path string
+	// Type is the key algorithm, "rsa" or "ed25519".
+	Type string
+	// Bits is the RSA modulus size. It is ignored for ed25519.
+	Bits int
+	// Name selects <name>.key/<name>.pub instead of private.pem/public.pem.
+	Name string
+	// Format is "pkcs1" or "pkcs8". PKCS#8 writes the public key as SPKI.
+	Format string
+	// Force allows existing key files to be overwritten.
+	Force bool
+}
 
 var GenerateKeyCmd = &cobra.Command{
 	Use:     "gen",
 	Aliases: []string{"generate-keys", "gen-key"},
-	Short:   "Generates RSA key pair for encryption and decryption.",
+	Short:   "Generates an RSA (or Ed25519) key pair for encryption and decryption.",
+	Example: "cryptix gen --type rsa --bits 4096 --name alice --path <path/to/keys_dir>",
 	Run:     runKeyGenerationCmd,
 }
 
 func runKeyGenerationCmd(cmd *cobra.Command, args []string) {
 	path, _ = cmd.Flags().GetString("path")
-	GenerateRSAKeys(path)
+	keyType, _ = cmd.Flags().GetString("type")
+	keyBits, _ = cmd.Flags().GetInt("bits")
+	keyName, _ = cmd.Flags().GetString("name")
+	keyFormat, _ = cmd.Flags().GetString("format")
+	keyForce, _ = cmd.Flags().GetBool("force")
+	if keyType == "ed25519" && !cmd.Flags().Changed("format") {
+		// Ed25519 keys have no PKCS#1 encoding.
+		keyFormat = "pkcs8"
+	}
+
+	opts := KeyGenOptions{
+		Path:   path,
+		Type:   keyType,
+		Bits:   keyBits,
+		Name:   keyName,
+		Format: keyFormat,
+		Force:  keyForce,
+	}
+	if err := opts.validate(); err != nil {
+		utility.Error("%s", err)
+		os.Exit(1)
+	}
+
+	GenerateKeys(opts)
 }
 
+func (o *KeyGenOptions) validate() error {
+	switch o.Type {
+	case "rsa":
+		switch o.Bits {
+		case 2048, 3072, 4096:
+		default:
+			return fmt.Errorf("unsupported RSA key size %d, use 2048, 3072 or 4096", o.Bits)
+		}
+	case "ed25519":
+		if o.Format == "pkcs1" {
+			return fmt.Errorf("ed25519 keys have no PKCS#1 encoding, use --format pkcs8")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %q, use rsa or ed25519", o.Type)
+	}
+
+	if o.Format != "pkcs1" && o.Format != "pkcs8" {
+		return fmt.Errorf("unsupported key format %q, use pkcs1 or pkcs8", o.Format)
+	}
+
+	if o.Name != "" && filepath.Base(o.Name) != o.Name {
+		return fmt.Errorf("invalid key name %q: must not contain a path", o.Name)
+	}
+	return nil
+}
+
+// KeyFileNames returns the private and public key file names for the options.
+func (o KeyGenOptions) KeyFileNames() (string, string) {
+	if o.Name == "" {
+		return "private.pem", "public.pem"
+	}
+	return o.Name + ".key", o.Name + ".pub"
+}
+
+// GenerateRSAKeys writes a 2048-bit RSA key pair in PKCS#1 to private.pem and
+// public.pem in path, replacing existing files as it always has.
 func GenerateRSAKeys(path string) {
-	logger.Logger.Info("RSA keys generation process started")
+	GenerateKeys(KeyGenOptions{
+		Path:   path,
+		Type:   "rsa",
+		Bits:   2048,
+		Format: "pkcs1",
+		Force:  true,
+	})
+}
+
+// GenerateKeys creates a key pair as described by opts and writes it to opts.Path.
+// The private key is written with 0600 permissions.
+func GenerateKeys(opts KeyGenOptions) {
+	logger.Logger.WithFields(logrus.Fields{
+		"type": opts.Type,
+		"bits": opts.Bits,
+	}).Info("Key generation process started")
 
-	absolutePath, err := filepath.Abs(path)
+	absolutePath, err := filepath.Abs(opts.Path)
 	if err != nil {
 		utility.Error("failed to get absolute path: %v", err)
 		logger.Logger.WithFields(logrus.Fields{
@@ -42,40 +142,54 @@ func GenerateRSAKeys(path string) {
 
 	// Ensure the directory exists
 	if err := os.MkdirAll(absolutePath, 0700); err != nil {
-		utility.Error("failed to create directory %s: %v", path, err)
+		utility.Error("failed to create directory %s: %v", opts.Path, err)
 		logger.Logger.WithFields(logrus.Fields{
 			"path": absolutePath,
 			"err":  err,
 		}).Fatal("failed to create directory")
 	}
 
-	// Generate 2048-bit RSA key pair
-	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	privName, pubName := opts.KeyFileNames()
+	privPath := filepath.Join(absolutePath, privName)
+	pubPath := filepath.Join(absolutePath, pubName)
+
+	// Refuse before generating anything so an existing pair is never half replaced.
+	if !opts.Force {
+		for _, p := range []string{privPath, pubPath} {
+			if _, err := os.Stat(p); err == nil {
+				utility.Error("%s already exists (use --force to overwrite)", p)
+				logger.Logger.WithFields(logrus.Fields{
+					"path": p,
+				}).Error("key file already exists")
+				os.Exit(1)
+			}
+		}
+	}
+
+	var privKey crypto.Signer
+	switch opts.Type {
+	case "ed25519":
+		_, privKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		privKey, err = rsa.GenerateKey(rand.Reader, opts.Bits)
+	}
 	if err != nil {
-		utility.Error("failed to generate RSA key: %v", err)
+		utility.Error("failed to generate %s key: %v", opts.Type, err)
 		logger.Logger.WithFields(logrus.Fields{
 			"err": err,
-		}).Fatal("failed to generate RSA key")
+		}).Fatal("failed to generate key")
 	}
-	pubKey := &privKey.PublicKey
 
-	// Save private key
-	privKeyBytes := x509.MarshalPKCS1PrivateKey(privKey)
-	privKeyPEM := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: privKeyBytes,
-	}
-	privPath := filepath.Join(absolutePath, "private.pem")
-	privFile, err := os.Create(privPath)
+	privPEM, pubPEM, err := encodeKeyPair(privKey, opts.Format)
 	if err != nil {
-		utility.Error("failed to create private key file: %v", err)
+		utility.Error("failed to encode key pair: %v", err)
 		logger.Logger.WithFields(logrus.Fields{
 			"err": err,
-		}).Fatal("failed to create private key file")
+		}).Fatal("failed to encode key pair")
 	}
-	defer privFile.Close()
 
-	if err := pem.Encode(privFile, privKeyPEM); err != nil {
+	// Save private key
+	if err := crypt.WriteKeyFile(privPath, privPEM, 0600, opts.Force); err != nil {
 		utility.Error("failed to write private key: %v", err)
 		logger.Logger.WithFields(logrus.Fields{
 			"err": err,
@@ -83,34 +197,43 @@ func GenerateRSAKeys(path string) {
 	}
 
 	// Save public key
-	pubKeyBytes := x509.MarshalPKCS1PublicKey(pubKey)
-	pubKeyPEM := &pem.Block{
-		Type:  "RSA PUBLIC KEY",
-		Bytes: pubKeyBytes,
-	}
-	pubPath := filepath.Join(absolutePath, "public.pem")
-	pubFile, err := os.Create(pubPath)
-	if err != nil {
-		utility.Error("failed to create public key file: %v", err)
-		logger.Logger.WithFields(logrus.Fields{
-			"err": err,
-		}).Fatal("failed to create public key file")
-	}
-	defer pubFile.Close()
-
-	if err := pem.Encode(pubFile, pubKeyPEM); err != nil {
+	if err := crypt.WriteKeyFile(pubPath, pubPEM, 0644, opts.Force); err != nil {
 		utility.Error("failed to write public key: %v", err)
 		logger.Logger.WithFields(logrus.Fields{
 			"err": err,
 		}).Fatal("failed to write public key")
 	}
 
-	utility.Success("RSA key pair generated successfully! at path: %s", absolutePath)
+	utility.Success("Key pair generated successfully! at path: %s", absolutePath)
 	logger.Logger.WithFields(logrus.Fields{
-		"path": absolutePath,
-	}).Info("RSA key pair generated successfully!")
+		"path":    absolutePath,
+		"private": privName,
+		"public":  pubName,
+	}).Info("Key pair generated successfully!")
+}
+
+// encodeKeyPair PEM encodes the key pair as PKCS#1 or as PKCS#8 with an SPKI public key.
+func encodeKeyPair(privKey crypto.Signer, format string) ([]byte, []byte, error) {
+	if rsaKey, ok := privKey.(*rsa.PrivateKey); ok && format == "pkcs1" {
+		return crypt.EncodePrivateKeyPEM(rsaKey), crypt.EncodePublicKeyPEM(&rsaKey.PublicKey), nil
+	}
+
+	privPEM, err := crypt.EncodePKCS8PrivateKeyPEM(privKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubPEM, err := crypt.EncodeSPKIPublicKeyPEM(privKey.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	return privPEM, pubPEM, nil
 }
 
 func init() {
-	GenerateKeyCmd.Flags().StringVarP(&path, "path", "o", ".", "Path where RSA keys-pairs will be created. [Default path: current directory]")
+	GenerateKeyCmd.Flags().StringVarP(&path, "path", "o", ".", "Path where key pairs will be created. [Default path: current directory]")
+	GenerateKeyCmd.Flags().StringVarP(&keyType, "type", "t", "rsa", "Key algorithm: rsa or ed25519. Only rsa keys can encrypt. [Default: rsa]")
+	GenerateKeyCmd.Flags().IntVarP(&keyBits, "bits", "b", 2048, "RSA key size: 2048, 3072 or 4096. [Default: 2048]")
+	GenerateKeyCmd.Flags().StringVarP(&keyName, "name", "n", "", "Write <name>.key/<name>.pub instead of private.pem/public.pem. [Optional]")
+	GenerateKeyCmd.Flags().StringVarP(&keyFormat, "format", "F", "pkcs1", "Key encoding: pkcs1, or pkcs8 with an SPKI public key. [Default: pkcs1, pkcs8 for ed25519]")
+	GenerateKeyCmd.Flags().BoolVarP(&keyForce, "force", "f", false, "Overwrite existing key files. [Optional]")
 }
@@ -0,0 +1,219 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importP12Path  string
+	importJWKPath  string
+	importKid      string
+	importPassword string
+	importOutput   string
+	importForce    bool
+)
+
+// ImportKeyCmd converts a key bundle from another tool into cryptix's PEM key files.
+var ImportKeyCmd = &cobra.Command{
+	Use:     "import",
+	Short:   "Import a key pair from a PKCS#12 (.p12/.pfx) bundle or a JWK/JWKS document.",
+	Example: "cryptix keys import --p12 <path/to/bundle.p12> --password <password> --output <path/to/keys_dir>\ncryptix keys import --jwk <path/to/jwks.json> --kid <key_id> --output <path/to/keys_dir>",
+	Run:     runImportKeyCmd,
+}
+
+func runImportKeyCmd(cmd *cobra.Command, args []string) {
+	importP12Path, _ = cmd.Flags().GetString("p12")
+	importJWKPath, _ = cmd.Flags().GetString("jwk")
+	importKid, _ = cmd.Flags().GetString("kid")
+	importPassword, _ = cmd.Flags().GetString("password")
+	importOutput, _ = cmd.Flags().GetString("output")
+	importForce, _ = cmd.Flags().GetBool("force")
+
+	switch {
+	case importP12Path != "":
+		importPKCS12()
+	case importJWKPath != "":
+		importJWK()
+	default:
+		utility.Error("No key source given, use --p12 <path/to/bundle.p12> or --jwk <path/to/key.jwk>")
+		os.Exit(1)
+	}
+}
+
+func importPKCS12() {
+	data, err := os.ReadFile(filepath.Clean(importP12Path))
+	if err != nil {
+		utility.Error("Failed to read PKCS#12 bundle: %s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"file": importP12Path,
+			"err":  err,
+		}).Error("Failed to read PKCS#12 bundle")
+		os.Exit(1)
+	}
+
+	privKey, cert, caCerts, err := crypt.ImportPKCS12(data, importPassword)
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("PKCS#12 decoding"))
+		os.Exit(1)
+	}
+
+	certPEM := crypt.EncodeCertificatePEM(cert)
+	for _, caCert := range caCerts {
+		certPEM = append(certPEM, crypt.EncodeCertificatePEM(caCert)...)
+	}
+
+	writeImportedKeys([]keyFile{
+		{name: "private.pem", data: crypt.EncodePrivateKeyPEM(privKey), perm: 0600},
+		{name: "public.pem", data: crypt.EncodePublicKeyPEM(&privKey.PublicKey), perm: 0644},
+		{name: "certificate.pem", data: certPEM, perm: 0644},
+	})
+}
+
+func importJWK() {
+	data, err := os.ReadFile(filepath.Clean(importJWKPath))
+	if err != nil {
+		utility.Error("Failed to read JWK file: %s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"file": importJWKPath,
+			"err":  err,
+		}).Error("Failed to read JWK file")
+		os.Exit(1)
+	}
+
+	jwk, err := crypt.ParseJWK(data, importKid)
+	if err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("JWK parsing"))
+		os.Exit(1)
+	}
+
+	key, err := jwk.Key()
+	if err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("JWK key conversion"))
+		os.Exit(1)
+	}
+
+	files, err := keyFilesFor(key)
+	if err != nil {
+		utility.Error("failed to encode imported key: %v", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"kid": jwk.Kid,
+			"err": err,
+		}).Error("failed to encode imported key")
+		os.Exit(1)
+	}
+
+	logger.Logger.WithFields(logrus.Fields{
+		"kid": jwk.Kid,
+		"kty": jwk.Kty,
+	}).Info("JWK decoded")
+	writeImportedKeys(files)
+}
+
+// keyFilesFor encodes an imported key the way gen writes keys: PKCS#1 for RSA, and
+// PKCS#8/SPKI for key types PKCS#1 cannot represent. Public keys yield only public.pem.
+func keyFilesFor(key interface{}) ([]keyFile, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return []keyFile{
+			{name: "private.pem", data: crypt.EncodePrivateKeyPEM(k), perm: 0600},
+			{name: "public.pem", data: crypt.EncodePublicKeyPEM(&k.PublicKey), perm: 0644},
+		}, nil
+	case *rsa.PublicKey:
+		return []keyFile{
+			{name: "public.pem", data: crypt.EncodePublicKeyPEM(k), perm: 0644},
+		}, nil
+	case crypto.Signer:
+		privPEM, err := crypt.EncodePKCS8PrivateKeyPEM(k)
+		if err != nil {
+			return nil, err
+		}
+		pubPEM, err := crypt.EncodeSPKIPublicKeyPEM(k.Public())
+		if err != nil {
+			return nil, err
+		}
+		return []keyFile{
+			{name: "private.pem", data: privPEM, perm: 0600},
+			{name: "public.pem", data: pubPEM, perm: 0644},
+		}, nil
+	default:
+		pubPEM, err := crypt.EncodeSPKIPublicKeyPEM(k)
+		if err != nil {
+			return nil, err
+		}
+		return []keyFile{
+			{name: "public.pem", data: pubPEM, perm: 0644},
+		}, nil
+	}
+}
+
+// keyFile is a single file produced by an import.
+type keyFile struct {
+	name string
+	data []byte
+	perm os.FileMode
+}
+
+// writeImportedKeys stores the converted key material in the output directory.
+// Existing files are checked up front so a refused import leaves nothing half written.
+func writeImportedKeys(files []keyFile) {
+	absolutePath, err := filepath.Abs(importOutput)
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Absolute path retrieval"))
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Absolute path retrieval")
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(absolutePath, 0700); err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Directory creation"))
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Directory creation")
+		os.Exit(1)
+	}
+
+	if !importForce {
+		for _, file := range files {
+			if _, err := os.Stat(filepath.Join(absolutePath, file.name)); err == nil {
+				utility.Error("%s already exists in %s (use --force to overwrite)", file.name, absolutePath)
+				os.Exit(1)
+			}
+		}
+	}
+
+	for _, file := range files {
+		path := filepath.Join(absolutePath, file.name)
+		if err := crypt.WriteKeyFile(path, file.data, file.perm, importForce); err != nil {
+			utility.Error("failed to write %s: %v", file.name, err)
+			logger.Logger.WithFields(logrus.Fields{
+				"path": path,
+				"err":  err,
+			}).Error("failed to write imported key file")
+			os.Exit(1)
+		}
+	}
+
+	utility.Success("Key pair imported successfully! at path: %s", absolutePath)
+	logger.Logger.WithFields(logrus.Fields{
+		"path": absolutePath,
+	}).Info("Key pair imported successfully!")
+}
+
+func init() {
+	ImportKeyCmd.Flags().StringVarP(&importP12Path, "p12", "", "", "Specify the PKCS#12 (.p12/.pfx) bundle to import.")
+	ImportKeyCmd.Flags().StringVarP(&importJWKPath, "jwk", "", "", "Specify the JWK or JWKS document to import.")
+	ImportKeyCmd.Flags().StringVarP(&importKid, "kid", "", "", "Select the key with this key ID from a JWKS document. [Optional]")
+	ImportKeyCmd.Flags().StringVarP(&importPassword, "password", "p", "", "Specify the password protecting the PKCS#12 bundle. [Optional]")
+	ImportKeyCmd.Flags().StringVarP(&importOutput, "output", "o", ".", "Specify the directory where imported keys will be stored. [Default path: current directory]")
+	ImportKeyCmd.Flags().BoolVarP(&importForce, "force", "f", false, "Overwrite existing key files. [Optional]")
+
+	ImportKeyCmd.MarkFlagsMutuallyExclusive("p12", "jwk")
+}
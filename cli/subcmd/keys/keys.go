@@ -0,0 +1,16 @@
+package keys
+
+import "github.com/spf13/cobra"
+
+// KeysCmd groups the key management subcommands.
+var KeysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Import, export and manage cryptix key pairs.",
+}
+
+func init() {
+	KeysCmd.AddCommand(ImportKeyCmd)
+	KeysCmd.AddCommand(ExportKeyCmd)
+	KeysCmd.AddCommand(CertCmd)
+	KeysCmd.AddCommand(CsrCmd)
+}
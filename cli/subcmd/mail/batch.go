@@ -0,0 +1,432 @@
+package mail
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+)
+
+// Columns of the batch CSV with a special meaning. Every other column is passed
+// to the templates as a variable named after its header.
+const (
+	columnEmail   = "email"
+	columnPubkey  = "pubkey"
+	columnMessage = "message"
+	columnFile    = "file"
+)
+
+var resultsHeader = []string{"row_id", "email", "status", "message_id", "attempted_at", "error"}
+
+// batchRow is one recipient of a batch send.
+type batchRow struct {
+	Line   int
+	ID     string
+	Email  string
+	Fields map[string]string
+}
+
+// batchResult is the outcome of one row, appended to the results file.
+type batchResult struct {
+	Row       *batchRow
+	Status    string
+	MessageID string
+	Err       error
+}
+
+// sendBatch encrypts each row's payload for that row's recipient and sends the
+// messages with a pool of workers, one message per interval at most, or without
+// limit when interval is zero. Outcomes are appended to resultsPath; rows already
+// recorded as sent or queued there are skipped, so an interrupted batch can be
+// rerun. Messages that fail temporarily are queued in the outbox. It reports
+// whether every row was delivered.
+func sendBatch(transport Transport, tmpl *EmailTemplate, csvPath, resultsPath string, workers int, interval time.Duration) bool {
+	rows, columns, err := readBatchRows(csvPath)
+	if err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("Batch file loading"))
+		logger.Logger.WithFields(logrus.Fields{"file": csvPath, "err": err}).Error("Batch file loading")
+		return false
+	}
+	// Every column but the payload and key columns is a template variable.
+	var columnVars []string
+	for _, column := range columns {
+		switch column {
+		case columnPubkey, columnMessage, columnFile:
+		default:
+			columnVars = append(columnVars, column)
+		}
+	}
+	if err := tmpl.Validate(columnVars...); err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("Template validation"))
+		return false
+	}
+
+	if resultsPath == "" {
+		resultsPath = csvPath + ".results.csv"
+	}
+	delivered, err := readDeliveredRows(resultsPath)
+	if err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("Results file loading"))
+		return false
+	}
+	results, err := openResults(resultsPath)
+	if err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("Results file loading"))
+		return false
+	}
+	defer results.Close()
+
+	// The --message/--file payload is only needed by rows that carry none of their own.
+	var defaultPayload []byte
+	if message != "" || messageFile != "" {
+		defaultPayload = readPlaintext()
+	}
+
+	pending := make([]*batchRow, 0, len(rows))
+	for _, row := range rows {
+		if delivered[row.ID] {
+			continue
+		}
+		pending = append(pending, row)
+	}
+	skipped := len(rows) - len(pending)
+	if skipped > 0 {
+		utility.Info("Skipping %d rows already delivered or queued according to %s", skipped, resultsPath)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	var throttle <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	keys := &keyCache{keys: map[string]*rsa.PublicKey{}}
+	jobs := make(chan *batchRow)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sent, queued, failed := 0, 0, 0
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range jobs {
+				if throttle != nil {
+					<-throttle
+				}
+				result := sendBatchRow(transport, tmpl, row, keys, defaultPayload)
+
+				mu.Lock()
+				switch result.Status {
+				case statusSent:
+					sent++
+				case statusQueued:
+					queued++
+					utility.Warning("Row %d (%s): %s", row.Line, row.Email, result.Err)
+				default:
+					failed++
+					utility.Error("Row %d (%s): %s", row.Line, row.Email, result.Err)
+				}
+				if err := results.Record(result); err != nil {
+					utility.Error("Failed to record result of row %d: %s", row.Line, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, row := range pending {
+		jobs <- row
+	}
+	close(jobs)
+	wg.Wait()
+
+	logger.Logger.WithFields(logrus.Fields{
+		"file":    csvPath,
+		"sent":    sent,
+		"queued":  queued,
+		"failed":  failed,
+		"skipped": skipped,
+	}).Info("Batch send finished")
+	utility.Info("Batch finished: %s sent, %s queued, %s failed, %d already delivered or queued. Results in %s",
+		utility.Green(fmt.Sprint(sent)), utility.Yellow(fmt.Sprint(queued)), utility.Red(fmt.Sprint(failed)), skipped, resultsPath)
+	return queued == 0 && failed == 0
+}
+
+// rateInterval turns --rate, in messages per second, into the interval between
+// messages. Zero means no limit; rates too high for a non-zero interval are refused.
+func rateInterval(rate float64) (time.Duration, error) {
+	if !(rate >= 0) {
+		return 0, fmt.Errorf("invalid --rate %v, give messages per second or 0 for no limit", rate)
+	}
+	if rate == 0 {
+		return 0, nil
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	if interval <= 0 {
+		return 0, fmt.Errorf("--rate %v is too high, use at most %d messages per second or 0 for no limit", rate, int64(time.Second))
+	}
+	return interval, nil
+}
+
+// sendBatchRow encrypts and sends one row.
+func sendBatchRow(transport Transport, tmpl *EmailTemplate, row *batchRow, keys *keyCache, defaultPayload []byte) *batchResult {
+	result := &batchResult{Row: row, Status: statusFailed}
+
+	payload := defaultPayload
+	switch {
+	case row.Fields[columnMessage] != "":
+		payload = []byte(row.Fields[columnMessage])
+	case row.Fields[columnFile] != "":
+		data, err := os.ReadFile(filepath.Clean(row.Fields[columnFile]))
+		if err != nil {
+			result.Err = fmt.Errorf("failed to read payload: %w", err)
+			return result
+		}
+		payload = data
+	}
+	if len(payload) == 0 {
+		result.Err = errors.New("no payload, add a message or file column or pass --message/--file")
+		return result
+	}
+
+	pubKey, err := keys.get(row.Email, row.Fields[columnPubkey])
+	if err != nil {
+		result.Err = fmt.Errorf("no key: %w", err)
+		return result
+	}
+
+	encryptedMsg, encryptedAESKey, err := crypt.HybridEncryption(payload, pubKey)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	envelope, err := crypt.MarshalEnvelope(encryptedMsg, encryptedAESKey)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	msg := NewMessage(SenderAddress())
+	msg.DKIM = dkimSigner
+	msg.Subject = subject
+	msg.ReplyTo = replyTo
+	msg.To = []string{row.Email}
+
+	fileName := envelopeName + env.Vars.JSON_FORMAT
+	vars, err := attachOrUpload(msg, fileName, envelope)
+	if err != nil {
+		result.Err = fmt.Errorf("upload failed: %w", err)
+		return result
+	}
+	for column, value := range row.Fields {
+		switch column {
+		case columnPubkey, columnMessage, columnFile:
+			// Never expose key paths or the plaintext payload to the templates.
+		default:
+			vars[column] = value
+		}
+	}
+
+	if err := tmpl.Render(msg, vars); err != nil {
+		result.Err = err
+		return result
+	}
+	if err := sendHtmlEmailWithRetry(transport, msg, 3, 2*time.Second); err != nil {
+		result.Err = err
+		if entry := spoolUndelivered(transport, msg, err); entry != nil {
+			result.Status, result.Err = statusQueued, fmt.Errorf("in outbox as %s: %w", entry.ID, err)
+			result.MessageID = msg.MessageID
+		}
+		return result
+	}
+
+	result.Status = statusSent
+	result.MessageID = msg.MessageID
+	return result
+}
+
+// keyCache resolves each recipient's key once, as rows may share keys.
+type keyCache struct {
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+func (c *keyCache) get(email, pubkeyPath string) (*rsa.PublicKey, error) {
+	cacheKey := "email:" + strings.ToLower(email)
+	if pubkeyPath != "" {
+		cacheKey = "path:" + pubkeyPath
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[cacheKey]; ok {
+		return key, nil
+	}
+
+	var key *rsa.PublicKey
+	var err error
+	if pubkeyPath != "" {
+		key, err = crypt.LoadPublicKey(pubkeyPath)
+	} else {
+		key, err = subcmd.ResolveRecipientKey(email)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.keys[cacheKey] = key
+	return key, nil
+}
+
+// readBatchRows parses the batch CSV. The header row names the columns and must
+// include email.
+func readBatchRows(path string) ([]*batchRow, []string, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read header of %s: %w", path, err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	emailColumn := indexOf(header, columnEmail)
+	if emailColumn < 0 {
+		return nil, nil, fmt.Errorf("%s has no %q column", path, columnEmail)
+	}
+
+	var rows []*batchRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		address, err := ParseAddressList(record[emailColumn])
+		if err != nil || len(address) != 1 {
+			return nil, nil, fmt.Errorf("line %d: invalid email %q", line, record[emailColumn])
+		}
+
+		row := &batchRow{Line: line, Email: bareAddress(address[0]), Fields: map[string]string{}}
+		for i, column := range header {
+			row.Fields[column] = record[i]
+		}
+		sum := sha256.Sum256([]byte(strings.Join(record, "\x00")))
+		row.ID = hex.EncodeToString(sum[:8])
+		rows = append(rows, row)
+	}
+	return rows, header, nil
+}
+
+// readDeliveredRows returns the IDs of rows the results file records as sent, or as
+// queued in the outbox, which redelivers them.
+func readDeliveredRows(path string) (map[string]bool, error) {
+	delivered := map[string]bool{}
+
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return delivered, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse results file %s: %w", path, err)
+	}
+	for i, record := range records {
+		if i == 0 || len(record) < 3 {
+			continue
+		}
+		if record[2] == statusSent || record[2] == statusQueued {
+			delivered[record[0]] = true
+		}
+	}
+	return delivered, nil
+}
+
+// resultsFile appends one CSV line per processed row and flushes it immediately, so
+// the file is accurate even if the batch is interrupted.
+type resultsFile struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func openResults(path string) (*resultsFile, error) {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results file: %w", err)
+	}
+	results := &resultsFile{f: f, w: csv.NewWriter(f)}
+
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		results.w.Write(resultsHeader)
+		results.w.Flush()
+	}
+	return results, nil
+}
+
+func (r *resultsFile) Record(result *batchResult) error {
+	errText := ""
+	if result.Err != nil {
+		errText = result.Err.Error()
+	}
+	r.w.Write([]string{
+		result.Row.ID,
+		result.Row.Email,
+		result.Status,
+		result.MessageID,
+		time.Now().Format(time.RFC3339),
+		errText,
+	})
+	r.w.Flush()
+	return r.w.Error()
+}
+
+func (r *resultsFile) Close() error {
+	return r.f.Close()
+}
+
+func indexOf(list []string, value string) int {
+	for i, entry := range list {
+		if entry == value {
+			return i
+		}
+	}
+	return -1
+}
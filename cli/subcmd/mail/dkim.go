@@ -0,0 +1,576 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+)
+
+// DKIM signing algorithms (RFC 6376 and RFC 8463).
+const (
+	DKIMRSASHA256     = "rsa-sha256"
+	DKIMEd25519SHA256 = "ed25519-sha256"
+)
+
+const (
+	dkimHeader           = "DKIM-Signature"
+	dkimCanonicalization = "relaxed/relaxed"
+	// dkimMinRSABits is the smallest RSA key RFC 8301 allows verifiers to accept.
+	dkimMinRSABits = 1024
+)
+
+// dkimSignedHeaders are signed when present. From is listed twice so a second From
+// header added in transit invalidates the signature.
+var dkimSignedHeaders = []string{
+	"From", "From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type",
+}
+
+// DKIMSigner adds a DKIM-Signature header to outgoing messages.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	// Key is an *rsa.PrivateKey or an ed25519.PrivateKey.
+	Key crypto.Signer
+}
+
+var (
+	defaultDKIMOnce   sync.Once
+	defaultDKIMSigner *DKIMSigner
+	defaultDKIMErr    error
+)
+
+// DefaultDKIMSigner returns the signer configured by DKIM_DOMAIN, DKIM_SELECTOR and
+// DKIM_PRIVATE_KEY, or nil when DKIM_DOMAIN is not set.
+func DefaultDKIMSigner() (*DKIMSigner, error) {
+	defaultDKIMOnce.Do(func() {
+		if env.Vars.DKIMDomain == "" {
+			return
+		}
+		if env.Vars.DKIMSelector == "" || env.Vars.DKIMPrivateKey == "" {
+			defaultDKIMErr = errors.New("DKIM_DOMAIN is set but DKIM_SELECTOR or DKIM_PRIVATE_KEY is missing")
+			return
+		}
+		key, err := LoadDKIMKey(env.Vars.DKIMPrivateKey)
+		if err != nil {
+			defaultDKIMErr = err
+			return
+		}
+		defaultDKIMSigner = &DKIMSigner{Domain: env.Vars.DKIMDomain, Selector: env.Vars.DKIMSelector, Key: key}
+	})
+	return defaultDKIMSigner, defaultDKIMErr
+}
+
+// LoadDKIMKey reads a PEM encoded RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key.
+func LoadDKIMKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DKIM key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("DKIM key %s is not PEM encoded", path)
+	}
+
+	var key interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DKIM key: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < dkimMinRSABits {
+			return nil, fmt.Errorf("DKIM RSA key has %d bits, at least %d are required", k.N.BitLen(), dkimMinRSABits)
+		}
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported DKIM key type %T, use RSA or Ed25519", key)
+	}
+}
+
+// Algorithm returns the a= tag value for the signer's key.
+func (s *DKIMSigner) Algorithm() string {
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		return DKIMEd25519SHA256
+	}
+	return DKIMRSASHA256
+}
+
+// DNSRecord returns the TXT record to publish at <selector>._domainkey.<domain>.
+func (s *DKIMSigner) DNSRecord() (string, error) {
+	switch pub := s.Key.Public().(type) {
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub), nil
+	default:
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	}
+}
+
+// Sign returns message, which must use CRLF line endings, with a DKIM-Signature
+// header prepended. Headers and body use relaxed canonicalization.
+func (s *DKIMSigner) Sign(message []byte) ([]byte, error) {
+	headers, body, err := splitMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyHash := sha256.Sum256(canonicalBodyRelaxed(body))
+
+	present := map[string]bool{}
+	for _, field := range headers {
+		present[strings.ToLower(field.name)] = true
+	}
+	if !present["from"] {
+		return nil, errors.New("cannot DKIM sign a message without a From header")
+	}
+	var signed []string
+	for _, name := range dkimSignedHeaders {
+		if present[strings.ToLower(name)] {
+			signed = append(signed, strings.ToLower(name))
+		}
+	}
+
+	tags := []string{
+		"v=1",
+		"a=" + s.Algorithm(),
+		"c=" + dkimCanonicalization,
+		"d=" + s.Domain,
+		"s=" + s.Selector,
+		"t=" + strconv.FormatInt(time.Now().Unix(), 10),
+		"h=" + strings.Join(signed, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+		"b=",
+	}
+	value := foldTags(tags)
+
+	digest := sha256.Sum256(dkimSigningInput(headers, signed, dkimHeader+": "+value))
+	var signature []byte
+	switch key := s.Key.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, digest[:])
+	default:
+		signature, err = s.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("failed to DKIM sign: %w", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(dkimHeader + ": " + value + foldBase64(base64.StdEncoding.EncodeToString(signature)) + "\r\n")
+	buf.Write(message)
+	return buf.Bytes(), nil
+}
+
+// DKIMKeyLookup returns the TXT record published for a selector and domain.
+type DKIMKeyLookup func(selector, domain string) (string, error)
+
+// LookupDKIMKey queries DNS for <selector>._domainkey.<domain>.
+func LookupDKIMKey(selector, domain string) (string, error) {
+	records, err := net.LookupTXT(selector + "._domainkey." + domain)
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return "", fmt.Errorf("no DKIM record for %s._domainkey.%s", selector, domain)
+	}
+	return records[0], nil
+}
+
+// VerifyDKIM checks the DKIM signatures of message and returns the signing domain
+// of the first valid one. lookup defaults to LookupDKIMKey.
+func VerifyDKIM(message []byte, lookup DKIMKeyLookup) (string, error) {
+	if lookup == nil {
+		lookup = LookupDKIMKey
+	}
+	if !bytes.Contains(message, []byte("\r\n")) {
+		message = bytes.ReplaceAll(message, []byte("\n"), []byte("\r\n"))
+	}
+	headers, body, err := splitMessage(message)
+	if err != nil {
+		return "", err
+	}
+
+	err = errors.New("message has no DKIM signature")
+	for _, field := range headers {
+		if !strings.EqualFold(field.name, dkimHeader) {
+			continue
+		}
+		var domain string
+		if domain, err = verifySignature(headers, field, body, lookup); err == nil {
+			return domain, nil
+		}
+	}
+	return "", err
+}
+
+func verifySignature(headers []headerField, signature headerField, body []byte, lookup DKIMKeyLookup) (string, error) {
+	tags, err := parseTags(signature.value())
+	if err != nil {
+		return "", err
+	}
+	for _, tag := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[tag]; !ok {
+			return "", fmt.Errorf("DKIM signature lacks the %s= tag", tag)
+		}
+	}
+	if tags["v"] != "1" {
+		return "", fmt.Errorf("unsupported DKIM version %q", tags["v"])
+	}
+	domain := tags["d"]
+	if expires, ok := tags["x"]; ok {
+		if x, err := strconv.ParseInt(expires, 10, 64); err == nil && time.Now().Unix() > x {
+			return domain, errors.New("DKIM signature has expired")
+		}
+	}
+
+	headerCanon, bodyCanon := "simple", "simple"
+	if c, ok := tags["c"]; ok {
+		parts := strings.SplitN(c, "/", 2)
+		headerCanon = parts[0]
+		if len(parts) == 2 {
+			bodyCanon = parts[1]
+		}
+	}
+
+	var canonicalBody []byte
+	switch bodyCanon {
+	case "relaxed":
+		canonicalBody = canonicalBodyRelaxed(body)
+	case "simple":
+		canonicalBody = canonicalBodySimple(body)
+	default:
+		return domain, fmt.Errorf("unsupported DKIM body canonicalization %q", bodyCanon)
+	}
+	if l, ok := tags["l"]; ok {
+		length, err := strconv.Atoi(l)
+		if err != nil || length > len(canonicalBody) {
+			return domain, fmt.Errorf("invalid DKIM body length %q", l)
+		}
+		canonicalBody = canonicalBody[:length]
+	}
+	bodyHash := sha256.Sum256(canonicalBody)
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != stripWhitespace(tags["bh"]) {
+		return domain, errors.New("DKIM body hash does not match, the body was modified")
+	}
+
+	var signed []string
+	for _, name := range strings.Split(tags["h"], ":") {
+		signed = append(signed, strings.ToLower(strings.TrimSpace(name)))
+	}
+	unsigned := signature.withoutSignature()
+
+	var input []byte
+	switch headerCanon {
+	case "relaxed":
+		input = dkimSigningInput(headers, signed, unsigned)
+	case "simple":
+		input = dkimSigningInputSimple(headers, signed, unsigned)
+	default:
+		return domain, fmt.Errorf("unsupported DKIM header canonicalization %q", headerCanon)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(stripWhitespace(tags["b"]))
+	if err != nil {
+		return domain, fmt.Errorf("invalid DKIM signature encoding: %w", err)
+	}
+
+	record, err := lookup(tags["s"], domain)
+	if err != nil {
+		return domain, fmt.Errorf("failed to fetch DKIM key: %w", err)
+	}
+	keyTags, err := parseTags(record)
+	if err != nil {
+		return domain, fmt.Errorf("invalid DKIM key record: %w", err)
+	}
+	encodedKey := stripWhitespace(keyTags["p"])
+	if encodedKey == "" {
+		return domain, errors.New("DKIM key has been revoked")
+	}
+	keyData, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return domain, fmt.Errorf("invalid DKIM key encoding: %w", err)
+	}
+
+	digest := sha256.Sum256(input)
+	switch tags["a"] {
+	case DKIMRSASHA256:
+		if k, ok := keyTags["k"]; ok && k != "rsa" {
+			return domain, fmt.Errorf("DKIM key type %q does not match algorithm %s", k, tags["a"])
+		}
+		pub, err := parseDKIMRSAKey(keyData)
+		if err != nil {
+			return domain, err
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return domain, errors.New("DKIM signature is invalid")
+		}
+	case DKIMEd25519SHA256:
+		if keyTags["k"] != "ed25519" {
+			return domain, fmt.Errorf("DKIM key type %q does not match algorithm %s", keyTags["k"], tags["a"])
+		}
+		if len(keyData) != ed25519.PublicKeySize {
+			return domain, errors.New("invalid Ed25519 DKIM key")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(keyData), digest[:], sig) {
+			return domain, errors.New("DKIM signature is invalid")
+		}
+	default:
+		return domain, fmt.Errorf("unsupported DKIM algorithm %q", tags["a"])
+	}
+	return domain, nil
+}
+
+// parseDKIMRSAKey accepts the SubjectPublicKeyInfo encoding used by most records and
+// the bare RSAPublicKey some publishers use.
+func parseDKIMRSAKey(data []byte) (*rsa.PublicKey, error) {
+	if pub, err := x509.ParsePKIXPublicKey(data); err == nil {
+		if rsaPub, ok := pub.(*rsa.PublicKey); ok {
+			return rsaPub, nil
+		}
+		return nil, errors.New("DKIM key is not an RSA key")
+	}
+	pub, err := x509.ParsePKCS1PublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RSA DKIM key: %w", err)
+	}
+	return pub, nil
+}
+
+// headerField is one header field as it appears in the message, folding included.
+type headerField struct {
+	name string
+	raw  string
+}
+
+func (f headerField) value() string {
+	return f.raw[strings.IndexByte(f.raw, ':')+1:]
+}
+
+// withoutSignature returns the DKIM-Signature field with the b= value emptied, as
+// hashed by the signer.
+func (f headerField) withoutSignature() string {
+	raw := strings.TrimSuffix(f.raw, "\r\n")
+	colon := strings.IndexByte(raw, ':')
+	value := raw[colon+1:]
+
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		trimmed := strings.TrimLeft(part, " \t\r\n")
+		if strings.HasPrefix(trimmed, "b=") || strings.HasPrefix(trimmed, "b\t") || strings.HasPrefix(trimmed, "b ") {
+			eq := strings.IndexByte(part, '=')
+			parts[i] = part[:eq+1]
+		}
+	}
+	return raw[:colon+1] + strings.Join(parts, ";")
+}
+
+// splitMessage separates the header fields from the body.
+func splitMessage(message []byte) ([]headerField, []byte, error) {
+	end := bytes.Index(message, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, nil, errors.New("message has no header/body separator")
+	}
+	head, body := string(message[:end+2]), message[end+4:]
+
+	var fields []headerField
+	for _, line := range strings.SplitAfter(head, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(fields) == 0 {
+				return nil, nil, errors.New("message starts with a continuation line")
+			}
+			fields[len(fields)-1].raw += line
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			return nil, nil, fmt.Errorf("malformed header line %q", strings.TrimSpace(line))
+		}
+		fields = append(fields, headerField{name: strings.TrimRight(line[:colon], " \t"), raw: line})
+	}
+	return fields, body, nil
+}
+
+// dkimSigningInput returns the relaxed canonical form of the signed headers, taken
+// bottom-up for repeated names, followed by the DKIM-Signature field without its
+// trailing CRLF.
+func dkimSigningInput(headers []headerField, signed []string, signature string) []byte {
+	var buf bytes.Buffer
+	for _, field := range selectHeaders(headers, signed) {
+		buf.WriteString(canonicalHeaderRelaxed(field.raw))
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString(canonicalHeaderRelaxed(signature))
+	return buf.Bytes()
+}
+
+func dkimSigningInputSimple(headers []headerField, signed []string, signature string) []byte {
+	var buf bytes.Buffer
+	for _, field := range selectHeaders(headers, signed) {
+		buf.WriteString(field.raw)
+	}
+	buf.WriteString(signature)
+	return buf.Bytes()
+}
+
+// selectHeaders picks, for each signed name, the last instance not yet used.
+// Names with no instance left contribute nothing.
+func selectHeaders(headers []headerField, signed []string) []headerField {
+	used := map[int]bool{}
+	var selected []headerField
+	for _, name := range signed {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(headers[i].name, name) {
+				used[i] = true
+				selected = append(selected, headers[i])
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// canonicalHeaderRelaxed applies RFC 6376 relaxed header canonicalization, without
+// the trailing CRLF.
+func canonicalHeaderRelaxed(raw string) string {
+	raw = strings.TrimSuffix(raw, "\r\n")
+	colon := strings.IndexByte(raw, ':')
+	name := strings.ToLower(strings.TrimRight(raw[:colon], " \t"))
+	value := strings.NewReplacer("\r\n", "").Replace(raw[colon+1:])
+	return name + ":" + strings.TrimSpace(collapseWhitespace(value))
+}
+
+// canonicalBodyRelaxed applies RFC 6376 relaxed body canonicalization.
+func canonicalBodyRelaxed(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(collapseWhitespace(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// canonicalBodySimple applies RFC 6376 simple body canonicalization.
+func canonicalBodySimple(body []byte) []byte {
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+	return append(append([]byte{}, body...), '\r', '\n')
+}
+
+func collapseWhitespace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func stripWhitespace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// parseTags parses a DKIM tag-list such as "v=1; a=rsa-sha256; ...".
+func parseTags(list string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, part := range strings.Split(list, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.IndexByte(part, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("malformed DKIM tag %q", part)
+		}
+		name := strings.TrimSpace(part[:eq])
+		if _, dup := tags[name]; dup {
+			return nil, fmt.Errorf("duplicate DKIM tag %q", name)
+		}
+		tags[name] = strings.TrimSpace(part[eq+1:])
+	}
+	return tags, nil
+}
+
+// foldTags joins tags into a header value folded before lines grow past 76 columns.
+func foldTags(tags []string) string {
+	var b strings.Builder
+	column := len(dkimHeader) + 2
+	for i, tag := range tags {
+		if i > 0 {
+			if column+len(tag)+2 > 76 {
+				b.WriteString(";\r\n ")
+				column = 1
+			} else {
+				b.WriteString("; ")
+				column += 2
+			}
+		}
+		b.WriteString(tag)
+		column += len(tag)
+	}
+	return b.String()
+}
+
+// foldBase64 splits the signature over continuation lines.
+func foldBase64(s string) string {
+	var b strings.Builder
+	for len(s) > 72 {
+		b.WriteString("\r\n " + s[:72])
+		s = s[72:]
+	}
+	if s != "" {
+		b.WriteString("\r\n " + s)
+	}
+	return b.String()
+}
@@ -0,0 +1,206 @@
+package mail
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const dkimTestMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: quarterly report\r\n" +
+	"Date: Mon, 19 Oct 2026 10:00:00 +0000\r\n" +
+	"Message-ID: <report.1@example.com>\r\n" +
+	"\r\n" +
+	"Hello Bob,\r\n" +
+	"\r\n" +
+	"the report is attached.\r\n"
+
+func dkimTestSigners(t *testing.T) map[string]*DKIMSigner {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*DKIMSigner{
+		DKIMRSASHA256:     {Domain: "example.com", Selector: "cryptix", Key: rsaKey},
+		DKIMEd25519SHA256: {Domain: "example.com", Selector: "cryptix", Key: edKey},
+	}
+}
+
+// stubLookup serves signer's DNS record, as LookupDKIMKey would once it is published.
+func stubLookup(t *testing.T, signer *DKIMSigner) DKIMKeyLookup {
+	t.Helper()
+	record, err := signer.DNSRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(selector, domain string) (string, error) {
+		if selector != signer.Selector || domain != signer.Domain {
+			return "", errors.New("no record for " + selector + "._domainkey." + domain)
+		}
+		return record, nil
+	}
+}
+
+func signTestMessage(t *testing.T, signer *DKIMSigner) string {
+	t.Helper()
+	signed, err := signer.Sign([]byte(dkimTestMessage))
+	if err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+	return string(signed)
+}
+
+func TestDKIMSignVerify(t *testing.T) {
+	for algorithm, signer := range dkimTestSigners(t) {
+		t.Run(algorithm, func(t *testing.T) {
+			if got := signer.Algorithm(); got != algorithm {
+				t.Fatalf("Algorithm() = %q, want %q", got, algorithm)
+			}
+			signed := signTestMessage(t, signer)
+			if !strings.HasPrefix(signed, dkimHeader+": ") || !strings.HasSuffix(signed, dkimTestMessage) {
+				t.Fatal("Sign() did not prepend a DKIM-Signature to the unchanged message")
+			}
+			for _, tag := range []string{"a=" + algorithm, "c=relaxed/relaxed", "d=example.com", "s=cryptix", "h=from:from:to:subject:date:message-id"} {
+				if !strings.Contains(signed, tag) {
+					t.Errorf("signature lacks %s", tag)
+				}
+			}
+
+			domain, err := VerifyDKIM([]byte(signed), stubLookup(t, signer))
+			if err != nil {
+				t.Fatalf("VerifyDKIM() = %v", err)
+			}
+			if domain != "example.com" {
+				t.Fatalf("VerifyDKIM() domain = %q, want example.com", domain)
+			}
+
+			// A message saved with LF line endings verifies the same.
+			lf := strings.ReplaceAll(signed, "\r\n", "\n")
+			if _, err := VerifyDKIM([]byte(lf), stubLookup(t, signer)); err != nil {
+				t.Fatalf("VerifyDKIM() with LF line endings = %v", err)
+			}
+		})
+	}
+}
+
+func TestDKIMRelaxedCanonicalization(t *testing.T) {
+	// Changes relaxed canonicalization absorbs, as relays commonly make them.
+	rewrites := []struct{ old, new string }{
+		{"Subject: quarterly report\r\n", "SUBJECT:   quarterly \t report  \r\n"},
+		{"To: bob@example.com\r\n", "to: \r\n\tbob@example.com\r\n"},
+		{"Hello Bob,\r\n", "Hello   Bob,\t \r\n"},
+		{"the report is attached.\r\n", "the report\tis attached.\r\n\r\n\r\n"},
+	}
+	for algorithm, signer := range dkimTestSigners(t) {
+		t.Run(algorithm, func(t *testing.T) {
+			relayed := signTestMessage(t, signer)
+			for _, r := range rewrites {
+				if !strings.Contains(relayed, r.old) {
+					t.Fatalf("test message lacks %q", r.old)
+				}
+				relayed = strings.Replace(relayed, r.old, r.new, 1)
+			}
+			if _, err := VerifyDKIM([]byte(relayed), stubLookup(t, signer)); err != nil {
+				t.Fatalf("VerifyDKIM() after whitespace and case changes = %v", err)
+			}
+		})
+	}
+}
+
+func TestDKIMVerifyRejectsTampering(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		wantErr  string
+	}{
+		{"body", "the report is attached.", "the report is NOT attached.", "body hash does not match"},
+		{"signed header", "Subject: quarterly report", "Subject: quarterly reports", "signature is invalid"},
+		{"second From", "From: Alice <alice@example.com>\r\n", "From: Alice <alice@example.com>\r\nFrom: Mallory <mallory@example.net>\r\n", "signature is invalid"},
+	}
+	for algorithm, signer := range dkimTestSigners(t) {
+		signed := signTestMessage(t, signer)
+		for _, tt := range tests {
+			t.Run(algorithm+"/"+tt.name, func(t *testing.T) {
+				tampered := strings.Replace(signed, tt.old, tt.new, 1)
+				_, err := VerifyDKIM([]byte(tampered), stubLookup(t, signer))
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("VerifyDKIM() = %v, want error containing %q", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestDKIMVerifyKeyRecord(t *testing.T) {
+	signers := dkimTestSigners(t)
+	signer := signers[DKIMEd25519SHA256]
+	signed := []byte(signTestMessage(t, signer))
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := &DKIMSigner{Domain: signer.Domain, Selector: signer.Selector, Key: otherKey}
+
+	tests := []struct {
+		name    string
+		lookup  DKIMKeyLookup
+		wantErr string
+	}{
+		{"other key", stubLookup(t, rotated), "signature is invalid"},
+		{"revoked", func(string, string) (string, error) { return "v=DKIM1; k=ed25519; p=", nil }, "revoked"},
+		{"rsa record for ed25519 signature", stubLookup(t, &DKIMSigner{Domain: "example.com", Selector: "cryptix", Key: signers[DKIMRSASHA256].Key}), "does not match algorithm"},
+		{"lookup failure", func(string, string) (string, error) { return "", errors.New("SERVFAIL") }, "failed to fetch DKIM key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyDKIM(signed, tt.lookup)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("VerifyDKIM() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := VerifyDKIM([]byte(dkimTestMessage), stubLookup(t, signer)); err == nil || !strings.Contains(err.Error(), "no DKIM signature") {
+		t.Fatalf("VerifyDKIM() of an unsigned message = %v", err)
+	}
+}
+
+func TestDKIMDNSRecord(t *testing.T) {
+	for algorithm, signer := range dkimTestSigners(t) {
+		t.Run(algorithm, func(t *testing.T) {
+			record, err := signer.DNSRecord()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tags, err := parseTags(record)
+			if err != nil {
+				t.Fatalf("DNSRecord() %q does not parse: %v", record, err)
+			}
+			wantType := map[string]string{DKIMRSASHA256: "rsa", DKIMEd25519SHA256: "ed25519"}[algorithm]
+			if tags["v"] != "DKIM1" || tags["k"] != wantType || tags["p"] == "" {
+				t.Fatalf("DNSRecord() = %q, want v=DKIM1, k=%s and a key", record, wantType)
+			}
+		})
+	}
+}
+
+func TestDKIMSignRequiresFrom(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &DKIMSigner{Domain: "example.com", Selector: "cryptix", Key: key}
+	if _, err := signer.Sign([]byte("To: bob@example.com\r\n\r\nhello\r\n")); err == nil {
+		t.Fatal("Sign() accepted a message without a From header")
+	}
+}
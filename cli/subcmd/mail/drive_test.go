@@ -0,0 +1,453 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/option"
+)
+
+// fakeDrive stands in for the Drive v2 API: resumable uploads below /upload and
+// files and permissions below /drive/v2.
+type fakeDrive struct {
+	*httptest.Server
+	t *testing.T
+
+	mu          sync.Mutex
+	starts      int
+	sessions    map[string]*fakeUploadSession
+	ranges      []string
+	files       map[string][]byte
+	permissions map[string][]*drive.Permission
+	deleted     []string
+	// failSharing is an address permissions are refused for.
+	failSharing string
+	// cancelSharing, when set, is called by the first permission insert, which then
+	// waits for the client to abandon it, as when --upload-timeout runs out.
+	cancelSharing context.CancelFunc
+	// interrupt is consulted before a chunk starting at offset is stored. A result
+	// n >= 0 stores only the first n bytes and fails the request with a 503.
+	interrupt func(offset int64) int
+}
+
+type fakeUploadSession struct {
+	title string
+	size  int64
+	data  []byte
+}
+
+func newFakeDrive(t *testing.T) *fakeDrive {
+	d := &fakeDrive{
+		t:           t,
+		sessions:    map[string]*fakeUploadSession{},
+		files:       map[string][]byte{},
+		permissions: map[string][]*drive.Permission{},
+	}
+	d.Server = httptest.NewServer(d)
+	t.Cleanup(d.Close)
+	return d
+}
+
+func (d *fakeDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && path == "/upload/drive/v2/files":
+		d.startUpload(w, r)
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/upload/sessions/"):
+		session, ok := d.sessions[strings.TrimPrefix(path, "/upload/sessions/")]
+		if !ok {
+			http.Error(w, "no such session", http.StatusNotFound)
+			return
+		}
+		d.putChunk(w, r, session)
+	case strings.HasPrefix(path, "/drive/v2/files/"):
+		d.serveFile(w, r, strings.Split(strings.TrimPrefix(path, "/drive/v2/files/"), "/"))
+	default:
+		d.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+func (d *fakeDrive) startUpload(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("uploadType") != "resumable" {
+		d.t.Errorf("upload started with uploadType %q", r.URL.Query().Get("uploadType"))
+	}
+	size, err := strconv.ParseInt(r.Header.Get("X-Upload-Content-Length"), 10, 64)
+	if err != nil {
+		http.Error(w, "missing X-Upload-Content-Length", http.StatusBadRequest)
+		return
+	}
+	metadata := &drive.File{}
+	if err := json.NewDecoder(r.Body).Decode(metadata); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.starts++
+	id := fmt.Sprintf("session-%d", d.starts)
+	d.sessions[id] = &fakeUploadSession{title: metadata.Title, size: size}
+	w.Header().Set("Location", d.URL+"/upload/sessions/"+id)
+}
+
+func (d *fakeDrive) putChunk(w http.ResponseWriter, r *http.Request, session *fakeUploadSession) {
+	contentRange := r.Header.Get("Content-Range")
+	d.ranges = append(d.ranges, contentRange)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+
+	if !strings.HasPrefix(contentRange, "bytes */") {
+		var first, last, size int64
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &first, &last, &size); err != nil ||
+			first != int64(len(session.data)) || last-first+1 != int64(len(body)) || size != session.size {
+			d.t.Errorf("chunk %q does not continue the %d stored bytes of %d", contentRange, len(session.data), session.size)
+			http.Error(w, "bad Content-Range", http.StatusBadRequest)
+			return
+		}
+		if d.interrupt != nil {
+			if n := d.interrupt(first); n >= 0 {
+				session.data = append(session.data, body[:n]...)
+				http.Error(w, "backend error", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		session.data = append(session.data, body...)
+	}
+
+	if int64(len(session.data)) < session.size {
+		if len(session.data) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.data)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+	id := "file-" + strings.TrimPrefix(r.URL.Path, "/upload/sessions/session-")
+	d.files[id] = session.data
+	d.permissions[id] = []*drive.Permission{{Id: "owner", Role: "owner", Type: "user", EmailAddress: "me@example.com"}}
+	json.NewEncoder(w).Encode(&drive.File{Id: id, Title: session.title})
+}
+
+// serveFile serves files/{id}, files/{id}/permissions and files/{id}/permissions/{permissionId}.
+func (d *fakeDrive) serveFile(w http.ResponseWriter, r *http.Request, parts []string) {
+	id := parts[0]
+	if _, ok := d.files[id]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error":{"code":404,"message":"File not found"}}`)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		delete(d.files, id)
+		d.deleted = append(d.deleted, id)
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		if r.URL.Query().Get("sendNotificationEmails") != "false" {
+			d.t.Errorf("permission inserted with sendNotificationEmails=%q", r.URL.Query().Get("sendNotificationEmails"))
+		}
+		permission := &drive.Permission{}
+		if err := json.NewDecoder(r.Body).Decode(permission); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if d.cancelSharing != nil {
+			d.cancelSharing()
+			d.cancelSharing = nil
+			<-r.Context().Done()
+			return
+		}
+		if permission.Value == d.failSharing {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"error":{"code":403,"message":"Sharing is restricted"}}`)
+			return
+		}
+		permission.Id = fmt.Sprintf("perm-%d", len(d.permissions[id]))
+		permission.EmailAddress = permission.Value
+		d.permissions[id] = append(d.permissions[id], permission)
+		json.NewEncoder(w).Encode(permission)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(&drive.PermissionList{Items: d.permissions[id]})
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		kept := d.permissions[id][:0]
+		for _, permission := range d.permissions[id] {
+			if permission.Id != parts[2] {
+				kept = append(kept, permission)
+			}
+		}
+		d.permissions[id] = kept
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		d.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+func (d *fakeDrive) service(t *testing.T) *drive.Service {
+	t.Helper()
+	service, err := drive.NewService(context.Background(), option.WithHTTPClient(d.Client()), option.WithEndpoint(d.URL+"/drive/v2/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func (d *fakeDrive) uploader() *resumableUpload {
+	return &resumableUpload{
+		client:    d.Client(),
+		endpoint:  d.URL + "/upload/drive/v2/files?uploadType=resumable",
+		chunkSize: uploadChunkUnit,
+	}
+}
+
+// testEnvelope returns size random bytes standing in for an encrypted envelope.
+func testEnvelope(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestResumableUploadChunks(t *testing.T) {
+	withEnv(t, func(c *env.Config) { c.UploadDir = t.TempDir() })
+	d := newFakeDrive(t)
+	data := testEnvelope(t, 2*uploadChunkUnit+1000)
+
+	var progress []int64
+	uploader := d.uploader()
+	uploader.progress = func(sent, total int64) { progress = append(progress, sent) }
+
+	file, err := uploader.Upload(context.Background(), "report.json", data)
+	if err != nil {
+		t.Fatalf("Upload() = %v", err)
+	}
+	if file.Title != "report.json" || !bytes.Equal(d.files[file.Id], data) {
+		t.Fatalf("Drive holds %q with %d bytes, want report.json with the %d bytes sent", file.Title, len(d.files[file.Id]), len(data))
+	}
+
+	want := []string{
+		fmt.Sprintf("bytes 0-%d/%d", uploadChunkUnit-1, len(data)),
+		fmt.Sprintf("bytes %d-%d/%d", uploadChunkUnit, 2*uploadChunkUnit-1, len(data)),
+		fmt.Sprintf("bytes %d-%d/%d", 2*uploadChunkUnit, len(data)-1, len(data)),
+	}
+	if strings.Join(d.ranges, "|") != strings.Join(want, "|") {
+		t.Fatalf("chunks sent as %q, want %q", d.ranges, want)
+	}
+	if fmt.Sprint(progress) != fmt.Sprint([]int64{uploadChunkUnit, 2 * uploadChunkUnit, int64(len(data))}) {
+		t.Fatalf("progress reported %v", progress)
+	}
+	if entries, _ := os.ReadDir(env.Vars.UploadDir); len(entries) != 0 {
+		t.Fatalf("finished upload left %d session directories behind", len(entries))
+	}
+}
+
+func TestResumableUploadRetriesPartiallyStoredChunk(t *testing.T) {
+	withEnv(t, func(c *env.Config) { c.UploadDir = t.TempDir() })
+	d := newFakeDrive(t)
+	data := testEnvelope(t, 2*uploadChunkUnit+1000)
+
+	// The second chunk fails once after half of it was stored.
+	interrupted := false
+	d.interrupt = func(offset int64) int {
+		if offset == uploadChunkUnit && !interrupted {
+			interrupted = true
+			return uploadChunkUnit / 2
+		}
+		return -1
+	}
+
+	file, err := d.uploader().Upload(context.Background(), "report.json", data)
+	if err != nil {
+		t.Fatalf("Upload() = %v", err)
+	}
+	if !bytes.Equal(d.files[file.Id], data) {
+		t.Fatal("Drive holds different bytes than were sent")
+	}
+	if d.starts != 1 {
+		t.Fatalf("%d upload sessions started, want the retry to reuse the first", d.starts)
+	}
+	// After the failure the client asks what was stored and continues from there.
+	retry := fmt.Sprintf("bytes %d-", uploadChunkUnit+uploadChunkUnit/2)
+	if len(d.ranges) < 4 || d.ranges[2] != fmt.Sprintf("bytes */%d", len(data)) || !strings.HasPrefix(d.ranges[3], retry) {
+		t.Fatalf("chunks sent as %q, want a status query followed by %s...", d.ranges, retry)
+	}
+}
+
+func TestResumableUploadResumesInterruptedSession(t *testing.T) {
+	withEnv(t, func(c *env.Config) { c.UploadDir = t.TempDir() })
+	d := newFakeDrive(t)
+	data := testEnvelope(t, 2*uploadChunkUnit+1000)
+
+	// The first attempt runs out of time after storing one chunk.
+	ctx, cancel := context.WithCancel(context.Background())
+	d.interrupt = func(offset int64) int {
+		if offset == uploadChunkUnit {
+			cancel()
+			return 0
+		}
+		return -1
+	}
+	if _, err := d.uploader().Upload(ctx, "report.json", data); err == nil {
+		t.Fatal("interrupted Upload() succeeded")
+	}
+	digest := testDigest(data)
+	if session, err := loadUploadSession(digest); err != nil || session == nil {
+		t.Fatalf("interrupted upload left no session to resume: %v", err)
+	}
+
+	d.interrupt = nil
+	d.ranges = nil
+	file, err := d.uploader().Upload(context.Background(), "report.json", data)
+	if err != nil {
+		t.Fatalf("resumed Upload() = %v", err)
+	}
+	if !bytes.Equal(d.files[file.Id], data) {
+		t.Fatal("Drive holds different bytes than were sent")
+	}
+	if d.starts != 1 {
+		t.Fatalf("%d upload sessions started, want the persisted one resumed", d.starts)
+	}
+	if len(d.ranges) < 2 || d.ranges[0] != fmt.Sprintf("bytes */%d", len(data)) || !strings.HasPrefix(d.ranges[1], fmt.Sprintf("bytes %d-", uploadChunkUnit)) {
+		t.Fatalf("resumed upload sent %q, want a status query and then the second chunk", d.ranges)
+	}
+	if session, _ := loadUploadSession(digest); session != nil {
+		t.Fatal("finished upload left its session behind")
+	}
+}
+
+func TestResumableUploadRestartsExpiredSession(t *testing.T) {
+	withEnv(t, func(c *env.Config) { c.UploadDir = t.TempDir() })
+	d := newFakeDrive(t)
+	data := testEnvelope(t, 2*uploadChunkUnit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.interrupt = func(offset int64) int {
+		cancel()
+		return 0
+	}
+	if _, err := d.uploader().Upload(ctx, "report.json", data); err == nil {
+		t.Fatal("interrupted Upload() succeeded")
+	}
+
+	// Drive forgot the session in the meantime.
+	d.interrupt = nil
+	d.sessions = map[string]*fakeUploadSession{}
+	file, err := d.uploader().Upload(context.Background(), "report.json", data)
+	if err != nil {
+		t.Fatalf("Upload() = %v", err)
+	}
+	if d.starts != 2 || !bytes.Equal(d.files[file.Id], data) {
+		t.Fatalf("%d sessions started, want the upload started over in a second one", d.starts)
+	}
+}
+
+func TestUploadToDriveSharesWithEachRecipient(t *testing.T) {
+	withEnv(t, func(c *env.Config) { c.UploadDir = t.TempDir() })
+	d := newFakeDrive(t)
+	data := testEnvelope(t, 1000)
+	recipients := []string{"bob@example.com", "carol@example.com"}
+
+	share, err := uploadToDrive(context.Background(), d.service(t), d.uploader(), "report.json", data, recipients)
+	if err != nil {
+		t.Fatalf("uploadToDrive() = %v", err)
+	}
+	if share.Backend != StoreDrive || share.Link != "https://drive.google.com/file/d/"+share.ID+"/view" || share.Name != "report.json" {
+		t.Fatalf("unexpected share %+v", share)
+	}
+
+	var granted []string
+	for _, permission := range d.permissions[share.ID] {
+		if permission.Role == "owner" {
+			continue
+		}
+		if permission.Type != "user" || permission.Role != "reader" {
+			t.Errorf("%s was granted type %q role %q, want a user reader", permission.Value, permission.Type, permission.Role)
+		}
+		granted = append(granted, permission.Value)
+	}
+	if strings.Join(granted, ",") != strings.Join(recipients, ",") {
+		t.Fatalf("shared with %q, want %q", granted, recipients)
+	}
+}
+
+func TestUploadToDriveDeletesFileWhenSharingFails(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(d *fakeDrive, cancel context.CancelFunc)
+	}{
+		{"refused", func(d *fakeDrive, cancel context.CancelFunc) { d.failSharing = "carol@example.com" }},
+		// The delete must not inherit the expired upload context.
+		{"timed out", func(d *fakeDrive, cancel context.CancelFunc) { d.cancelSharing = cancel }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withEnv(t, func(c *env.Config) { c.UploadDir = t.TempDir() })
+			d := newFakeDrive(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			tt.configure(d, cancel)
+
+			share, err := uploadToDrive(ctx, d.service(t), d.uploader(), "report.json", testEnvelope(t, 1000), []string{"bob@example.com", "carol@example.com"})
+			if err == nil || share != nil {
+				t.Fatalf("uploadToDrive() = %v, %v, want the sharing error", share, err)
+			}
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if len(d.deleted) != 1 || len(d.files) != 0 {
+				t.Fatalf("deleted %q, %d files left; want the partially shared file deleted", d.deleted, len(d.files))
+			}
+		})
+	}
+}
+
+func TestDriveStoreRevoke(t *testing.T) {
+	withEnv(t, func(c *env.Config) { c.UploadDir = t.TempDir() })
+	d := newFakeDrive(t)
+	store := &DriveStore{service: d.service(t)}
+	recipients := []string{"bob@example.com", "carol@example.com"}
+
+	share, err := uploadToDrive(context.Background(), store.service, d.uploader(), "report.json", testEnvelope(t, 1000), recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(context.Background(), share, ShareActionUnshare); err != nil {
+		t.Fatalf("Revoke(unshare) = %v", err)
+	}
+	if left := d.permissions[share.ID]; len(left) != 1 || left[0].Role != "owner" {
+		t.Fatalf("permissions after unsharing: %d, want only the owner's", len(left))
+	}
+
+	if err := store.Revoke(context.Background(), share, ShareActionDelete); err != nil {
+		t.Fatalf("Revoke(delete) = %v", err)
+	}
+	if _, ok := d.files[share.ID]; ok {
+		t.Fatal("Revoke(delete) left the file")
+	}
+	if err := store.Revoke(context.Background(), share, ShareActionDelete); err != nil {
+		t.Fatalf("Revoke(delete) of a deleted file = %v, want nil", err)
+	}
+}
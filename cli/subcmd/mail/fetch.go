@@ -0,0 +1,145 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+)
+
+// linkDigestParam is the fragment parameter carrying the SHA-256 of the file a
+// download link points at. Fragments are not sent to servers, so the link works
+// unchanged in a browser.
+const linkDigestParam = "sha256"
+
+// linkWithDigest adds the SHA-256 of the linked file to link, so fetch can check
+// the download without the rest of the email.
+func linkWithDigest(link, digest string) string {
+	if i := strings.IndexByte(link, '#'); i >= 0 {
+		return link + "&" + linkDigestParam + "=" + digest
+	}
+	return link + "#" + linkDigestParam + "=" + digest
+}
+
+// SplitLinkDigest separates the SHA-256 added by send from a download link. The
+// digest is empty when the link carries none.
+func SplitLinkDigest(link string) (string, string) {
+	i := strings.IndexByte(link, '#')
+	if i < 0 {
+		return link, ""
+	}
+	values, err := url.ParseQuery(link[i+1:])
+	if err != nil || values.Get(linkDigestParam) == "" {
+		return link, ""
+	}
+	digest := values.Get(linkDigestParam)
+	values.Del(linkDigestParam)
+	if len(values) > 0 {
+		return link[:i] + "#" + values.Encode(), digest
+	}
+	return link[:i], digest
+}
+
+// ShareFromMessage reads a saved share email and returns the download link and the
+// SHA-256 it lists, from the plain text part written by the share template.
+func ShareFromMessage(data []byte) (string, string, error) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return "", "", fmt.Errorf("not an email: %w", err)
+	}
+	text, err := findTextPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if text == nil {
+		return "", "", errors.New("the email has no plain text part")
+	}
+
+	var link, digest string
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if value, ok := strings.CutPrefix(line, "SHA-256:"); ok && digest == "" {
+			digest = strings.TrimSpace(value)
+			continue
+		}
+		if link == "" && isDownloadLink(line) {
+			link = line
+		}
+	}
+	if link == "" {
+		return "", "", errors.New("the email has no download link, the envelope may be attached instead")
+	}
+	link, linkDigest := SplitLinkDigest(link)
+	if digest == "" {
+		digest = linkDigest
+	}
+	return link, digest, nil
+}
+
+func isDownloadLink(line string) bool {
+	u, err := url.Parse(line)
+	if err != nil || strings.ContainsAny(line, " \t") {
+		return false
+	}
+	switch u.Scheme {
+	case "https", "http", "file", "sftp":
+		return u.Host != "" || u.Path != ""
+	default:
+		return false
+	}
+}
+
+// findTextPart returns the decoded first text/plain part of an entity, descending
+// into multipart entities, or nil when there is none.
+func findTextPart(contentType, encoding string, body io.Reader) ([]byte, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// RFC 2045 defaults a missing or broken Content-Type to plain text.
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("malformed multipart email: %w", err)
+			}
+			text, err := findTextPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil || text != nil {
+				return text, err
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		// The decoder skips the line breaks of wrapped base64 itself.
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	return io.ReadAll(body)
+}
+
+// ValidDigest reports whether digest is a hex encoded SHA-256.
+func ValidDigest(digest string) bool {
+	decoded, err := hex.DecodeString(digest)
+	return err == nil && len(decoded) == 32
+}
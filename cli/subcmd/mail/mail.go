@@ -1,54 +1,47 @@
 package mail
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
-	"log"
 	"net/http"
-	"net/smtp"
-	"os"
-	"path/filepath"
-	"strings"
+	netmail "net/mail"
 	"time"
 
 	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
 	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
 	"github.com/Kshitiz-Mhto/cryptix/utility"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v2"
 	"google.golang.org/api/option"
 )
 
-func sendHtmlEmailWithRetry(to []string, subject string, htmlBody string, maxRetries int, retryInterval time.Duration) error {
-	auth := smtp.PlainAuth(
-		"cryptrix",
-		env.Vars.FromEmail,
-		env.Vars.FromEmailPassword,
-		env.Vars.FromEmailSMTP,
-	)
-
-	headers := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";"
-
-	message := "Subject: " + subject + "\n" + headers + "\n\n" + htmlBody
+// driveCleanupTimeout bounds deleting an upload whose sharing failed.
+const driveCleanupTimeout = 30 * time.Second
 
+func sendHtmlEmailWithRetry(transport Transport, msg *Message, maxRetries int, retryInterval time.Duration) error {
 	var lastError error
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		lastError = smtp.SendMail(
-			env.Vars.SMTPAddress,
-			auth,
-			env.Vars.FromEmail,
-			to,
-			[]byte(message),
-		)
+		lastError = transport.Deliver(msg)
 		if lastError == nil {
 			return nil
 		}
 
+		// 5xx replies and TLS policy failures will not succeed on a later attempt
+		if IsPermanent(lastError) {
+			logger.Logger.WithFields(logrus.Fields{
+				"transport": transport.Name(),
+				"attempt":   attempt,
+				"err":       lastError.Error(),
+			}).Error("Permanent delivery failure")
+			utility.Error("Permanent failure sending email: %s", lastError.Error())
+			return lastError
+		}
+
+		if attempt == maxRetries {
+			break
+		}
+
 		// If failed, wait before retrying
 		utility.Warning("Attempt %d failed: %s. Retrying in %v...", attempt, lastError.Error(), retryInterval)
 		time.Sleep(retryInterval)
@@ -64,31 +57,19 @@ func sendHtmlEmailWithRetry(to []string, subject string, htmlBody string, maxRet
 	return lastError
 }
 
-func HTMLTemplateMailHandler(addr, subject string, vars map[string]interface{}) bool {
-	logger.Logger.Info("Email sending initialization")
-	var emailSubject string
-	basePathForEmailHtml := "./static/"
-
-	if subject == "" {
-		emailSubject = env.Vars.SUBJECT_DESC
-	}
-
-	to := strings.Split(addr, ",")
+// SenderAddress returns the configured From address, with FROM_NAME as display name.
+func SenderAddress() string {
+	return (&netmail.Address{Name: env.Vars.FromName, Address: env.Vars.FromEmail}).String()
+}
 
-	// Parse the HTML template
-	templatePath := filepath.Join(basePathForEmailHtml, env.Vars.HTML_TEMPLATE)
-	tmpl, err := template.ParseFiles(templatePath)
-	if err != nil {
-		utility.Error("failed to parse template: %v", err)
-		logger.Logger.Errorf("failed to parse template: %v", err)
-		return false
-	}
+// HTMLTemplateMailHandler renders tmpl with vars into msg and delivers it with
+// transport. An empty subject falls back to the template's default subject.
+func HTMLTemplateMailHandler(transport Transport, tmpl *EmailTemplate, msg *Message, vars map[string]interface{}) bool {
+	logger.Logger.Info("Email sending initialization")
 
-	// Render the template with the map data
-	var rendered bytes.Buffer
-	if err := tmpl.Execute(&rendered, vars); err != nil {
-		utility.Error("failed to render template: %v", err)
-		logger.Logger.Errorf("failed to render template: %v", err)
+	if err := tmpl.Render(msg, vars); err != nil {
+		utility.Error("%v", err)
+		logger.Logger.Errorf("%v", err)
 		return false
 	}
 
@@ -97,171 +78,128 @@ func HTMLTemplateMailHandler(addr, subject string, vars map[string]interface{})
 	initialRetryInterval := 2 * time.Second
 
 	// Attempt to send the email with retry logic
-	err = sendHtmlEmailWithRetry(to, emailSubject, rendered.String(), maxRetries, initialRetryInterval)
+	// sendHtmlEmailWithRetry already reported the failure to the user.
+	err := sendHtmlEmailWithRetry(transport, msg, maxRetries, initialRetryInterval)
 	if err != nil {
-		utility.Error("%s", err.Error())
 		logger.Logger.Errorf("failed to send mail: %v", err)
+		spoolUndelivered(transport, msg, err)
 		return false
 	}
 
 	return true
 }
 
-func UploadFileToGoogleDrive(filePath string) (string, error) {
-	credentialsPath := env.Vars.OAUTH_CREDENTIALS_PATH
-	b, err := os.ReadFile(credentialsPath)
+// UploadToGoogleDrive uploads data as a file named name, shares it read-only with
+// each recipient address and returns the share, whose link only those Google
+// accounts can open; the file is never shared publicly.
+// The upload is resumable and gives up when ctx is done.
+func UploadToGoogleDrive(ctx context.Context, name string, data []byte, recipients []string) (*Share, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients to share the upload with")
+	}
+
+	service, client, err := newDriveService(ctx)
 	if err != nil {
-		utility.Error("Unable to read credentials file: %s", err)
-		logger.Logger.WithFields(logrus.Fields{
-			"credentialsPath": credentialsPath,
-			"err":             err,
-		}).Error("Unable to read credentials file")
-		return "", err
+		logger.Logger.WithFields(logrus.Fields{"file": name, "err": err}).Error("Unable to create Drive service")
+		return nil, err
 	}
+	return uploadToDrive(ctx, service, newResumableUpload(client), name, data, recipients)
+}
 
-	// Parse the credentials and create a config.
-	config, err := google.ConfigFromJSON(b, drive.DriveFileScope)
+// newDriveService authorizes with the OAuth credentials and returns a Drive
+// service along with its authenticated HTTP client.
+func newDriveService(ctx context.Context) (*drive.Service, *http.Client, error) {
+	config, err := loadOAuthConfig()
 	if err != nil {
-		utility.Error("Unable to parse credentials file: %s", err)
-		logger.Logger.WithFields(logrus.Fields{
-			"credentialsPath": credentialsPath,
-			"err":             err,
-		}).Error("Unable to parse credentials file")
-		return "", err
+		return nil, nil, err
 	}
 
 	utility.Success("OAuth2 credentials loaded successfully")
 	logger.Logger.Info("OAuth2 credentials loaded successfully")
 
 	// Obtain an authenticated HTTP client.
-	client := getClient(config)
-
-	// Create a new Drive service using the authenticated client.
-	service, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
+	client, err := getClient(config)
 	if err != nil {
-		utility.Error("Unable to create Drive service: %s", err)
-		logger.Logger.WithFields(logrus.Fields{
-			"filePath": filePath,
-			"err":      err,
-		}).Error("Unable to create Drive service")
-		return "", err
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("OAuth2 authorization failed")
+		return nil, nil, err
 	}
 
-	// Open the file for upload.
-	f, err := os.Open(filePath)
+	// Create a new Drive service using the authenticated client.
+	service, err := drive.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		utility.Error("Unable to open file for upload: %s", err)
-		logger.Logger.WithFields(logrus.Fields{
-			"filePath": filePath,
-			"err":      err,
-		}).Error("Unable to open file for upload")
-		return "", err
-	}
-	defer f.Close()
-
-	// Create file metadata for Google Drive.
-	driveFile := &drive.File{
-		Description: filepath.Base(filePath),
+		utility.Error("Unable to create Drive service: %s", err)
+		return nil, nil, err
 	}
+	return service, client, nil
+}
 
+// uploadToDrive does the upload and sharing with an already configured service.
+func uploadToDrive(ctx context.Context, service *drive.Service, uploader *resumableUpload, name string, data []byte, recipients []string) (*Share, error) {
 	logger.Logger.WithFields(logrus.Fields{
-		"filePath": filePath,
+		"file": name,
+		"size": len(data),
 	}).Info("Uploading file to Google Drive...")
 
 	// Upload the file.
-	uploadedFile, err := service.Files.Insert(driveFile).Media(f).Do()
+	uploadedFile, err := uploader.Upload(ctx, name, data)
 	if err != nil {
-		utility.Error("Unable to open file for upload to GD: %s", err)
+		utility.Error("Unable to upload file to GD: %s", err)
 		logger.Logger.WithFields(logrus.Fields{
-			"filePath": filePath,
-			"err":      err,
+			"file": name,
+			"err":  err,
 		}).Error("Unable to upload file to Google Drive")
-		return "", err
+		return nil, err
 	}
 
 	logger.Logger.WithFields(logrus.Fields{
-		"filePath": uploadedFile.Description,
-		"fileId":   uploadedFile.Id,
+		"file":   uploadedFile.Title,
+		"fileId": uploadedFile.Id,
 	}).Info("File uploaded successfully to Google Drive")
 
-	// Set the file's permissions to public (read-only).
-	permission := &drive.Permission{
-		Type: "anyone",
-		Role: "read",
-	}
-	_, err = service.Permissions.Insert(uploadedFile.Id, permission).Do()
-	if err != nil {
-		utility.Error("Unable to update file permissions: %s", err)
-		logger.Logger.WithFields(logrus.Fields{
-			"filePath": filePath,
-			"err":      err,
-		}).Error("Unable to update file permissions")
-		return "", err
+	// Grant read access to each recipient only. The message itself carries the
+	// link, so Drive's own notification emails are suppressed.
+	for _, recipient := range recipients {
+		permission := &drive.Permission{
+			Type:  "user",
+			Role:  "reader",
+			Value: recipient,
+		}
+		_, err = service.Permissions.Insert(uploadedFile.Id, permission).SendNotificationEmails(false).Context(ctx).Do()
+		if err != nil {
+			utility.Error("Unable to share file with %s: %s", recipient, err)
+			logger.Logger.WithFields(logrus.Fields{
+				"fileId":    uploadedFile.Id,
+				"recipient": recipient,
+				"err":       err,
+			}).Error("Unable to update file permissions")
+			// Do not leave behind a file nobody can be told about. The upload context
+			// may be what ran out, so the delete gets a deadline of its own.
+			cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), driveCleanupTimeout)
+			delErr := service.Files.Delete(uploadedFile.Id).Context(cleanupCtx).Do()
+			cancel()
+			if delErr != nil {
+				logger.Logger.WithFields(logrus.Fields{
+					"fileId": uploadedFile.Id,
+					"err":    delErr,
+				}).Warn("Unable to delete partially shared file")
+			}
+			return nil, err
+		}
 	}
 
 	logger.Logger.WithFields(logrus.Fields{
-		"fileId": uploadedFile.Id,
-	}).Info("File permissions updated to allow public access")
+		"fileId":     uploadedFile.Id,
+		"recipients": recipients,
+	}).Info("File shared with recipients")
 	utility.Success("Successfully uploaded file to google drive")
 	logger.Logger.Info("Successfully uploaded file to google drive")
 
-	// Construct and return the shareable download link.
-	fileLink := fmt.Sprintf("https://drive.google.com/file/d/%s/view?usp=sharing", uploadedFile.Id)
-	return fileLink, nil
-}
-
-func getClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
-	// created automatically when the authorization flow completes for the first
-	// time.
-	tokFile := "token.json"
-	tok, err := tokenFromFile(tokFile)
-	if err != nil {
-		utility.Error("%s", err)
-		logger.Logger.Errorf("%s", err)
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
-	}
-	return config.Client(context.Background(), tok)
-}
-
-// Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code %v", err)
-	}
-
-	tok, err := config.Exchange(context.TODO(), authCode)
-	if err != nil {
-		log.Fatalf("Unable to retrieve token from web %v", err)
-	}
-	return tok
-}
-
-// Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
-
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
-	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	return &Share{
+		ID:         uploadedFile.Id,
+		Backend:    StoreDrive,
+		Name:       name,
+		Link:       fmt.Sprintf("https://drive.google.com/file/d/%s/view", uploadedFile.Id),
+		Recipients: recipients,
+		Created:    time.Now(),
+	}, nil
 }
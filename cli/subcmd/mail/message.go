@@ -0,0 +1,209 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	netmail "net/mail"
+	"strings"
+	"time"
+)
+
+// Message is an RFC 5322 email message with an HTML body, a plain-text alternative
+// and attachments. Build it with NewMessage and render it with Bytes; nothing here
+// touches the network.
+type Message struct {
+	From        string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	ReplyTo     string
+	Subject     string
+	Date        time.Time
+	MessageID   string
+	HTMLBody    string
+	TextBody    string
+	Attachments []Attachment
+	// SMIME signs and/or encrypts the rendered body. It is never persisted, so
+	// S/MIME messages are not spooled to the outbox.
+	SMIME *SMIMEOptions `json:"-"`
+	// DKIM signs the rendered message when set. It is not persisted either; the
+	// outbox sets it again on redelivery.
+	DKIM *DKIMSigner `json:"-"`
+}
+
+// NewMessage returns a message from the given sender, dated now.
+func NewMessage(from string) *Message {
+	return &Message{From: from, Date: time.Now()}
+}
+
+// ParseAddressList splits a comma separated list of addresses, as accepted by the
+// --mail, --cc and --bcc flags, validating each one.
+func ParseAddressList(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	addresses, err := netmail.ParseAddressList(list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address list %q: %w", list, err)
+	}
+
+	result := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		result = append(result, address.String())
+	}
+	return result, nil
+}
+
+// Validate checks every address and requires a sender and at least one recipient.
+func (m *Message) Validate() error {
+	if _, err := netmail.ParseAddress(m.From); err != nil {
+		return fmt.Errorf("invalid From address %q: %w", m.From, err)
+	}
+	if m.ReplyTo != "" {
+		if _, err := netmail.ParseAddress(m.ReplyTo); err != nil {
+			return fmt.Errorf("invalid Reply-To address %q: %w", m.ReplyTo, err)
+		}
+	}
+
+	fields := []struct {
+		name string
+		list []string
+	}{{"To", m.To}, {"Cc", m.Cc}, {"Bcc", m.Bcc}}
+	for _, field := range fields {
+		for _, address := range field.list {
+			if _, err := netmail.ParseAddress(address); err != nil {
+				return fmt.Errorf("invalid %s address %q: %w", field.name, address, err)
+			}
+		}
+	}
+
+	if len(m.To)+len(m.Cc)+len(m.Bcc) == 0 {
+		return errors.New("message has no recipients")
+	}
+	return nil
+}
+
+// Sender returns the bare sender address used for the SMTP envelope.
+func (m *Message) Sender() string {
+	address, err := netmail.ParseAddress(m.From)
+	if err != nil {
+		return m.From
+	}
+	return address.Address
+}
+
+// bareAddress strips the display name from an address.
+func bareAddress(entry string) string {
+	address, err := netmail.ParseAddress(entry)
+	if err != nil {
+		return entry
+	}
+	return address.Address
+}
+
+// Recipients returns the bare addresses of all To, Cc and Bcc recipients for the SMTP envelope.
+func (m *Message) Recipients() []string {
+	var recipients []string
+	for _, list := range [][]string{m.To, m.Cc, m.Bcc} {
+		for _, entry := range list {
+			if address, err := netmail.ParseAddress(entry); err == nil {
+				recipients = append(recipients, address.Address)
+			}
+		}
+	}
+	return recipients
+}
+
+// Bytes validates the message and renders it with CRLF line endings. Bcc recipients
+// are not written to the headers. A Date and Message-ID are generated when none are
+// set, and the message is signed with DKIM when it has a signer.
+func (m *Message) Bytes() ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	if m.Date.IsZero() {
+		m.Date = time.Now()
+	}
+	if m.MessageID == "" {
+		id, err := newMessageID(m.Sender())
+		if err != nil {
+			return nil, err
+		}
+		m.MessageID = id
+	}
+
+	contentType, body, err := buildMIMEBody(m.HTMLBody, m.TextBody, m.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	entityHeader := "Content-Type: " + contentType + "\r\n"
+	if m.SMIME != nil {
+		if entityHeader, body, err = m.SMIME.protect(contentType, body); err != nil {
+			return nil, fmt.Errorf("failed to apply S/MIME: %w", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", formatAddress(m.From))
+	writeHeader(&buf, "To", formatAddressList(m.To))
+	writeHeader(&buf, "Cc", formatAddressList(m.Cc))
+	if m.ReplyTo != "" {
+		writeHeader(&buf, "Reply-To", formatAddress(m.ReplyTo))
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("UTF-8", m.Subject))
+	writeHeader(&buf, "Date", m.Date.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", m.MessageID)
+	writeHeader(&buf, "MIME-Version", "1.0")
+	buf.WriteString(entityHeader)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	if m.DKIM != nil {
+		return m.DKIM.Sign(buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	if value == "" {
+		return
+	}
+	buf.WriteString(name + ": " + value + "\r\n")
+}
+
+// formatAddress renders an address, RFC 2047 encoding a non-ASCII display name.
+func formatAddress(entry string) string {
+	address, err := netmail.ParseAddress(entry)
+	if err != nil {
+		return entry
+	}
+	return address.String()
+}
+
+// formatAddressList renders addresses one per folded header line.
+func formatAddressList(list []string) string {
+	formatted := make([]string, 0, len(list))
+	for _, entry := range list {
+		formatted = append(formatted, formatAddress(entry))
+	}
+	return strings.Join(formatted, ",\r\n ")
+}
+
+func newMessageID(sender string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	domain := "cryptix.local"
+	if at := strings.LastIndex(sender, "@"); at >= 0 && at < len(sender)-1 {
+		domain = sender[at+1:]
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain), nil
+}
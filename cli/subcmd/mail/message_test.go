@@ -0,0 +1,185 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"mime"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Message
+		wantErr string
+	}{
+		{"valid", Message{From: "Alice <alice@example.com>", To: []string{"bob@example.com"}}, ""},
+		{"only bcc", Message{From: "alice@example.com", Bcc: []string{"bob@example.com"}}, ""},
+		{"bad from", Message{From: "alice", To: []string{"bob@example.com"}}, "invalid From address"},
+		{"bad reply-to", Message{From: "alice@example.com", ReplyTo: "nobody", To: []string{"bob@example.com"}}, "invalid Reply-To address"},
+		{"bad to", Message{From: "alice@example.com", To: []string{"bob@"}}, "invalid To address"},
+		{"bad cc", Message{From: "alice@example.com", To: []string{"bob@example.com"}, Cc: []string{"carol"}}, "invalid Cc address"},
+		{"bad bcc", Message{From: "alice@example.com", To: []string{"bob@example.com"}, Bcc: []string{"@example.com"}}, "invalid Bcc address"},
+		{"no recipients", Message{From: "alice@example.com"}, "no recipients"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.msg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseAddressList(t *testing.T) {
+	got, err := ParseAddressList(" Alice <alice@example.com>, bob@example.com ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`"Alice" <alice@example.com>`, "<bob@example.com>"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("ParseAddressList() = %q, want %q", got, want)
+	}
+
+	if got, err := ParseAddressList("  "); err != nil || got != nil {
+		t.Fatalf("ParseAddressList(blank) = %q, %v, want nil, nil", got, err)
+	}
+	if _, err := ParseAddressList("alice@example.com, not an address"); err == nil {
+		t.Fatal("ParseAddressList accepted an invalid address")
+	}
+}
+
+func TestMessageBytesOmitsBcc(t *testing.T) {
+	msg := NewMessage("alice@example.com")
+	msg.To = []string{"bob@example.com"}
+	msg.Cc = []string{"carol@example.com"}
+	msg.Bcc = []string{"dave@example.com"}
+	msg.TextBody = "hello"
+
+	parsed := renderMessage(t, msg)
+	if got := parsed.Header.Get("Bcc"); got != "" {
+		t.Fatalf("Bcc header = %q, want none", got)
+	}
+	if strings.Contains(string(mustBytes(t, msg)), "dave@example.com") {
+		t.Fatal("Bcc recipient leaked into the rendered message")
+	}
+	if got := parsed.Header.Get("Cc"); got != "<carol@example.com>" {
+		t.Fatalf("Cc header = %q", got)
+	}
+	if got := strings.Join(msg.Recipients(), ","); got != "bob@example.com,carol@example.com,dave@example.com" {
+		t.Fatalf("Recipients() = %q, want To, Cc and Bcc", got)
+	}
+}
+
+func TestMessageBytesEncodesSubject(t *testing.T) {
+	msg := NewMessage("alice@example.com")
+	msg.To = []string{"bob@example.com"}
+	msg.Subject = "Grüße, 秘密のファイル"
+	msg.TextBody = "hello"
+
+	raw := mustBytes(t, msg)
+	header, _, _ := bytes.Cut(raw, []byte("\r\n\r\n"))
+	for _, b := range header {
+		if b >= 0x80 {
+			t.Fatalf("header contains raw non-ASCII byte %#x", b)
+		}
+	}
+
+	parsed := renderMessage(t, msg)
+	decoded, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != msg.Subject {
+		t.Fatalf("decoded Subject = %q, want %q", decoded, msg.Subject)
+	}
+
+	msg.Subject = "plain subject"
+	msg.MessageID = ""
+	if got := renderMessage(t, msg).Header.Get("Subject"); got != "plain subject" {
+		t.Fatalf("ASCII Subject = %q, want it unencoded", got)
+	}
+}
+
+func TestMessageBytesGeneratesIDAndDate(t *testing.T) {
+	msg := &Message{From: "Alice <alice@example.com>", To: []string{"bob@example.com"}, TextBody: "hello"}
+
+	before := time.Now().Add(-time.Second)
+	parsed := renderMessage(t, msg)
+	if msg.Date.IsZero() || msg.Date.Before(before) {
+		t.Fatalf("Date = %v, want it set to now", msg.Date)
+	}
+	date, err := parsed.Header.Date()
+	if err != nil {
+		t.Fatalf("Date header: %v", err)
+	}
+	if !date.Equal(msg.Date.Truncate(time.Second)) {
+		t.Fatalf("Date header = %v, want %v", date, msg.Date)
+	}
+
+	id := parsed.Header.Get("Message-ID")
+	if id != msg.MessageID || !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Fatalf("Message-ID = %q, want a generated <...@example.com> ID stored on the message", id)
+	}
+
+	// Rendering again keeps the ID and date, so a spooled message is the same message.
+	again := renderMessage(t, msg)
+	if again.Header.Get("Message-ID") != id || again.Header.Get("Date") != parsed.Header.Get("Date") {
+		t.Fatal("second render changed the Message-ID or Date")
+	}
+
+	other := &Message{From: "alice@example.com", To: []string{"bob@example.com"}}
+	renderMessage(t, other)
+	if other.MessageID == id {
+		t.Fatal("two messages got the same Message-ID")
+	}
+}
+
+func TestMessageBytesDKIM(t *testing.T) {
+	msg := NewMessage("alice@example.com")
+	msg.To = []string{"bob@example.com"}
+	msg.TextBody = "hello"
+	if renderMessage(t, msg).Header.Get(dkimHeader) != "" {
+		t.Fatal("message without a signer was DKIM signed")
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.DKIM = &DKIMSigner{Domain: "example.com", Selector: "test", Key: key}
+	if !strings.Contains(renderMessage(t, msg).Header.Get(dkimHeader), "d=example.com") {
+		t.Fatal("message with a signer was not DKIM signed")
+	}
+}
+
+func mustBytes(t *testing.T, msg *Message) []byte {
+	t.Helper()
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() = %v", err)
+	}
+	return raw
+}
+
+func renderMessage(t *testing.T, msg *Message) *netmail.Message {
+	t.Helper()
+	parsed, err := netmail.ReadMessage(bytes.NewReader(mustBytes(t, msg)))
+	if err != nil {
+		t.Fatalf("rendered message does not parse: %v", err)
+	}
+	io.Copy(io.Discard, parsed.Body)
+	return parsed
+}
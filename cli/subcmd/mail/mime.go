@@ -0,0 +1,150 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+)
+
+// maxLineLength is the base64 line length required by RFC 2045.
+const maxLineLength = 76
+
+// Attachment is a file sent alongside the message body.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// buildMIMEBody assembles a multipart/mixed body holding a multipart/alternative part
+// (text/plain, then text/html) followed by the attachments. It returns the
+// Content-Type header value for the message and the encoded body.
+func buildMIMEBody(htmlBody, textBody string, attachments []Attachment) (string, []byte, error) {
+	var body bytes.Buffer
+	mixed := multipart.NewWriter(&body)
+
+	var alt bytes.Buffer
+	alternative := multipart.NewWriter(&alt)
+	if err := writeTextPart(alternative, "text/plain", textBody); err != nil {
+		return "", nil, err
+	}
+	if err := writeTextPart(alternative, "text/html", htmlBody); err != nil {
+		return "", nil, err
+	}
+	if err := alternative.Close(); err != nil {
+		return "", nil, err
+	}
+
+	altHeader := textproto.MIMEHeader{}
+	altHeader.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative.Boundary()}))
+	altPart, err := mixed.CreatePart(altHeader)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := altPart.Write(alt.Bytes()); err != nil {
+		return "", nil, err
+	}
+
+	for _, attachment := range attachments {
+		if err := writeAttachment(mixed, attachment); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if err := mixed.Close(); err != nil {
+		return "", nil, err
+	}
+
+	contentType := mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mixed.Boundary()})
+	return contentType, body.Bytes(), nil
+}
+
+// writeTextPart writes a quoted-printable encoded UTF-8 text part.
+func writeTextPart(w *multipart.Writer, contentType, content string) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"charset": "UTF-8"}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeAttachment writes an attachment part with line-wrapped base64 encoding.
+func writeAttachment(w *multipart.Writer, attachment Attachment) error {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"name": attachment.Filename}))
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	header.Set("Content-Transfer-Encoding", "base64")
+
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	lines := &lineWrapper{w: part}
+	encoder := base64.NewEncoder(base64.StdEncoding, lines)
+	if _, err := encoder.Write(attachment.Data); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return lines.finish()
+}
+
+// lineWrapper breaks a stream into CRLF terminated lines of maxLineLength bytes.
+type lineWrapper struct {
+	w   io.Writer
+	col int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := maxLineLength - l.col
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.col += n
+		p = p[n:]
+
+		if l.col == maxLineLength {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.col = 0
+		}
+	}
+	return written, nil
+}
+
+// finish terminates a partially filled last line.
+func (l *lineWrapper) finish() error {
+	if l.col == 0 {
+		return nil
+	}
+	_, err := io.WriteString(l.w, "\r\n")
+	l.col = 0
+	return err
+}
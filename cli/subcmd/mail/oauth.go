@@ -0,0 +1,261 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v2"
+)
+
+// gmailScope grants SMTP access through XOAUTH2.
+const gmailScope = "https://mail.google.com/"
+
+// googleScopes are requested together during consent so that a single token
+// serves both the Drive upload and XOAUTH2 SMTP authentication.
+var googleScopes = []string{drive.DriveFileScope, gmailScope}
+
+// authorizationTimeout bounds how long the loopback flow waits for the browser.
+const authorizationTimeout = 5 * time.Minute
+
+// loadOAuthConfig reads the OAuth client credentials from OAUTH_CREDENTIALS_PATH.
+func loadOAuthConfig() (*oauth2.Config, error) {
+	credentialsPath := env.Vars.OAUTH_CREDENTIALS_PATH
+	b, err := os.ReadFile(credentialsPath)
+	if err != nil {
+		utility.Error("Unable to read credentials file: %s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"credentialsPath": credentialsPath,
+			"err":             err,
+		}).Error("Unable to read credentials file")
+		return nil, err
+	}
+
+	// Parse the credentials and create a config.
+	config, err := google.ConfigFromJSON(b, googleScopes...)
+	if err != nil {
+		utility.Error("Unable to parse credentials file: %s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"credentialsPath": credentialsPath,
+			"err":             err,
+		}).Error("Unable to parse credentials file")
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func getClient(config *oauth2.Config) (*http.Client, error) {
+	source, err := getTokenSource(config)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(context.Background(), source), nil
+}
+
+// getTokenSource returns a source of valid access tokens. Expired tokens are
+// refreshed automatically and the refreshed token is written back to disk.
+func getTokenSource(config *oauth2.Config) (oauth2.TokenSource, error) {
+	// The token file stores the user's access and refresh tokens, and is created
+	// automatically when the authorization flow completes for the first time.
+	tokFile := env.Vars.OAuthTokenPath
+	tok, err := tokenFromFile(tokFile)
+	if err != nil {
+		logger.Logger.WithFields(logrus.Fields{
+			"tokenPath": tokFile,
+			"err":       err,
+		}).Info("No usable OAuth2 token, starting authorization")
+		if tok, err = getTokenFromWeb(config); err != nil {
+			return nil, err
+		}
+		if err := saveToken(tokFile, tok); err != nil {
+			return nil, err
+		}
+	}
+
+	return &savingTokenSource{
+		base: config.TokenSource(context.Background(), tok),
+		path: tokFile,
+		last: tok.AccessToken,
+	}, nil
+}
+
+// savingTokenSource persists every newly issued token so refreshes survive restarts.
+type savingTokenSource struct {
+	mu   sync.Mutex
+	base oauth2.TokenSource
+	path string
+	last string
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken != s.last {
+		if err := saveToken(s.path, tok); err != nil {
+			// The token is still good for this run; only the next one re-authorizes.
+			logger.Logger.WithFields(logrus.Fields{"err": err}).Warn("Unable to save refreshed OAuth2 token")
+		}
+		s.last = tok.AccessToken
+		logger.Logger.Info("OAuth2 token refreshed")
+	}
+	return tok, nil
+}
+
+// getTokenFromWeb runs the loopback authorization flow (RFC 8252): consent happens
+// in the browser, which Google then redirects to a one-shot listener on 127.0.0.1
+// carrying the authorization code. The state parameter and a PKCE verifier tie the
+// redirect to this request.
+func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to start the OAuth2 redirect listener: %w", err)
+	}
+	defer listener.Close()
+
+	// Copy the config so the loopback redirect does not leak into later refreshes.
+	loopback := *config
+	loopback.RedirectURL = fmt.Sprintf("http://%s/", listener.Addr().String())
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+	authURL := loopback.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+
+	type callback struct {
+		code string
+		err  error
+	}
+	result := make(chan callback, 1)
+	server := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if query.Get("state") != state {
+				http.Error(w, "Authorization state mismatch.", http.StatusBadRequest)
+				return
+			}
+			var cb callback
+			switch {
+			case query.Get("error") != "":
+				cb.err = fmt.Errorf("authorization was denied: %s", query.Get("error"))
+				fmt.Fprintln(w, "Authorization was denied. You can close this window.")
+			case query.Get("code") == "":
+				cb.err = errors.New("authorization redirect carried no code")
+				http.Error(w, "No authorization code received.", http.StatusBadRequest)
+			default:
+				cb.code = query.Get("code")
+				fmt.Fprintln(w, "Cryptix is authorized. You can close this window.")
+			}
+			select {
+			case result <- cb:
+			default:
+			}
+		}),
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	utility.Info("Authorize cryptix in your browser. If it does not open, visit:\n%s", authURL)
+	if err := utility.OpenInBrowser(authURL); err != nil {
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Warn("Unable to open the browser for authorization")
+	}
+
+	var cb callback
+	select {
+	case cb = <-result:
+	case <-time.After(authorizationTimeout):
+		return nil, fmt.Errorf("no authorization received within %s", authorizationTimeout)
+	}
+	if cb.err != nil {
+		return nil, cb.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tok, err := loopback.Exchange(ctx, cb.code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("unable to exchange the authorization code: %w", err)
+	}
+	utility.Success("Authorization complete")
+	logger.Logger.Info("OAuth2 authorization complete")
+	return tok, nil
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Retrieves a token from a local file.
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tok := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(tok)
+	return tok, err
+}
+
+// saveToken writes the token readable only by the current user. The file is
+// replaced rather than truncated, so a token file created with wider permissions
+// does not keep them.
+func saveToken(path string, token *oauth2.Token) error {
+	path = filepath.Clean(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("unable to create token directory: %w", err)
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".token-*")
+	if err != nil {
+		return fmt.Errorf("unable to cache OAuth2 token: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to cache OAuth2 token: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to cache OAuth2 token: %w", err)
+	}
+	logger.Logger.WithFields(logrus.Fields{"tokenPath": path}).Info("OAuth2 token saved")
+	return nil
+}
@@ -0,0 +1,238 @@
+package mail
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// outboxBaseDelay is the wait after the first failed attempt; it doubles with
+	// every further attempt up to outboxMaxDelay.
+	outboxBaseDelay = time.Minute
+	outboxMaxDelay  = 6 * time.Hour
+)
+
+// ErrNotSpooled is returned when a message cannot be spooled because an attachment
+// is not an encrypted envelope, or because it is an S/MIME message whose keys are
+// not persisted. The outbox never stores plaintext.
+var ErrNotSpooled = errors.New("only messages whose attachments are cryptix envelopes can be spooled")
+
+// OutboxAttempt records one failed delivery attempt.
+type OutboxAttempt struct {
+	At    time.Time `json:"at"`
+	Error string    `json:"error"`
+}
+
+// OutboxEntry is a message waiting in the outbox for redelivery.
+type OutboxEntry struct {
+	ID        string          `json:"id"`
+	Created   time.Time       `json:"created"`
+	Transport string          `json:"transport"`
+	Message   *Message        `json:"message"`
+	Attempts  []OutboxAttempt `json:"attempts"`
+	// NextAttempt is when flush may try the entry again.
+	NextAttempt time.Time `json:"next_attempt"`
+	// Failed is set when the last attempt failed permanently; flush skips the entry
+	// until it is retried explicitly.
+	Failed bool `json:"failed,omitempty"`
+}
+
+// LastError returns the error of the most recent attempt.
+func (e *OutboxEntry) LastError() string {
+	if len(e.Attempts) == 0 {
+		return ""
+	}
+	return e.Attempts[len(e.Attempts)-1].Error
+}
+
+// Due reports whether flush should try the entry now.
+func (e *OutboxEntry) Due(now time.Time) bool {
+	return !e.Failed && !e.NextAttempt.After(now)
+}
+
+// recordFailure appends an attempt and schedules the next one with exponential backoff.
+func (e *OutboxEntry) recordFailure(err error) {
+	now := time.Now()
+	e.Attempts = append(e.Attempts, OutboxAttempt{At: now, Error: err.Error()})
+
+	delay := outboxBaseDelay << (len(e.Attempts) - 1)
+	if delay > outboxMaxDelay || delay <= 0 {
+		delay = outboxMaxDelay
+	}
+	e.NextAttempt = now.Add(delay)
+	e.Failed = IsPermanent(err)
+}
+
+// Outbox is the on-disk spool of undelivered messages, one JSON file per message.
+type Outbox struct {
+	Dir string
+}
+
+// OpenOutbox returns the outbox in OUTBOX_DIR.
+func OpenOutbox() *Outbox {
+	return &Outbox{Dir: env.Vars.OutboxDir}
+}
+
+// Spool stores msg after a failed delivery over transport. Messages whose
+// attachments are not cryptix envelopes are refused with ErrNotSpooled.
+func (o *Outbox) Spool(transport Transport, msg *Message, cause error) (*OutboxEntry, error) {
+	if msg.SMIME != nil {
+		return nil, ErrNotSpooled
+	}
+	for _, attachment := range msg.Attachments {
+		if _, err := crypt.ParseEnvelope(attachment.Data); err != nil {
+			return nil, ErrNotSpooled
+		}
+	}
+	// Render once so the Message-ID and Date are fixed before spooling.
+	if _, err := msg.Bytes(); err != nil {
+		return nil, err
+	}
+
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	entry := &OutboxEntry{
+		ID:        time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(random),
+		Created:   time.Now(),
+		Transport: transport.Name(),
+		Message:   msg,
+	}
+	entry.recordFailure(cause)
+
+	if err := o.Save(entry); err != nil {
+		return nil, err
+	}
+	logger.Logger.WithFields(logrus.Fields{
+		"id":        entry.ID,
+		"transport": entry.Transport,
+		"to":        strings.Join(msg.Recipients(), ","),
+	}).Info("Message spooled to outbox")
+	return entry, nil
+}
+
+// Save writes an entry, replacing the file atomically.
+func (o *Outbox) Save(entry *OutboxEntry) error {
+	if err := os.MkdirAll(o.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create outbox: %w", err)
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := o.path(entry.ID)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	return nil
+}
+
+// List returns the spooled entries, oldest first.
+func (o *Outbox) List() ([]*OutboxEntry, error) {
+	files, err := filepath.Glob(filepath.Join(o.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*OutboxEntry, 0, len(files))
+	for _, file := range files {
+		entry, err := readOutboxEntry(file)
+		if err != nil {
+			logger.Logger.WithFields(logrus.Fields{"file": file, "err": err}).Warn("Skipping unreadable outbox entry")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Created.Before(entries[j].Created) })
+	return entries, nil
+}
+
+// Get returns the entry with the given ID.
+func (o *Outbox) Get(id string) (*OutboxEntry, error) {
+	entry, err := readOutboxEntry(o.path(filepath.Base(id)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no outbox entry %s", id)
+	}
+	return entry, err
+}
+
+// Deliver attempts one delivery of entry. A delivered entry is removed from the
+// outbox; otherwise the attempt is recorded and the next one scheduled.
+func (o *Outbox) Deliver(transport Transport, entry *OutboxEntry) error {
+	err := transport.Deliver(entry.Message)
+	if err == nil {
+		if removeErr := os.Remove(o.path(entry.ID)); removeErr != nil {
+			return fmt.Errorf("delivered, but failed to remove outbox entry: %w", removeErr)
+		}
+		logger.Logger.WithFields(logrus.Fields{"id": entry.ID, "transport": transport.Name()}).Info("Outbox entry delivered")
+		return nil
+	}
+
+	entry.recordFailure(err)
+	if saveErr := o.Save(entry); saveErr != nil {
+		return fmt.Errorf("%w (and failed to update outbox entry: %v)", err, saveErr)
+	}
+	logger.Logger.WithFields(logrus.Fields{
+		"id":       entry.ID,
+		"attempts": len(entry.Attempts),
+		"err":      err,
+	}).Warn("Outbox delivery failed")
+	return err
+}
+
+func (o *Outbox) path(id string) string {
+	return filepath.Join(o.Dir, id+".json")
+}
+
+func readOutboxEntry(path string) (*OutboxEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry OutboxEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse outbox entry %s: %w", filepath.Base(path), err)
+	}
+	if entry.Message == nil {
+		return nil, fmt.Errorf("outbox entry %s holds no message", filepath.Base(path))
+	}
+	return &entry, nil
+}
+
+// spoolUndelivered queues msg in the outbox after a transient delivery failure and
+// returns the entry, or nil when the failure is permanent or spooling is refused.
+func spoolUndelivered(transport Transport, msg *Message, cause error) *OutboxEntry {
+	if IsPermanent(cause) {
+		return nil
+	}
+
+	entry, err := OpenOutbox().Spool(transport, msg, cause)
+	if err != nil {
+		utility.Warning("Message not queued in the outbox: %s", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Warn("Message not spooled")
+		return nil
+	}
+
+	utility.Warning("Message queued in the outbox as %s, run 'cryptix outbox flush' to redeliver it", entry.ID)
+	return entry
+}
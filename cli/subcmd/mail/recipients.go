@@ -0,0 +1,147 @@
+package mail
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+)
+
+// Delivery states shown in the per-recipient status table.
+const (
+	statusSent    = "sent"
+	statusFailed  = "failed"
+	statusSkipped = "skipped"
+	statusQueued  = "queued"
+)
+
+// recipientDelivery tracks one recipient of a per-recipient send.
+type recipientDelivery struct {
+	Address string
+	Key     *rsa.PublicKey
+	KeyID   string
+	Status  string
+	Err     error
+}
+
+// sendPerRecipient encrypts the plaintext for each recipient's key and sends each
+// recipient a separate message, so no one sees who else received it. With
+// --shared-envelope all recipients get the same multi-recipient envelope. It prints
+// a status table and reports whether every delivery succeeded.
+func sendPerRecipient(transport Transport, tmpl *EmailTemplate, addresses []string) bool {
+	plaintext := readPlaintext()
+
+	deliveries := make([]*recipientDelivery, 0, len(addresses))
+	var keys []*rsa.PublicKey
+	for _, address := range addresses {
+		delivery := &recipientDelivery{Address: bareAddress(address)}
+		deliveries = append(deliveries, delivery)
+
+		key, err := subcmd.ResolveRecipientKey(delivery.Address)
+		if err != nil {
+			delivery.Status, delivery.Err = statusSkipped, fmt.Errorf("no key: %w", err)
+			continue
+		}
+		delivery.Key = key
+		delivery.KeyID, _ = crypt.KeyFingerprint(key)
+		keys = append(keys, key)
+	}
+
+	var shared []byte
+	if sharedEnvelope && len(keys) > 0 {
+		encryptedMsg, recipientKeys, err := crypt.HybridEncryptionForRecipients(plaintext, keys)
+		if err == nil {
+			shared, err = crypt.MarshalMultiEnvelope(encryptedMsg, recipientKeys)
+		}
+		if err != nil {
+			utility.Info("Aborting operation: %s", utility.Red("Encryption generation"))
+			logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Multi-recipient encryption")
+			return false
+		}
+	}
+
+	fileName := envelopeName + env.Vars.JSON_FORMAT
+	for _, delivery := range deliveries {
+		if delivery.Key == nil {
+			continue
+		}
+
+		envelope := shared
+		if envelope == nil {
+			encryptedMsg, encryptedAESKey, err := crypt.HybridEncryption(plaintext, delivery.Key)
+			if err == nil {
+				envelope, err = crypt.MarshalEnvelope(encryptedMsg, encryptedAESKey)
+			}
+			if err != nil {
+				delivery.Status, delivery.Err = statusFailed, err
+				continue
+			}
+		}
+
+		msg := NewMessage(SenderAddress())
+		msg.DKIM = dkimSigner
+		msg.Subject = subject
+		msg.ReplyTo = replyTo
+		msg.To = []string{delivery.Address}
+
+		vars, err := attachOrUpload(msg, fileName, envelope)
+		if err != nil {
+			delivery.Status, delivery.Err = statusFailed, fmt.Errorf("upload failed: %w", err)
+			continue
+		}
+		if err := tmpl.Render(msg, vars); err != nil {
+			delivery.Status, delivery.Err = statusFailed, err
+			continue
+		}
+		if err := sendHtmlEmailWithRetry(transport, msg, 3, 2*time.Second); err != nil {
+			delivery.Status, delivery.Err = statusFailed, err
+			if entry := spoolUndelivered(transport, msg, err); entry != nil {
+				delivery.Status, delivery.Err = statusQueued, fmt.Errorf("in outbox as %s: %w", entry.ID, err)
+			}
+			continue
+		}
+		delivery.Status = statusSent
+	}
+
+	return printDeliveryStatus(transport, deliveries)
+}
+
+// printDeliveryStatus prints one row per recipient and reports whether all were sent.
+func printDeliveryStatus(transport Transport, deliveries []*recipientDelivery) bool {
+	allSent := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RECIPIENT\tKEY\tSTATUS\tDETAIL")
+	for _, delivery := range deliveries {
+		keyID := "-"
+		if len(delivery.KeyID) >= 16 {
+			keyID = delivery.KeyID[:16]
+		}
+
+		status, detail := utility.Green(delivery.Status), "via "+transport.Name()
+		if delivery.Status != statusSent {
+			allSent = false
+			status, detail = utility.Red(delivery.Status), delivery.Err.Error()
+			if delivery.Status == statusSkipped || delivery.Status == statusQueued {
+				status = utility.Yellow(delivery.Status)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", delivery.Address, keyID, status, detail)
+
+		logger.Logger.WithFields(logrus.Fields{
+			"recipient": delivery.Address,
+			"kid":       delivery.KeyID,
+			"status":    delivery.Status,
+			"err":       delivery.Err,
+		}).Info("Recipient delivery")
+	}
+	w.Flush()
+	return allSent
+}
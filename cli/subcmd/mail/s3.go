@@ -0,0 +1,269 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+)
+
+const (
+	// s3MaxPresign is the longest validity AWS Signature Version 4 allows a presigned URL.
+	s3MaxPresign = 7 * 24 * time.Hour
+	// s3KeyPrefix groups cryptix uploads in the bucket.
+	s3KeyPrefix = "cryptix/"
+)
+
+// S3Store uploads to a bucket of S3 or an S3 compatible service such as MinIO, and
+// links to the object with a presigned GET URL, so recipients need no account.
+type S3Store struct {
+	Endpoint  *url.URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as endpoint/bucket instead of bucket.endpoint,
+	// as most self-hosted services expect.
+	PathStyle bool
+	Client    *http.Client
+}
+
+// S3StoreFromEnv builds the S3 store from the S3_* variables.
+func S3StoreFromEnv() (*S3Store, error) {
+	if env.Vars.S3Bucket == "" || env.Vars.S3AccessKey == "" || env.Vars.S3SecretKey == "" {
+		return nil, errors.New("the s3 store requires S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY")
+	}
+	endpoint, err := url.Parse(env.Vars.S3Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", env.Vars.S3Endpoint)
+	}
+	return &S3Store{
+		Endpoint:  endpoint,
+		Region:    env.Vars.S3Region,
+		Bucket:    env.Vars.S3Bucket,
+		AccessKey: env.Vars.S3AccessKey,
+		SecretKey: env.Vars.S3SecretKey,
+		PathStyle: env.Vars.S3PathStyle,
+		Client:    http.DefaultClient,
+	}, nil
+}
+
+func (s *S3Store) Name() string { return StoreS3 }
+
+// Upload puts the object and presigns a GET for ttl, capped at the seven days
+// Signature Version 4 allows. A ttl of zero also gets seven days.
+func (s *S3Store) Upload(ctx context.Context, name string, data []byte, recipients []string, ttl time.Duration) (*Share, error) {
+	object, err := objectName(name)
+	if err != nil {
+		return nil, err
+	}
+	key := s3KeyPrefix + object
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.sign(req, data, time.Now())
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to upload to S3: %s", responseError(resp))
+	}
+
+	if ttl <= 0 || ttl > s3MaxPresign {
+		ttl = s3MaxPresign
+	}
+	return &Share{
+		ID:         object,
+		Backend:    StoreS3,
+		Name:       name,
+		Link:       s.presign(http.MethodGet, key, ttl, time.Now()),
+		Recipients: recipients,
+		Created:    time.Now(),
+	}, nil
+}
+
+// Revoke deletes the object, the only way to withdraw a presigned URL.
+func (s *S3Store) Revoke(ctx context.Context, share *Share, action string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(s3KeyPrefix+share.ID).String(), nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, nil, time.Now())
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete from S3: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("failed to delete from S3: %s", responseError(resp))
+	}
+}
+
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.Endpoint
+	if s.PathStyle {
+		u.Path = "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + s.Endpoint.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	return &u
+}
+
+// sign adds a Signature Version 4 Authorization header to req, whose body is payload.
+func (s *S3Store) sign(req *http.Request, payload []byte, now time.Time) {
+	now = now.UTC()
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	signedHeaders, canonicalHeaders := s3CanonicalHeaders(headers)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := s.scope(now)
+	signature := s.signature(now, scope, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// presign returns a URL granting method on key for expires without credentials.
+func (s *S3Store) presign(method, key string, expires time.Duration, now time.Time) string {
+	now = now.UTC()
+	u := s.objectURL(key)
+	scope := s.scope(now)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	signedHeaders, canonicalHeaders := s3CanonicalHeaders(map[string]string{"host": u.Host})
+	canonicalRequest := strings.Join([]string{
+		method,
+		s3EscapePath(u.Path),
+		s3CanonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	u.RawQuery = s3CanonicalQuery(query) + "&X-Amz-Signature=" + s.signature(now, scope, canonicalRequest)
+	return u.String()
+}
+
+func (s *S3Store) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+}
+
+func (s *S3Store) signature(now time.Time, scope, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3CanonicalHeaders returns the signed header list and the canonical header block.
+func s3CanonicalHeaders(headers map[string]string) (string, string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return strings.Join(names, ";"), canonical.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func s3EscapePath(path string) string {
+	return s3Escape(path, false)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, and '/' in
+// paths, as Signature Version 4 requires.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
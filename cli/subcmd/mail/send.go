@@ -4,51 +4,370 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package mail
 
 import (
-	"encoding/base64"
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/url"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
 	"github.com/Kshitiz-Mhto/cryptix/utility"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
 	sourcePath string
 	mail       string
 	subject    string
+	cc         string
+	bcc        string
+	replyTo    string
+	via        string
+
+	message        string
+	messageFile    string
+	to             string
+	envelopeName   string
+	allowPlaintext bool
+	sharedEnvelope bool
+
+	dryRun  bool
+	outPath string
+	preview bool
+
+	templateName  string
+	attachLimit   int64
+	uploadTimeout time.Duration
+	shareTTL      time.Duration
+	store         string
+
+	smimeEncrypt   bool
+	smimeSign      bool
+	smimeCipher    string
+	recipientCerts []string
+
+	batchPath   string
+	resultsPath string
+	workers     int
+	rate        float64
+
+	// dkimSigner signs every message of the run, nil without DKIM_DOMAIN.
+	dkimSigner *DKIMSigner
 )
 
 // SendMailCmd represents the send command
 var SendMailCmd = &cobra.Command{
 	Use:     "send",
 	Short:   "It basically help to send mail with attachment",
-	Example: "stegomail send --source <path/to/file> --mail <email_address> --subject <mail_subject>",
+	Example: "stegomail send --source <path/to/file> --mail <email_address> --subject <mail_subject>\nstegomail send --message <message_content> --to alice@corp.com\nstegomail send --file <path/to/secret> --to alice@corp.com --mail team@corp.com\nstegomail send --file <path/to/secret> --to alice@corp.com,bob@corp.com\nstegomail send --batch recipients.csv --file <path/to/secret> --workers 8 --rate 5\nstegomail send --message <message_content> --to alice@corp.com --dry-run --out msg.eml --preview\nstegomail send --template invite --mail bob@corp.com\nstegomail send --file <path/to/secret> --mail alice@corp.com --smime --smime-sign",
 	Run:     runSendMailCmd,
 }
 
 func runSendMailCmd(cmd *cobra.Command, args []string) {
-	absSourceFilePath, err := filepath.Abs(sourcePath)
+	tmpl := loadEmailTemplate()
+
+	transport, err := selectTransport()
 	if err != nil {
-		utility.Info("Aborting operation: %s", utility.Red("Absolute path retrieval"))
-		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Absolute path retrieval")
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("Transport configuration"))
+		logger.Logger.WithFields(logrus.Fields{"via": via, "err": err}).Error("Transport configuration")
 		os.Exit(1)
 	}
 
-	/*
-		Upload file to Google Drive
-		downloadLink, err := UploadFileToGoogleDrive(absSourceFilePath)
+	// Load the DKIM key now rather than when the first message renders.
+	if dkimSigner, err = DefaultDKIMSigner(); err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("DKIM configuration"))
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("DKIM configuration")
+		os.Exit(1)
+	}
+
+	// Check the storage configuration now rather than when the first large envelope uploads.
+	if _, err := NewUploader(store); err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("Storage configuration"))
+		logger.Logger.WithFields(logrus.Fields{"store": store, "err": err}).Error("Storage configuration")
+		os.Exit(1)
+	}
+
+	if batchPath != "" {
+		interval, err := rateInterval(rate)
 		if err != nil {
-			utility.Error("Failed to upload file to Google Drive: %s", err)
-			logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to upload file to Google Drive")
+			utility.Error("%s", err)
+			os.Exit(1)
+		}
+		if !sendBatch(transport, tmpl, batchPath, resultsPath, workers, interval) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if smimeEncrypt {
+		// S/MIME encrypts the one message for all of its recipients, so --to only
+		// names them another way.
+		if to != "" && mail != "" {
+			utility.Error("With --smime the message is encrypted for every recipient, give them with --mail, --cc and --bcc")
+			os.Exit(1)
+		}
+		if mail == "" {
+			mail, to = to, ""
+		}
+	} else if (message != "" || messageFile != "") && to == "" {
+		// Encrypt for the --mail recipients when no --to is given
+		if mail == "" {
+			utility.Error("--message and --file need --to <email_address> to look up the recipient's key")
+			os.Exit(1)
+		}
+		to, mail = mail, ""
+	}
+
+	recipients, err := ParseAddressList(to)
+	if err != nil {
+		utility.Error("%s", err)
+		os.Exit(1)
+	}
+
+	// Several --to addresses get their own envelope and their own message.
+	if len(recipients) > 1 && tmpl.Envelope {
+		if mail != "" || cc != "" || bcc != "" {
+			utility.Error("--to with several addresses sends one message per recipient, --mail, --cc and --bcc cannot be combined with it")
+			os.Exit(1)
+		}
+		if !sendPerRecipient(transport, tmpl, recipients) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if mail == "" {
+		mail = to
+	}
+
+	msg := NewMessage(SenderAddress())
+	msg.DKIM = dkimSigner
+	msg.Subject = subject
+	msg.ReplyTo = replyTo
+
+	for _, list := range []struct {
+		value string
+		dest  *[]string
+	}{{mail, &msg.To}, {cc, &msg.Cc}, {bcc, &msg.Bcc}} {
+		if *list.dest, err = ParseAddressList(list.value); err != nil {
+			utility.Error("%s", err)
+			os.Exit(1)
+		}
+	}
+	if err := msg.Validate(); err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("Message validation"))
+		os.Exit(1)
+	}
+
+	vars := map[string]interface{}{}
+	if tmpl.Envelope {
+		var fileName string
+		var fileData []byte
+		switch {
+		case sourcePath != "":
+			fileName, fileData = loadSourceEnvelope(sourcePath)
+		case smimeEncrypt:
+			// The S/MIME layer protects the payload, so the recipient's mail client
+			// can open it without cryptix.
+			fileName, fileData = plaintextName(), readPlaintext()
+		default:
+			fileName, fileData = encryptForRecipient()
+		}
+
+		if vars, err = attachOrUpload(msg, fileName, fileData); err != nil {
+			utility.Error("Failed to upload %s: %s", fileName, err)
+			utility.Info("Aborting operation: %s", utility.Red("Envelope upload"))
+			logger.Logger.WithFields(logrus.Fields{"file": fileName, "err": err}).Error("Envelope upload")
 			os.Exit(1)
 		}
-	*/
+		// A plaintext payload under S/MIME is opened by the recipient's mail client,
+		// a --source envelope still needs cryptix.
+		vars["smime"] = smimeEncrypt && sourcePath == ""
+	}
+
+	if smimeEncrypt || smimeSign {
+		msg.SMIME = loadSMIMEOptions(msg.Recipients())
+	}
+
+	success := HTMLTemplateMailHandler(transport, tmpl, msg, vars)
+	if !success {
+		utility.Error("Failed to send mail")
+		logger.Logger.Error("Failed to send mail")
+		os.Exit(1)
+	}
+
+	if dryRun || preview {
+		utility.Success("Message rendered with %s, nothing was sent", transport.Name())
+		return
+	}
+
+	utility.Success("Email sent successfully via %s!!", transport.Name())
+	logger.Logger.WithFields(logrus.Fields{"transport": transport.Name()}).Info("Email sent successfully!!")
+}
+
+// attachOrUpload attaches the envelope to msg when it fits within --attach-limit,
+// and otherwise uploads it and leaves only the link, with the envelope's SHA-256 so
+// the recipient can check the download. Files that are not envelopes are always
+// attached, as uploading them would publish plaintext. It returns the template
+// variables describing the file.
+func attachOrUpload(msg *Message, fileName string, fileData []byte) (map[string]interface{}, error) {
+	sum := sha256.Sum256(fileData)
+	vars := map[string]interface{}{
+		"filename":     fileName,
+		"filesize":     len(fileData),
+		"sha256":       hex.EncodeToString(sum[:]),
+		"downloadlink": "",
+		"smime":        false,
+		"time":         time.Now().Format(time.RFC1123),
+	}
+
+	_, err := crypt.ParseEnvelope(fileData)
+	isEnvelope := err == nil
+	if int64(len(fileData)) <= attachLimit || !isEnvelope {
+		if !isEnvelope && int64(len(fileData)) > attachLimit {
+			utility.Warning("%s is %d bytes, over the attachment limit, but is attached because it is not an envelope", fileName, len(fileData))
+		}
+		msg.Attachments = append(msg.Attachments, envelopeAttachment(fileName, fileData))
+		return vars, nil
+	}
 
-	//test
-	downloadLink := "https://drive.google.com/file/d/1BSOF_hPOZqycyKYuzBNOBPe83KFKX76M/view?usp=sharing"
+	if dryRun || preview {
+		// Rendering must not publish anything: no upload, no sharing, no ledger entry.
+		utility.Info("%s is %d bytes, over the %d byte attachment limit, it is not uploaded with --dry-run or --preview and the link is a placeholder", fileName, len(fileData), attachLimit)
+		vars["downloadlink"] = linkWithDigest(placeholderLink(fileName), vars["sha256"].(string))
+		return vars, nil
+	}
+
+	share, err := uploadEnvelope(fileName, fileData, msg.Recipients())
+	if err != nil {
+		return nil, err
+	}
+	utility.Info("%s is %d bytes, over the %d byte attachment limit, sending a link instead", fileName, len(fileData), attachLimit)
+	logger.Logger.WithFields(logrus.Fields{
+		"file":   fileName,
+		"size":   len(fileData),
+		"sha256": vars["sha256"],
+	}).Info("Envelope uploaded instead of attached")
+	vars["downloadlink"] = linkWithDigest(share.Link, vars["sha256"].(string))
+	return vars, nil
+}
+
+// placeholderLink stands in for the link of an envelope that --dry-run and
+// --preview do not upload. The .invalid domain never resolves.
+func placeholderLink(fileName string) string {
+	return "https://upload.invalid/" + url.PathEscape(filepath.Base(fileName))
+}
+
+// uploadEnvelope stores an envelope too large to attach in the --store backend,
+// readable by the recipients only where the backend allows it, and records the
+// share in the ledger to expire after --share-ttl. Shares whose TTL has passed are
+// revoked first, so expiry also happens without a scheduled job.
+func uploadEnvelope(fileName string, fileData []byte, recipients []string) (*Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	expired, err := NewShareRevoker().Expire(ctx, env.Vars.ShareExpiryAction)
+	if err != nil {
+		utility.Warning("%s", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Warn("Share expiry")
+	}
+	for _, share := range expired {
+		utility.Info("Share %s of %s expired and was %sd", share.ID, share.Name, share.Action)
+	}
+
+	uploader, err := NewUploader(store)
+	if err != nil {
+		return nil, err
+	}
+	share, err := uploader.Upload(ctx, fileName, fileData, recipients, shareTTL)
+	if err != nil {
+		return nil, err
+	}
+	logger.Logger.WithFields(logrus.Fields{
+		"file":  fileName,
+		"store": uploader.Name(),
+		"id":    share.ID,
+	}).Info("Envelope uploaded")
+	recordShare(share, shareTTL)
+	return share, nil
+}
+
+func envelopeAttachment(fileName string, fileData []byte) Attachment {
+	return Attachment{
+		Filename:    fileName,
+		ContentType: "application/octet-stream",
+		Data:        fileData,
+	}
+}
+
+// loadEmailTemplate loads and validates the --template template, and checks that
+// a payload is given exactly when the template carries an envelope.
+func loadEmailTemplate() *EmailTemplate {
+	tmpl, err := LoadTemplate(templateName)
+	if err == nil {
+		err = tmpl.Validate()
+	}
+	if err != nil {
+		utility.Error("%s", err)
+		utility.Info("Aborting operation: %s", utility.Red("Template validation"))
+		logger.Logger.WithFields(logrus.Fields{"template": templateName, "err": err}).Error("Template validation")
+		os.Exit(1)
+	}
+
+	hasPayload := sourcePath != "" || message != "" || messageFile != "" || batchPath != ""
+	switch {
+	case tmpl.Envelope && !hasPayload:
+		utility.Error("The %s template sends an envelope, give --source, --message, --file or --batch", tmpl.Name)
+		os.Exit(1)
+	case !tmpl.Envelope && hasPayload:
+		utility.Error("The %s template carries no envelope, --source, --message, --file and --batch cannot be used with it", tmpl.Name)
+		os.Exit(1)
+	}
+	return tmpl
+}
+
+// selectTransport returns the --via transport, or the dry-run and preview
+// transports when --dry-run or --preview is given, so that nothing is sent.
+func selectTransport() (Transport, error) {
+	if !dryRun && !preview {
+		return NewTransport(via)
+	}
+
+	var transports MultiTransport
+	if dryRun {
+		transports = append(transports, &DryRunTransport{Path: outPath})
+	}
+	if preview {
+		transports = append(transports, &PreviewTransport{})
+	}
+	if len(transports) == 1 {
+		return transports[0], nil
+	}
+	return transports, nil
+}
+
+// loadSourceEnvelope reads the file given with --source, refusing anything that is
+// not a cryptix envelope unless --allow-plaintext is set.
+func loadSourceEnvelope(path string) (string, []byte) {
+	absSourceFilePath, err := filepath.Abs(path)
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Absolute path retrieval"))
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Absolute path retrieval")
+		os.Exit(1)
+	}
 
 	fileData, err := os.ReadFile(absSourceFilePath)
 	if err != nil {
@@ -59,31 +378,179 @@ func runSendMailCmd(cmd *cobra.Command, args []string) {
 		}).Error("Failed to read encrypted file")
 		os.Exit(1)
 	}
-	encodedFileData := base64.StdEncoding.EncodeToString(fileData)
-	fileName := filepath.Base(absSourceFilePath)
 
-	vars := map[string]interface{}{
-		"filename":     fileName,
-		"filedata":     encodedFileData,
-		"downloadlink": downloadLink,
-		"time":         time.Now().Format(time.RFC1123),
+	if _, err := crypt.ParseEnvelope(fileData); err != nil {
+		if !allowPlaintext {
+			utility.Error("%s is %s", absSourceFilePath, err)
+			utility.Info("Encrypt it first, use --message/--file with --to, or pass --allow-plaintext to mail it as is")
+			utility.Info("Aborting operation: %s", utility.Red("Envelope validation"))
+			logger.Logger.WithFields(logrus.Fields{
+				"file": absSourceFilePath,
+				"err":  err,
+			}).Error("Refusing to mail a file that is not an envelope")
+			os.Exit(1)
+		}
+		utility.Warning("%s is not a cryptix envelope, mailing it unencrypted", absSourceFilePath)
+		logger.Logger.WithFields(logrus.Fields{"file": absSourceFilePath}).Warn("Mailing plaintext file")
 	}
 
-	success := HTMLTemplateMailHandler(mail, subject, vars)
-	if !success {
-		utility.Error("Failed to send mail")
-		logger.Logger.Error("Failed to send mail")
+	return filepath.Base(absSourceFilePath), fileData
+}
+
+// encryptForRecipient encrypts --message or the contents of --file for the key
+// certified for --to. Neither the plaintext nor the envelope touch the disk.
+func encryptForRecipient() (string, []byte) {
+	plaintext := readPlaintext()
+
+	pubKey, err := subcmd.ResolveRecipientKey(bareAddress(to))
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Recipient key lookup"))
 		os.Exit(1)
 	}
 
-	utility.Success("Email sent successfully!!")
-	logger.Logger.Info("Email sent successfully!!")
+	encryptedMsg, encryptedAESKey, err := crypt.HybridEncryption(plaintext, pubKey)
+	if err != nil {
+		utility.Info("Aborting operation: %s", utility.Red("Encryption generation"))
+		os.Exit(1)
+	}
+
+	envelope, err := crypt.MarshalEnvelope(encryptedMsg, encryptedAESKey)
+	if err != nil {
+		utility.Error("failed to marshal encrypted data: %v", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Marshal encryption")
+		os.Exit(1)
+	}
+
+	return envelopeName + env.Vars.JSON_FORMAT, envelope
+}
+
+// plaintextName is the attachment name of an unencrypted --message or --file.
+func plaintextName() string {
+	if messageFile != "" {
+		return filepath.Base(messageFile)
+	}
+	return envelopeName + env.Vars.TXT_FORMAT
+}
+
+// loadSMIMEOptions loads the signer for --smime-sign and the recipient certificates
+// for --smime. The sender's own certificate is added as a recipient when
+// SMIME_CERT is set, so the sent copy stays readable.
+func loadSMIMEOptions(addresses []string) *SMIMEOptions {
+	options := &SMIMEOptions{Cipher: smimeCipher}
+	var err error
+
+	if smimeSign {
+		if options.SignCert, options.SignKey, err = LoadSMIMESigner(); err != nil {
+			utility.Error("%s", err)
+			utility.Info("Aborting operation: %s", utility.Red("S/MIME signer loading"))
+			logger.Logger.WithFields(logrus.Fields{"err": err}).Error("S/MIME signer loading")
+			os.Exit(1)
+		}
+	}
+
+	if smimeEncrypt {
+		if smimeCipher != crypt.CMSCipherAES256CBC && smimeCipher != crypt.CMSCipherAES256GCM {
+			utility.Error("Unsupported --smime-cipher %q, use %s or %s", smimeCipher, crypt.CMSCipherAES256CBC, crypt.CMSCipherAES256GCM)
+			os.Exit(1)
+		}
+
+		var given []*x509.Certificate
+		for _, path := range recipientCerts {
+			cert, err := crypt.LoadCertificate(path)
+			if err != nil {
+				utility.Info("Aborting operation: %s", utility.Red("Recipient certificate loading"))
+				os.Exit(1)
+			}
+			given = append(given, cert)
+		}
+		if options.Recipients, err = ResolveRecipientCertificates(addresses, given); err != nil {
+			utility.Error("%s", err)
+			utility.Info("Aborting operation: %s", utility.Red("Recipient certificate lookup"))
+			logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Recipient certificate lookup")
+			os.Exit(1)
+		}
+
+		if env.Vars.SMIMECert != "" {
+			if cert, err := crypt.LoadCertificate(env.Vars.SMIMECert); err == nil {
+				if _, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+					options.Recipients = append(options.Recipients, cert)
+				}
+			}
+		}
+		utility.Success("Encrypting with S/MIME (%s) for %d certificates", smimeCipher, len(options.Recipients))
+	}
+	return options
+}
+
+// readPlaintext returns --message, or the contents of --file.
+func readPlaintext() []byte {
+	plaintext := []byte(message)
+	if messageFile != "" {
+		var err error
+		plaintext, err = os.ReadFile(filepath.Clean(messageFile))
+		if err != nil {
+			utility.Error("Failed to read file to encrypt: %s", err)
+			logger.Logger.WithFields(logrus.Fields{
+				"file": messageFile,
+				"err":  err,
+			}).Error("Failed to read file to encrypt")
+			os.Exit(1)
+		}
+	}
+	if len(plaintext) == 0 {
+		utility.Error("Message to be encrypted is empty.")
+		os.Exit(1)
+	}
+	return plaintext
 }
 
 func init() {
-	SendMailCmd.Flags().StringVarP(&sourcePath, "source", "s", "", "Specify the source path of file.[*Required]")
-	SendMailCmd.Flags().StringVarP(&mail, "mail", "m", "", "Specify mail address. [*Required]")
+	SendMailCmd.Flags().StringVarP(&sourcePath, "source", "s", "", "Specify the source path of the encrypted envelope. [*Required unless --message/--file]")
+	SendMailCmd.Flags().StringVarP(&mail, "mail", "m", "", "Specify mail address. [Default: --to]")
 	SendMailCmd.Flags().StringVarP(&subject, "subject", "S", "", "Specify your mail subject. [Optional]")
+	SendMailCmd.Flags().StringVarP(&cc, "cc", "", "", "Comma separated Cc addresses. [Optional]")
+	SendMailCmd.Flags().StringVarP(&bcc, "bcc", "", "", "Comma separated Bcc addresses, hidden from other recipients. [Optional]")
+	SendMailCmd.Flags().StringVarP(&replyTo, "reply-to", "", "", "Reply-To address. [Optional]")
+
+	SendMailCmd.Flags().StringVarP(&via, "via", "", "", "Delivery transport: smtp, sendmail, webhook or eml. [Default: MAIL_TRANSPORT]")
 
-	SendMailCmd.MarkFlagsRequiredTogether("source", "mail")
+	SendMailCmd.Flags().StringVarP(&message, "message", "", "", "Message to encrypt for --to and send. [Optional]")
+	SendMailCmd.Flags().StringVarP(&messageFile, "file", "", "", "File to encrypt for --to and send. [Optional]")
+	SendMailCmd.Flags().StringVarP(&to, "to", "t", "", "Encrypt for the contact or CA certified key of this email address, comma separated for one message per recipient. [Default: --mail]")
+	SendMailCmd.Flags().StringVarP(&envelopeName, "name", "n", "envelope", "Attachment name of the envelope created with --to, without extension. [Optional]")
+	SendMailCmd.Flags().BoolVarP(&sharedEnvelope, "shared-envelope", "", false, "With several --to addresses, encrypt once for all recipients instead of once per recipient. [Optional]")
+	SendMailCmd.Flags().BoolVarP(&allowPlaintext, "allow-plaintext", "", false, "Mail a --source that is not a cryptix envelope. [Optional]")
+
+	// --encrypt-for is accepted as a spelling of --to.
+	SendMailCmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "encrypt-for" {
+			name = "to"
+		}
+		return pflag.NormalizedName(name)
+	})
+
+	SendMailCmd.Flags().BoolVarP(&smimeEncrypt, "smime", "", false, "Encrypt the whole message with S/MIME for the recipients' X.509 certificates; --message/--file are attached unencrypted inside it. [Optional]")
+	SendMailCmd.Flags().BoolVarP(&smimeSign, "smime-sign", "", false, "Sign the message with S/MIME using SMIME_CERT and SMIME_KEY. [Optional]")
+	SendMailCmd.Flags().StringVarP(&smimeCipher, "smime-cipher", "", crypt.CMSCipherAES256CBC, "S/MIME content encryption: aes-256-cbc, or aes-256-gcm for recent clients. [Default: aes-256-cbc]")
+	SendMailCmd.Flags().StringSliceVarP(&recipientCerts, "recipient-cert", "", nil, "PEM certificate to encrypt --smime mail for, repeatable; recipients without one use the team CA. [Optional]")
+	SendMailCmd.Flags().Int64VarP(&attachLimit, "attach-limit", "", env.Vars.AttachmentLimit, "Largest envelope in bytes to attach; larger ones are uploaded and linked. [Default: ATTACHMENT_LIMIT]")
+	SendMailCmd.Flags().DurationVarP(&uploadTimeout, "upload-timeout", "", 30*time.Minute, "Give up an upload after this long; it can be resumed by sending the same envelope again. [Default: 30m]")
+	SendMailCmd.Flags().DurationVarP(&shareTTL, "share-ttl", "", env.Vars.ShareTTL, "Revoke uploaded envelopes after this long, 0 to keep them until 'shares revoke'. [Default: SHARE_TTL]")
+	SendMailCmd.Flags().StringVarP(&store, "store", "", "", "Where envelopes over --attach-limit are uploaded: drive, local, s3, webdav or sftp. [Default: STORE]")
+	SendMailCmd.Flags().StringVarP(&templateName, "template", "T", TemplateShare, "Email template: share, invite, key-request, or the path of an .html file replacing share. [Default: share]")
+	SendMailCmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "Write the message instead of sending it. [Optional]")
+	SendMailCmd.Flags().StringVarP(&outPath, "out", "o", "", "File or directory --dry-run writes the .eml to. [Default: stdout]")
+	SendMailCmd.Flags().BoolVarP(&preview, "preview", "", false, "Open the rendered HTML in the browser instead of sending. [Optional]")
+	SendMailCmd.Flags().StringVarP(&batchPath, "batch", "", "", "CSV with an email column, and optional pubkey, message, file and template variable columns, to send one encrypted message per row. [Optional]")
+	SendMailCmd.Flags().StringVarP(&resultsPath, "results", "", "", "Results file of --batch, rows recorded as sent are skipped on rerun. [Default: <batch>.results.csv]")
+	SendMailCmd.Flags().IntVarP(&workers, "workers", "w", 4, "Number of concurrent senders for --batch. [Default: 4]")
+	SendMailCmd.Flags().Float64VarP(&rate, "rate", "", 0, "Maximum messages per second for --batch, 0 for no limit. [Optional]")
+
+	SendMailCmd.MarkFlagsMutuallyExclusive("via", "dry-run")
+	SendMailCmd.MarkFlagsMutuallyExclusive("via", "preview")
+	SendMailCmd.MarkFlagsMutuallyExclusive("source", "message", "file")
+	SendMailCmd.MarkFlagsMutuallyExclusive("source", "to")
+	for _, flag := range []string{"source", "to", "mail", "cc", "bcc", "smime", "smime-sign"} {
+		SendMailCmd.MarkFlagsMutuallyExclusive("batch", flag)
+	}
 }
@@ -0,0 +1,216 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPStore uploads to a directory on an SFTP server. Host keys are checked
+// against SFTP_KNOWN_HOSTS; unknown hosts are refused rather than trusted.
+type SFTPStore struct {
+	Addr       string
+	User       string
+	Password   string
+	KeyPath    string
+	KnownHosts string
+	Dir        string
+}
+
+// SFTPStoreFromEnv builds the SFTP store from the SFTP_* variables.
+func SFTPStoreFromEnv() (*SFTPStore, error) {
+	if env.Vars.SFTPAddr == "" || env.Vars.SFTPUser == "" {
+		return nil, errors.New("the sftp store requires SFTP_ADDR and SFTP_USER")
+	}
+	if env.Vars.SFTPPassword == "" && env.Vars.SFTPKey == "" {
+		return nil, errors.New("the sftp store requires SFTP_KEY or SFTP_PASSWORD")
+	}
+	addr := env.Vars.SFTPAddr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	return &SFTPStore{
+		Addr:       addr,
+		User:       env.Vars.SFTPUser,
+		Password:   env.Vars.SFTPPassword,
+		KeyPath:    env.Vars.SFTPKey,
+		KnownHosts: env.Vars.SFTPKnownHosts,
+		Dir:        env.Vars.SFTPDir,
+	}, nil
+}
+
+func (s *SFTPStore) Name() string { return StoreSFTP }
+
+func (s *SFTPStore) Upload(ctx context.Context, name string, data []byte, recipients []string, ttl time.Duration) (*Share, error) {
+	object, err := objectName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	if s.Dir != "" {
+		if err := client.MkdirAll(s.Dir); err != nil {
+			return nil, fmt.Errorf("failed to create %s on the SFTP server: %w", s.Dir, err)
+		}
+	}
+	remotePath := path.Join(s.Dir, object)
+	f, err := client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s on the SFTP server: %w", remotePath, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to upload to SFTP: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to upload to SFTP: %w", err)
+	}
+
+	// Links carry the absolute path, as a relative one is relative to the uploader's home.
+	if absolute, err := client.RealPath(remotePath); err == nil {
+		remotePath = absolute
+	}
+	link := &url.URL{Scheme: "sftp", User: url.User(s.User), Host: s.Addr, Path: remotePath}
+	return &Share{
+		ID:         object,
+		Backend:    StoreSFTP,
+		Name:       name,
+		Link:       link.String(),
+		Recipients: recipients,
+		Created:    time.Now(),
+	}, nil
+}
+
+// Revoke removes the file; access is managed by the server's accounts.
+func (s *SFTPStore) Revoke(ctx context.Context, share *Share, action string) error {
+	u, err := url.Parse(share.Link)
+	if err != nil {
+		return fmt.Errorf("invalid link of share %s: %w", share.ID, err)
+	}
+	client, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	err = client.Remove(u.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Fetch downloads an sftp:// link from SFTP_ADDR. The credentials are only ever
+// offered to that server.
+func (s *SFTPStore) Fetch(ctx context.Context, link string) (io.ReadCloser, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	if host != s.Addr {
+		return nil, fmt.Errorf("%s is not on SFTP_ADDR %s", link, s.Addr)
+	}
+
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f, err := client.Open(u.Path)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &sftpFile{File: f, client: client}, nil
+}
+
+// sftpFile closes the connection along with the file.
+type sftpFile struct {
+	*sftp.File
+	client *sftpClient
+}
+
+func (f *sftpFile) Close() error {
+	err := f.File.Close()
+	f.client.Close()
+	return err
+}
+
+// sftpClient closes the SSH connection along with the SFTP session.
+type sftpClient struct {
+	*sftp.Client
+	ssh *ssh.Client
+}
+
+func (c *sftpClient) Close() error {
+	err := c.Client.Close()
+	c.ssh.Close()
+	return err
+}
+
+func (s *SFTPStore) connect(ctx context.Context) (*sftpClient, error) {
+	hostKeys, err := knownhosts.New(filepath.Clean(s.KnownHosts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SFTP_KNOWN_HOSTS: %w", err)
+	}
+
+	var auth []ssh.AuthMethod
+	if s.KeyPath != "" {
+		pemBytes, err := os.ReadFile(filepath.Clean(s.KeyPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SFTP_KEY: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SFTP_KEY: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if s.Password != "" {
+		auth = append(auth, ssh.Password(s.Password))
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SFTP server: %w", err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.Addr, &ssh.ClientConfig{
+		User:            s.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH handshake with %s failed: %w", s.Addr, err)
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+	return &sftpClient{Client: client, ssh: sshClient}, nil
+}
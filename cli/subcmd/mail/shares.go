@@ -0,0 +1,221 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/sirupsen/logrus"
+)
+
+// Actions taken on a share when it is revoked or expires.
+const (
+	// ShareActionDelete removes the uploaded file.
+	ShareActionDelete = "delete"
+	// ShareActionUnshare keeps the file but removes the recipients' access.
+	ShareActionUnshare = "unshare"
+)
+
+// Share is an upload made by send, recorded in the shares ledger so it can be
+// listed, revoked, and expired once its TTL has passed.
+type Share struct {
+	ID         string    `json:"id"`
+	Backend    string    `json:"backend"`
+	Name       string    `json:"name"`
+	Link       string    `json:"link"`
+	Recipients []string  `json:"recipients"`
+	Created    time.Time `json:"created"`
+	// Expires is nil for shares that never expire.
+	Expires *time.Time `json:"expires,omitempty"`
+	// Revoked is set once the share was deleted or unshared, by hand or on expiry.
+	Revoked *time.Time `json:"revoked,omitempty"`
+	Action  string     `json:"action,omitempty"`
+}
+
+// Status is active, expired (waiting to be revoked) or revoked.
+func (s *Share) Status(now time.Time) string {
+	switch {
+	case s.Revoked != nil:
+		return "revoked"
+	case s.Expired(now):
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+// Expired reports whether the share has outlived its TTL and is not revoked yet.
+func (s *Share) Expired(now time.Time) bool {
+	return s.Revoked == nil && s.Expires != nil && !s.Expires.After(now)
+}
+
+// ShareLedger is the on-disk record of shares, one JSON file per share.
+type ShareLedger struct {
+	Dir string
+}
+
+// OpenShareLedger returns the ledger in SHARES_DIR.
+func OpenShareLedger() *ShareLedger {
+	return &ShareLedger{Dir: env.Vars.SharesDir}
+}
+
+// Save writes a share, replacing the file atomically.
+func (l *ShareLedger) Save(share *Share) error {
+	if err := os.MkdirAll(l.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create shares ledger: %w", err)
+	}
+	data, err := json.MarshalIndent(share, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := l.path(share.ID)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to write share: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return fmt.Errorf("failed to write share: %w", err)
+	}
+	return nil
+}
+
+// List returns the recorded shares, oldest first.
+func (l *ShareLedger) List() ([]*Share, error) {
+	files, err := filepath.Glob(filepath.Join(l.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([]*Share, 0, len(files))
+	for _, file := range files {
+		share, err := readShare(file)
+		if err != nil {
+			logger.Logger.WithFields(logrus.Fields{"file": file, "err": err}).Warn("Skipping unreadable share")
+			continue
+		}
+		shares = append(shares, share)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Created.Before(shares[j].Created) })
+	return shares, nil
+}
+
+// Get returns the share with the given ID.
+func (l *ShareLedger) Get(id string) (*Share, error) {
+	share, err := readShare(l.path(filepath.Base(id)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no share %s", id)
+	}
+	return share, err
+}
+
+func (l *ShareLedger) path(id string) string {
+	return filepath.Join(l.Dir, id+".json")
+}
+
+func readShare(path string) (*Share, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	share := &Share{}
+	if err := json.Unmarshal(data, share); err != nil {
+		return nil, fmt.Errorf("invalid share %s: %w", path, err)
+	}
+	// Older ledgers wrote unset times as the zero time.
+	if share.Expires != nil && share.Expires.IsZero() {
+		share.Expires = nil
+	}
+	if share.Revoked != nil && share.Revoked.IsZero() {
+		share.Revoked = nil
+	}
+	return share, nil
+}
+
+// recordShare adds a share to the ledger, expiring after ttl when it is positive.
+// A failure is only logged: the upload worked, it just cannot be revoked by ID.
+func recordShare(share *Share, ttl time.Duration) {
+	if ttl > 0 {
+		expires := share.Created.Add(ttl)
+		share.Expires = &expires
+	}
+	if err := OpenShareLedger().Save(share); err != nil {
+		logger.Logger.WithFields(logrus.Fields{"id": share.ID, "err": err}).Warn("Unable to record share")
+	}
+}
+
+// ShareRevoker deletes or unshares shares. Backends are set up on first use, so
+// commands that end up with nothing to revoke need no credentials.
+type ShareRevoker struct {
+	ledger    *ShareLedger
+	uploaders map[string]Uploader
+}
+
+// NewShareRevoker returns a revoker updating the shares ledger.
+func NewShareRevoker() *ShareRevoker {
+	return &ShareRevoker{ledger: OpenShareLedger(), uploaders: map[string]Uploader{}}
+}
+
+// Revoke applies action, ShareActionDelete or ShareActionUnshare, to the share
+// and records it as revoked. A file already gone from the backend counts as revoked.
+func (r *ShareRevoker) Revoke(ctx context.Context, share *Share, action string) error {
+	if share.Revoked != nil {
+		return fmt.Errorf("share %s was already revoked on %s", share.ID, share.Revoked.Format(time.DateTime))
+	}
+	if action != ShareActionDelete && action != ShareActionUnshare {
+		return fmt.Errorf("unsupported share action %q, use delete or unshare", action)
+	}
+
+	uploader, ok := r.uploaders[share.Backend]
+	if !ok {
+		var err error
+		if uploader, err = NewUploader(share.Backend); err != nil {
+			return fmt.Errorf("share %s: %w", share.ID, err)
+		}
+		r.uploaders[share.Backend] = uploader
+	}
+	if err := uploader.Revoke(ctx, share, action); err != nil {
+		return err
+	}
+
+	revoked := time.Now()
+	share.Revoked = &revoked
+	share.Action = action
+	if err := r.ledger.Save(share); err != nil {
+		return fmt.Errorf("%s done, but failed to update the ledger: %w", action, err)
+	}
+	logger.Logger.WithFields(logrus.Fields{
+		"id":      share.ID,
+		"backend": share.Backend,
+		"action":  action,
+	}).Info("Share revoked")
+	return nil
+}
+
+// Expire revokes every share whose TTL has passed with action, and returns the
+// revoked shares. It stops at the first failure, leaving the rest for a later run.
+func (r *ShareRevoker) Expire(ctx context.Context, action string) ([]*Share, error) {
+	shares, err := r.ledger.List()
+	if err != nil {
+		return nil, err
+	}
+	var expired []*Share
+	now := time.Now()
+	for _, share := range shares {
+		if !share.Expired(now) {
+			continue
+		}
+		if err := r.Revoke(ctx, share, action); err != nil {
+			return expired, fmt.Errorf("failed to expire share %s: %w", share.ID, err)
+		}
+		expired = append(expired, share)
+	}
+	return expired, nil
+}
@@ -0,0 +1,177 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/ca"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+)
+
+// SMIMEOptions makes Bytes sign and/or encrypt the message body with S/MIME
+// (RFC 8551). Signing happens first, so the signature is hidden by the encryption.
+type SMIMEOptions struct {
+	// Recipients are the certificates the message is encrypted for. Empty means the
+	// message is only signed.
+	Recipients []*x509.Certificate
+	// Cipher is crypt.CMSCipherAES256CBC or crypt.CMSCipherAES256GCM.
+	Cipher string
+	// SignCert and SignKey produce a multipart/signed message when set.
+	SignCert *x509.Certificate
+	SignKey  crypto.Signer
+}
+
+// protect wraps the MIME entity made of contentType and body and returns the
+// header lines and body of the protected entity.
+func (o *SMIMEOptions) protect(contentType string, body []byte) (string, []byte, error) {
+	header := "Content-Type: " + contentType + "\r\n"
+	entity := append([]byte(header+"\r\n"), body...)
+
+	if o.SignKey != nil {
+		signature, err := crypt.SignCMSDetached(entity, o.SignCert, o.SignKey, nil)
+		if err != nil {
+			return "", nil, err
+		}
+
+		boundary := multipart.NewWriter(io.Discard).Boundary()
+		var signed bytes.Buffer
+		// The first part is the entity byte for byte; it is what the signature covers.
+		signed.WriteString("--" + boundary + "\r\n")
+		signed.Write(entity)
+		signed.WriteString("\r\n--" + boundary + "\r\n")
+		signed.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+		signed.WriteString("Content-Transfer-Encoding: base64\r\n")
+		signed.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+		if err := writeBase64(&signed, signature); err != nil {
+			return "", nil, err
+		}
+		signed.WriteString("--" + boundary + "--\r\n")
+
+		header = "Content-Type: " + mime.FormatMediaType("multipart/signed", map[string]string{
+			"protocol": "application/pkcs7-signature",
+			"micalg":   "sha-256",
+			"boundary": boundary,
+		}) + "\r\n"
+		body = signed.Bytes()
+		entity = append([]byte(header+"\r\n"), body...)
+	}
+
+	if len(o.Recipients) > 0 {
+		enveloped, err := crypt.EncryptCMS(entity, o.Recipients, o.Cipher)
+		if err != nil {
+			return "", nil, err
+		}
+		smimeType := "enveloped-data"
+		if o.Cipher == crypt.CMSCipherAES256GCM {
+			smimeType = "authEnveloped-data"
+		}
+
+		var encoded bytes.Buffer
+		if err := writeBase64(&encoded, enveloped); err != nil {
+			return "", nil, err
+		}
+		header = "Content-Type: " + mime.FormatMediaType("application/pkcs7-mime", map[string]string{
+			"smime-type": smimeType,
+			"name":       "smime.p7m",
+		}) + "\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"Content-Disposition: attachment; filename=\"smime.p7m\"\r\n"
+		body = encoded.Bytes()
+	}
+	return header, body, nil
+}
+
+func writeBase64(w io.Writer, data []byte) error {
+	lines := &lineWrapper{w: w}
+	encoder := base64.NewEncoder(base64.StdEncoding, lines)
+	if _, err := encoder.Write(data); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return lines.finish()
+}
+
+// LoadSMIMESigner reads the sender's certificate and key from SMIME_CERT and
+// SMIME_KEY and checks that they belong together.
+func LoadSMIMESigner() (*x509.Certificate, crypto.Signer, error) {
+	if env.Vars.SMIMECert == "" || env.Vars.SMIMEKey == "" {
+		return nil, nil, errors.New("S/MIME signing requires SMIME_CERT and SMIME_KEY")
+	}
+	cert, err := crypt.LoadCertificate(env.Vars.SMIMECert)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := crypt.LoadSigner(env.Vars.SMIMEKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	signerKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(certKey, signerKey) {
+		return nil, nil, fmt.Errorf("SMIME_KEY does not match the certificate in %s", env.Vars.SMIMECert)
+	}
+	return cert, key, nil
+}
+
+// ResolveRecipientCertificates returns a certificate for each address. Certificates
+// in given are matched by their email addresses; the rest come from the team CA.
+func ResolveRecipientCertificates(addresses []string, given []*x509.Certificate) ([]*x509.Certificate, error) {
+	byEmail := map[string]*x509.Certificate{}
+	for _, cert := range given {
+		for _, email := range certificateEmails(cert) {
+			byEmail[strings.ToLower(email)] = cert
+		}
+	}
+
+	var authority *ca.Authority
+	certs := append([]*x509.Certificate{}, given...)
+	for _, address := range addresses {
+		if _, ok := byEmail[strings.ToLower(address)]; ok {
+			continue
+		}
+		if authority == nil {
+			var err error
+			if authority, err = ca.Open(env.Vars.CA_DIR); err != nil {
+				return nil, fmt.Errorf("no certificate given for %s and the team CA is unavailable: %w", address, err)
+			}
+		}
+		cert, err := authority.Lookup(address)
+		if err != nil {
+			return nil, fmt.Errorf("no S/MIME certificate for %s, pass one with --recipient-cert: %w", address, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// certificateEmails returns the email addresses a certificate was issued for.
+func certificateEmails(cert *x509.Certificate) []string {
+	emails := append([]string{}, cert.EmailAddresses...)
+	for _, name := range cert.Subject.Names {
+		// emailAddress in the subject, used by older certificates.
+		if name.Type.String() == "1.2.840.113549.1.9.1" {
+			if email, ok := name.Value.(string); ok {
+				emails = append(emails, email)
+			}
+		}
+	}
+	return emails
+}
@@ -0,0 +1,349 @@
+package mail
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"golang.org/x/oauth2"
+)
+
+// TLS modes for the SMTP connection.
+const (
+	TLSNone             = "none"
+	TLSStartTLSRequired = "starttls-required"
+	TLSImplicit         = "implicit"
+)
+
+// SMTP authentication mechanisms.
+const (
+	AuthNone    = "none"
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+	AuthXOAuth2 = "xoauth2"
+)
+
+// SMTPConfig describes how to reach and authenticate with the SMTP server.
+type SMTPConfig struct {
+	// Addr is the host:port of the server.
+	Addr string
+	// Host is the server name used for TLS verification and PLAIN auth.
+	Host          string
+	TLSMode       string
+	RootCAs       *x509.CertPool
+	AuthMechanism string
+	Username      string
+	Password      string
+	// TokenSource supplies OAuth2 access tokens for XOAUTH2.
+	TokenSource    oauth2.TokenSource
+	ConnectTimeout time.Duration
+	// CommandTimeout bounds every read and write on the connection.
+	CommandTimeout time.Duration
+}
+
+// PermanentError marks a failure that will not succeed on retry, such as a 5xx reply
+// or a server that does not meet the configured TLS policy.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// IsPermanent reports whether err is a permanent SMTP failure: an explicit
+// PermanentError or a 5xx reply from the server. 4xx replies and network
+// errors are transient.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		return true
+	}
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code >= 500 && reply.Code < 600
+	}
+	return false
+}
+
+// SMTPConfigFromEnv builds the SMTP configuration from the environment.
+func SMTPConfigFromEnv() (*SMTPConfig, error) {
+	cfg := &SMTPConfig{
+		Addr:           env.Vars.SMTPAddress,
+		Host:           env.Vars.FromEmailSMTP,
+		TLSMode:        strings.ToLower(env.Vars.SMTPTLSMode),
+		AuthMechanism:  strings.ToLower(env.Vars.SMTPAuth),
+		Username:       env.Vars.FromEmail,
+		Password:       env.Vars.FromEmailPassword,
+		ConnectTimeout: time.Duration(env.Vars.SMTPConnectTimeout) * time.Second,
+		CommandTimeout: time.Duration(env.Vars.SMTPCommandTimeout) * time.Second,
+	}
+
+	if cfg.Host == "" {
+		host, _, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_ADDR %q: %w", cfg.Addr, err)
+		}
+		cfg.Host = host
+	}
+
+	switch cfg.TLSMode {
+	case TLSNone, TLSStartTLSRequired, TLSImplicit:
+	default:
+		return nil, fmt.Errorf("unsupported SMTP_TLS_MODE %q, use none, starttls-required or implicit", cfg.TLSMode)
+	}
+
+	switch cfg.AuthMechanism {
+	case AuthNone, AuthPlain, AuthLogin, AuthCRAMMD5:
+	case AuthXOAuth2:
+		config, err := loadOAuthConfig()
+		if err != nil {
+			return nil, fmt.Errorf("XOAUTH2 requires OAuth credentials: %w", err)
+		}
+		if cfg.TokenSource, err = getTokenSource(config); err != nil {
+			return nil, fmt.Errorf("XOAUTH2 authorization failed: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SMTP_AUTH %q, use none, plain, login, cram-md5 or xoauth2", cfg.AuthMechanism)
+	}
+
+	if env.Vars.SMTPCAFile != "" {
+		pemBytes, err := os.ReadFile(filepath.Clean(env.Vars.SMTPCAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SMTP_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no certificates found in SMTP_CA_FILE %s", env.Vars.SMTPCAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+func (c *SMTPConfig) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName: c.Host,
+		RootCAs:    c.RootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+func (c *SMTPConfig) auth() smtp.Auth {
+	switch c.AuthMechanism {
+	case AuthPlain:
+		return smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	case AuthLogin:
+		return &loginAuth{username: c.Username, password: c.Password, host: c.Host}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(c.Username, c.Password)
+	case AuthXOAuth2:
+		return &xoauth2Auth{username: c.Username, source: c.TokenSource}
+	default:
+		return nil
+	}
+}
+
+// Send delivers a rendered message to the recipients in a single SMTP session.
+func (c *SMTPConfig) Send(from string, to []string, message []byte) error {
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if auth := c.auth(); auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return &PermanentError{fmt.Errorf("server %s does not support authentication", c.Addr)}
+		}
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", rcpt, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// dial connects, greets the server and applies the TLS policy.
+func (c *SMTPConfig) dial() (*smtp.Client, error) {
+	dialer := &net.Dialer{Timeout: c.ConnectTimeout}
+
+	raw, err := dialer.Dial("tcp", c.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", c.Addr, err)
+	}
+
+	// The deadlines go beneath TLS, so net/smtp still sees a *tls.Conn and offers
+	// credentials over implicit TLS as it does after STARTTLS.
+	var conn net.Conn = &deadlineConn{Conn: raw, timeout: c.CommandTimeout}
+	if c.TLSMode == TLSImplicit {
+		tlsConn := tls.Client(conn, c.tlsConfig())
+		if err := tlsConn.Handshake(); err != nil {
+			raw.Close()
+			if isCertificateError(err) {
+				return nil, &PermanentError{fmt.Errorf("TLS connection to %s failed: %w", c.Addr, err)}
+			}
+			return nil, fmt.Errorf("TLS connection to %s failed: %w", c.Addr, err)
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := client.Hello(localName()); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if c.TLSMode == TLSStartTLSRequired {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, &PermanentError{fmt.Errorf("server %s does not offer STARTTLS", c.Addr)}
+		}
+		if err := client.StartTLS(c.tlsConfig()); err != nil {
+			client.Close()
+			if isCertificateError(err) {
+				return nil, &PermanentError{fmt.Errorf("STARTTLS with %s failed: %w", c.Addr, err)}
+			}
+			return nil, fmt.Errorf("STARTTLS with %s failed: %w", c.Addr, err)
+		}
+	}
+
+	return client, nil
+}
+
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
+
+func localName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "localhost"
+	}
+	return name
+}
+
+// deadlineConn refreshes the connection deadline before every read and write so that
+// each SMTP command, rather than the whole session, is bounded by the timeout.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (d *deadlineConn) Read(p []byte) (int, error) {
+	if d.timeout > 0 {
+		d.Conn.SetReadDeadline(time.Now().Add(d.timeout))
+	}
+	return d.Conn.Read(p)
+}
+
+func (d *deadlineConn) Write(p []byte) (int, error) {
+	if d.timeout > 0 {
+		d.Conn.SetWriteDeadline(time.Now().Add(d.timeout))
+	}
+	return d.Conn.Write(p)
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp does not provide.
+// Like PLAIN it sends the password in clear, so it requires TLS or localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+// xoauth2Auth implements the XOAUTH2 mechanism used by Gmail and Outlook. The access
+// token is fetched from the token source on every attempt, so it is refreshed when
+// it has expired.
+type xoauth2Auth struct {
+	username string
+	source   oauth2.TokenSource
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if a.source == nil {
+		return "", nil, errors.New("no OAuth2 token source configured")
+	}
+	token, err := a.source.Token()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to obtain OAuth2 token: %w", err)
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + token.AccessToken + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	// On failure the server sends a base64 JSON error as a challenge and expects
+	// an empty response before replying with the final error code.
+	return []byte{}, nil
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
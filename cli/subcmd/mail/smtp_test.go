@@ -0,0 +1,497 @@
+package mail
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSMTPHost is the name the fake server's certificate is issued for. It is not
+// localhost, so net/smtp only offers credentials over TLS as it would to a real server.
+const testSMTPHost = "mail.fake.test"
+
+// fakeSMTP is an in-process SMTP server speaking just enough of RFC 5321, STARTTLS
+// and AUTH PLAIN, LOGIN and CRAM-MD5 for SMTPConfig.
+type fakeSMTP struct {
+	addr string
+	tls  *tls.Config
+
+	// implicit serves TLS from the first byte, starttls offers STARTTLS.
+	implicit, starttls bool
+	// mechanisms are advertised in the AUTH extension.
+	mechanisms     []string
+	user, password string
+	// rcptReply replaces the 250 reply to RCPT TO, such as "550 5.1.1 no such user".
+	rcptReply string
+	// delay is waited before every reply after the greeting; stall never answers EHLO.
+	delay time.Duration
+	stall bool
+
+	mu        sync.Mutex
+	sessions  int
+	mechanism string
+	secure    bool
+	from      string
+	to        []string
+	data      string
+}
+
+func newFakeSMTP(t *testing.T, configure func(*fakeSMTP)) (*fakeSMTP, *x509.CertPool) {
+	t.Helper()
+	serverTLS, roots := testTLS(t, testSMTPHost)
+	s := &fakeSMTP{tls: serverTLS, user: "alice@example.com", password: "s3cret"}
+	if configure != nil {
+		configure(s)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.implicit {
+		ln = tls.NewListener(ln, s.tls)
+	}
+	s.addr = ln.Addr().String()
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s, roots
+}
+
+func (s *fakeSMTP) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	s.mu.Lock()
+	s.sessions++
+	s.mu.Unlock()
+
+	_, secure := conn.(*tls.Conn)
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) {
+		time.Sleep(s.delay)
+		tp.PrintfLine(format, args...)
+	}
+
+	tp.PrintfLine("220 %s ESMTP fake", testSMTPHost)
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if s.stall {
+				// Hold the connection open until the client gives up.
+				io.Copy(io.Discard, conn)
+				return
+			}
+			lines := []string{testSMTPHost}
+			if s.starttls && !secure {
+				lines = append(lines, "STARTTLS")
+			}
+			if len(s.mechanisms) > 0 {
+				lines = append(lines, "AUTH "+strings.Join(s.mechanisms, " "))
+			}
+			time.Sleep(s.delay)
+			for i, ext := range lines {
+				separator := "-"
+				if i == len(lines)-1 {
+					separator = " "
+				}
+				tp.PrintfLine("250%s%s", separator, ext)
+			}
+		case "STARTTLS":
+			reply("220 2.0.0 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			mechanism, err := s.authenticate(tp, arg)
+			if err != nil {
+				reply("535 5.7.8 %s", err)
+				continue
+			}
+			s.mu.Lock()
+			s.mechanism, s.secure = mechanism, secure
+			s.mu.Unlock()
+			reply("235 2.7.0 authenticated")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = arg
+			s.mu.Unlock()
+			reply("250 2.1.0 ok")
+		case "RCPT":
+			if s.rcptReply != "" {
+				reply("%s", s.rcptReply)
+				continue
+			}
+			s.mu.Lock()
+			s.to = append(s.to, arg)
+			s.mu.Unlock()
+			reply("250 2.1.5 ok")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = string(data)
+			s.mu.Unlock()
+			reply("250 2.0.0 queued")
+		case "QUIT":
+			reply("221 2.0.0 bye")
+			return
+		default:
+			reply("250 2.0.0 ok")
+		}
+	}
+}
+
+// authenticate runs one AUTH exchange and returns the mechanism used.
+func (s *fakeSMTP) authenticate(tp *textproto.Conn, arg string) (string, error) {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	mechanism = strings.ToUpper(mechanism)
+	challenge := func(prompt string) (string, error) {
+		tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		return string(decoded), err
+	}
+
+	switch mechanism {
+	case "PLAIN":
+		decoded, err := base64.StdEncoding.DecodeString(initial)
+		if err != nil {
+			return "", err
+		}
+		parts := strings.Split(string(decoded), "\x00")
+		if len(parts) != 3 || parts[1] != s.user || parts[2] != s.password {
+			return "", errors.New("bad PLAIN credentials")
+		}
+	case "LOGIN":
+		user, err := challenge("Username:")
+		if err != nil {
+			return "", err
+		}
+		password, err := challenge("Password:")
+		if err != nil {
+			return "", err
+		}
+		if user != s.user || password != s.password {
+			return "", errors.New("bad LOGIN credentials")
+		}
+	case "CRAM-MD5":
+		const nonce = "<1896.697170952@mail.fake.test>"
+		response, err := challenge(nonce)
+		if err != nil {
+			return "", err
+		}
+		mac := hmac.New(md5.New, []byte(s.password))
+		mac.Write([]byte(nonce))
+		if response != s.user+" "+hex.EncodeToString(mac.Sum(nil)) {
+			return "", errors.New("bad CRAM-MD5 digest")
+		}
+	default:
+		return "", fmt.Errorf("mechanism %s not supported", mechanism)
+	}
+	return mechanism, nil
+}
+
+func (s *fakeSMTP) sessionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions
+}
+
+// config returns a client configuration for the fake server.
+func (s *fakeSMTP) config(roots *x509.CertPool, tlsMode, auth string) *SMTPConfig {
+	return &SMTPConfig{
+		Addr:           s.addr,
+		Host:           testSMTPHost,
+		TLSMode:        tlsMode,
+		RootCAs:        roots,
+		AuthMechanism:  auth,
+		Username:       "alice@example.com",
+		Password:       "s3cret",
+		ConnectTimeout: 5 * time.Second,
+		CommandTimeout: 5 * time.Second,
+	}
+}
+
+func testMessage() *Message {
+	msg := NewMessage("alice@example.com")
+	msg.To = []string{"bob@example.com"}
+	msg.Bcc = []string{"carol@example.com"}
+	msg.Subject = "test"
+	msg.TextBody = "hello"
+	return msg
+}
+
+// testTLS returns a server TLS configuration with a self-signed certificate for
+// host, and a pool trusting it.
+func testTLS(t *testing.T, host string) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, roots
+}
+
+func TestSMTPSendTLSModes(t *testing.T) {
+	tests := []struct {
+		name       string
+		tlsMode    string
+		configure  func(*fakeSMTP)
+		wantSecure bool
+	}{
+		{"none", TLSNone, nil, false},
+		{"starttls-required", TLSStartTLSRequired, func(s *fakeSMTP) { s.starttls = true }, true},
+		{"implicit", TLSImplicit, func(s *fakeSMTP) { s.implicit = true }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, roots := newFakeSMTP(t, func(s *fakeSMTP) {
+				s.mechanisms = []string{"PLAIN"}
+				if tt.configure != nil {
+					tt.configure(s)
+				}
+			})
+			auth := AuthPlain
+			if !tt.wantSecure {
+				// Credentials are never sent in clear to a remote host.
+				auth = AuthNone
+			}
+
+			transport := &SMTPTransport{Config: server.config(roots, tt.tlsMode, auth)}
+			if err := transport.Deliver(testMessage()); err != nil {
+				t.Fatalf("Deliver() = %v", err)
+			}
+
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if server.from != "FROM:<alice@example.com>" {
+				t.Errorf("MAIL %s, want FROM:<alice@example.com>", server.from)
+			}
+			if got := strings.Join(server.to, ","); got != "TO:<bob@example.com>,TO:<carol@example.com>" {
+				t.Errorf("RCPT %s, want To and Bcc recipients", got)
+			}
+			if !strings.Contains(server.data, "Subject: test") || strings.Contains(server.data, "carol@example.com") {
+				t.Errorf("unexpected message data:\n%s", server.data)
+			}
+			if tt.wantSecure && (server.mechanism != "PLAIN" || !server.secure) {
+				t.Errorf("authenticated with %q over TLS %v, want PLAIN over TLS", server.mechanism, server.secure)
+			}
+		})
+	}
+}
+
+func TestSMTPStartTLSRequiredRefusesPlaintextServer(t *testing.T) {
+	server, roots := newFakeSMTP(t, nil)
+	err := server.config(roots, TLSStartTLSRequired, AuthNone).Send("alice@example.com", []string{"bob@example.com"}, []byte("x"))
+	if err == nil || !IsPermanent(err) || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("Send() = %v, want a permanent STARTTLS error", err)
+	}
+}
+
+func TestSMTPRefusesUntrustedCertificate(t *testing.T) {
+	for _, mode := range []string{TLSStartTLSRequired, TLSImplicit} {
+		t.Run(mode, func(t *testing.T) {
+			server, _ := newFakeSMTP(t, func(s *fakeSMTP) {
+				s.starttls = mode == TLSStartTLSRequired
+				s.implicit = mode == TLSImplicit
+			})
+			_, otherRoots := testTLS(t, testSMTPHost)
+			err := server.config(otherRoots, mode, AuthNone).Send("alice@example.com", []string{"bob@example.com"}, []byte("x"))
+			if err == nil || !IsPermanent(err) {
+				t.Fatalf("Send() = %v, want a permanent certificate error", err)
+			}
+		})
+	}
+}
+
+func TestSMTPAuthMechanisms(t *testing.T) {
+	for _, tt := range []struct{ auth, mechanism string }{
+		{AuthPlain, "PLAIN"},
+		{AuthLogin, "LOGIN"},
+		{AuthCRAMMD5, "CRAM-MD5"},
+	} {
+		t.Run(tt.auth, func(t *testing.T) {
+			server, roots := newFakeSMTP(t, func(s *fakeSMTP) {
+				s.starttls = true
+				s.mechanisms = []string{"PLAIN", "LOGIN", "CRAM-MD5"}
+			})
+			cfg := server.config(roots, TLSStartTLSRequired, tt.auth)
+			if err := cfg.Send("alice@example.com", []string{"bob@example.com"}, []byte("x")); err != nil {
+				t.Fatalf("Send() = %v", err)
+			}
+			server.mu.Lock()
+			mechanism := server.mechanism
+			server.mu.Unlock()
+			if mechanism != tt.mechanism {
+				t.Fatalf("authenticated with %q, want %q", mechanism, tt.mechanism)
+			}
+
+			cfg.Password = "wrong"
+			err := cfg.Send("alice@example.com", []string{"bob@example.com"}, []byte("x"))
+			if err == nil || !IsPermanent(err) {
+				t.Fatalf("Send() with a wrong password = %v, want a permanent 535 error", err)
+			}
+		})
+	}
+}
+
+func TestSMTPPlainAuthRefusedWithoutTLS(t *testing.T) {
+	for _, auth := range []string{AuthPlain, AuthLogin} {
+		t.Run(auth, func(t *testing.T) {
+			server, roots := newFakeSMTP(t, func(s *fakeSMTP) { s.mechanisms = []string{"PLAIN", "LOGIN"} })
+			err := server.config(roots, TLSNone, auth).Send("alice@example.com", []string{"bob@example.com"}, []byte("x"))
+			if err == nil || !strings.Contains(err.Error(), "unencrypted connection") {
+				t.Fatalf("Send() = %v, want the credentials withheld", err)
+			}
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if server.mechanism != "" {
+				t.Fatalf("credentials were sent in clear with %s", server.mechanism)
+			}
+		})
+	}
+}
+
+func TestSMTPCommandTimeout(t *testing.T) {
+	server, roots := newFakeSMTP(t, func(s *fakeSMTP) { s.stall = true })
+	cfg := server.config(roots, TLSNone, AuthNone)
+	cfg.CommandTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	err := cfg.Send("alice@example.com", []string{"bob@example.com"}, []byte("x"))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Send() = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Send() took %v to time out", elapsed)
+	}
+	if IsPermanent(err) {
+		t.Fatal("a timeout is reported as permanent")
+	}
+}
+
+func TestSMTPCommandTimeoutIsPerCommand(t *testing.T) {
+	// Every reply is slow, and the session as a whole takes longer than the
+	// timeout, but no single command does.
+	server, roots := newFakeSMTP(t, func(s *fakeSMTP) { s.delay = 100 * time.Millisecond })
+	cfg := server.config(roots, TLSNone, AuthNone)
+	cfg.CommandTimeout = 400 * time.Millisecond
+
+	start := time.Now()
+	if err := cfg.Send("alice@example.com", []string{"bob@example.com"}, []byte("x")); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < cfg.CommandTimeout {
+		t.Fatalf("session took %v, the test needs it to outlast the timeout", elapsed)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"permanent error", &PermanentError{errors.New("policy")}, true},
+		{"5xx reply", &textproto.Error{Code: 550, Msg: "no such user"}, true},
+		{"wrapped 5xx reply", fmt.Errorf("recipient rejected: %w", &textproto.Error{Code: 554, Msg: "rejected"}), true},
+		{"4xx reply", &textproto.Error{Code: 451, Msg: "try again later"}, false},
+		{"wrapped 4xx reply", fmt.Errorf("recipient rejected: %w", &textproto.Error{Code: 421, Msg: "closing"}), false},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.want {
+				t.Fatalf("IsPermanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		rcptReply    string
+		wantSessions int
+		wantErr      bool
+	}{
+		{"delivered", "", 1, false},
+		{"4xx is retried", "450 4.2.1 mailbox busy, try later", 3, true},
+		{"5xx is not retried", "550 5.1.1 no such user", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, roots := newFakeSMTP(t, func(s *fakeSMTP) { s.rcptReply = tt.rcptReply })
+			transport := &SMTPTransport{Config: server.config(roots, TLSNone, AuthNone)}
+
+			err := sendHtmlEmailWithRetry(transport, testMessage(), 3, time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sendHtmlEmailWithRetry() = %v, want error %v", err, tt.wantErr)
+			}
+			if got := server.sessionCount(); got != tt.wantSessions {
+				t.Fatalf("%d delivery attempts, want %d", got, tt.wantSessions)
+			}
+		})
+	}
+}
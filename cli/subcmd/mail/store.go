@@ -0,0 +1,295 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// Names of the storage backends envelopes too large to attach are uploaded to.
+const (
+	StoreDrive  = "drive"
+	StoreLocal  = "local"
+	StoreS3     = "s3"
+	StoreWebDAV = "webdav"
+	StoreSFTP   = "sftp"
+)
+
+// Uploader stores envelopes too large to attach and makes them available to the
+// recipients through a link.
+type Uploader interface {
+	// Name identifies the backend in logs and in the shares ledger.
+	Name() string
+	// Upload stores data as name and returns the share recording its link. Links
+	// that can expire by themselves stay valid for ttl.
+	Upload(ctx context.Context, name string, data []byte, recipients []string, ttl time.Duration) (*Share, error)
+	// Revoke deletes the share, or with ShareActionUnshare only withdraws the
+	// recipients' access where the backend distinguishes the two. A file that is
+	// already gone is not an error.
+	Revoke(ctx context.Context, share *Share, action string) error
+}
+
+// Fetcher downloads a file from a link created by an Uploader.
+type Fetcher interface {
+	Fetch(ctx context.Context, link string) (io.ReadCloser, error)
+}
+
+// NewUploader returns the backend with the given name, configured from the
+// environment. An empty name selects STORE.
+func NewUploader(name string) (Uploader, error) {
+	if name == "" {
+		name = env.Vars.Store
+	}
+
+	switch strings.ToLower(name) {
+	case StoreDrive:
+		return &DriveStore{}, nil
+	case StoreLocal:
+		if env.Vars.StoreDir == "" {
+			return nil, errors.New("the local store requires STORE_DIR")
+		}
+		return &LocalStore{Dir: env.Vars.StoreDir, BaseURL: env.Vars.StoreBaseURL}, nil
+	case StoreS3:
+		return S3StoreFromEnv()
+	case StoreWebDAV:
+		return WebDAVStoreFromEnv()
+	case StoreSFTP:
+		return SFTPStoreFromEnv()
+	default:
+		return nil, fmt.Errorf("unsupported store %q, use drive, local, s3, webdav or sftp", name)
+	}
+}
+
+// NewFetcher returns a fetcher able to download link: file and sftp links go to
+// their backends, Drive links through the Drive API, links below WEBDAV_URL with
+// the WebDAV credentials, and any other http(s) link, such as an S3 presigned URL,
+// with a plain GET.
+func NewFetcher(link string) (Fetcher, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link %q: %w", link, err)
+	}
+
+	switch {
+	case u.Scheme == "file":
+		return &LocalStore{}, nil
+	case u.Scheme == "sftp":
+		return SFTPStoreFromEnv()
+	case u.Scheme == "https" && u.Host == "drive.google.com":
+		return &DriveStore{}, nil
+	case u.Scheme == "https" || u.Scheme == "http":
+		if env.Vars.WebDAVURL != "" && strings.HasPrefix(link, strings.TrimSuffix(env.Vars.WebDAVURL, "/")+"/") {
+			return WebDAVStoreFromEnv()
+		}
+		if env.Vars.StoreBaseURL != "" && strings.HasPrefix(link, strings.TrimSuffix(env.Vars.StoreBaseURL, "/")+"/") {
+			return &LocalStore{Dir: env.Vars.StoreDir, BaseURL: env.Vars.StoreBaseURL}, nil
+		}
+		return &HTTPFetcher{Client: http.DefaultClient}, nil
+	default:
+		return nil, fmt.Errorf("unsupported link %q", link)
+	}
+}
+
+// objectName prefixes name with random hex, so uploads never overwrite each other
+// and links cannot be guessed from the file name.
+func objectName(name string) (string, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random) + "-" + filepath.Base(name), nil
+}
+
+// DriveStore uploads to the Google Drive of the OAuth account, shared with each
+// recipient's Google account. Drive links do not expire by themselves.
+type DriveStore struct {
+	service *drive.Service
+}
+
+func (s *DriveStore) Name() string { return StoreDrive }
+
+func (s *DriveStore) Upload(ctx context.Context, name string, data []byte, recipients []string, ttl time.Duration) (*Share, error) {
+	return UploadToGoogleDrive(ctx, name, data, recipients)
+}
+
+func (s *DriveStore) Revoke(ctx context.Context, share *Share, action string) error {
+	service, err := s.drive(ctx)
+	if err != nil {
+		return err
+	}
+
+	if action == ShareActionDelete {
+		err := service.Files.Delete(share.ID).Context(ctx).Do()
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	permissions, err := service.Permissions.List(share.ID).Context(ctx).Do()
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	recipients := map[string]bool{}
+	for _, recipient := range share.Recipients {
+		recipients[strings.ToLower(recipient)] = true
+	}
+	for _, permission := range permissions.Items {
+		// The owner's permission cannot be removed and must stay.
+		if permission.Role == "owner" || !recipients[strings.ToLower(permission.EmailAddress)] {
+			continue
+		}
+		err := service.Permissions.Delete(share.ID, permission.Id).Context(ctx).Do()
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to remove access of %s: %w", permission.EmailAddress, err)
+		}
+	}
+	return nil
+}
+
+func (s *DriveStore) drive(ctx context.Context) (*drive.Service, error) {
+	if s.service == nil {
+		service, _, err := newDriveService(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.service = service
+	}
+	return s.service, nil
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == 404
+}
+
+// Fetch downloads a drive.google.com/file/d/<id> link with the OAuth account,
+// which the file must be shared with.
+func (s *DriveStore) Fetch(ctx context.Context, link string) (io.ReadCloser, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "file" || parts[1] != "d" {
+		return nil, fmt.Errorf("%s is not a Drive file link", link)
+	}
+
+	service, err := s.drive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := service.Files.Get(parts[2]).Context(ctx).Download()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// LocalStore copies uploads into a directory, typically a share mounted by both
+// sides. Links are file URLs, or below BaseURL when a web server publishes Dir.
+type LocalStore struct {
+	Dir     string
+	BaseURL string
+}
+
+func (s *LocalStore) Name() string { return StoreLocal }
+
+func (s *LocalStore) Upload(ctx context.Context, name string, data []byte, recipients []string, ttl time.Duration) (*Share, error) {
+	object, err := objectName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create STORE_DIR: %w", err)
+	}
+	path, err := filepath.Abs(filepath.Join(s.Dir, object))
+	if err != nil {
+		return nil, err
+	}
+	// Readable by others, as the point is for recipients to read it; the content
+	// is an envelope only they can decrypt.
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to store %s: %w", name, err)
+	}
+
+	link := (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	if s.BaseURL != "" {
+		link = strings.TrimSuffix(s.BaseURL, "/") + "/" + url.PathEscape(object)
+	}
+	return &Share{
+		ID:         object,
+		Backend:    StoreLocal,
+		Name:       name,
+		Link:       link,
+		Recipients: recipients,
+		Created:    time.Now(),
+	}, nil
+}
+
+// Revoke removes the file; a plain directory has no per-recipient access to withdraw.
+func (s *LocalStore) Revoke(ctx context.Context, share *Share, action string) error {
+	err := os.Remove(filepath.Join(s.Dir, filepath.Base(share.ID)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStore) Fetch(ctx context.Context, link string) (io.ReadCloser, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "file" {
+		// A published STORE_DIR: read the file itself rather than over HTTP.
+		object, err := url.PathUnescape(strings.TrimPrefix(link, strings.TrimSuffix(s.BaseURL, "/")+"/"))
+		if err != nil {
+			return nil, err
+		}
+		return os.Open(filepath.Join(s.Dir, filepath.Base(object)))
+	}
+	return os.Open(filepath.FromSlash(u.Path))
+}
+
+// HTTPFetcher downloads links that need no credentials, like S3 presigned URLs.
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, link string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	return doDownload(f.Client, req)
+}
+
+// doDownload sends req and returns the body of a successful response.
+func doDownload(client *http.Client, req *http.Request) (io.ReadCloser, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("download failed: %s", responseError(resp))
+	}
+	return resp.Body, nil
+}
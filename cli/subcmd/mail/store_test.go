@@ -0,0 +1,207 @@
+package mail
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+)
+
+// withEnv changes env.Vars for the duration of the test.
+func withEnv(t *testing.T, set func(*env.Config)) {
+	t.Helper()
+	saved := env.Vars
+	set(&env.Vars)
+	t.Cleanup(func() { env.Vars = saved })
+}
+
+// storeTestEnv configures every backend, with WebDAV and a published STORE_DIR on
+// hosts of their own.
+func storeTestEnv(dir string) func(*env.Config) {
+	return func(c *env.Config) {
+		c.Store = StoreDrive
+		c.StoreDir = dir
+		c.StoreBaseURL = "https://files.example.com/cryptix/"
+		c.S3Endpoint = "https://s3.example.com"
+		c.S3Region = "eu-central-1"
+		c.S3Bucket = "envelopes"
+		c.S3AccessKey = "AKIDEXAMPLE"
+		c.S3SecretKey = "secret"
+		c.S3PathStyle = true
+		c.WebDAVURL = "https://cloud.example.com/dav/cryptix/"
+		c.WebDAVUser = "alice"
+		c.WebDAVPassword = "s3cret"
+		c.SFTPAddr = "sftp.example.com"
+		c.SFTPUser = "alice"
+		c.SFTPPassword = "s3cret"
+		c.SFTPDir = "cryptix"
+	}
+}
+
+func TestNewUploader(t *testing.T) {
+	dir := t.TempDir()
+	withEnv(t, storeTestEnv(dir))
+
+	tests := []struct {
+		name string
+		want Uploader
+	}{
+		{"", &DriveStore{}},
+		{"drive", &DriveStore{}},
+		{"LOCAL", &LocalStore{Dir: dir, BaseURL: "https://files.example.com/cryptix/"}},
+		{"webdav", &WebDAVStore{URL: "https://cloud.example.com/dav/cryptix", User: "alice", Password: "s3cret"}},
+		{"sftp", &SFTPStore{Addr: "sftp.example.com:22", User: "alice", Password: "s3cret", Dir: "cryptix"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploader, err := NewUploader(tt.name)
+			if err != nil {
+				t.Fatalf("NewUploader(%q) = %v", tt.name, err)
+			}
+			if webdav, ok := uploader.(*WebDAVStore); ok {
+				webdav.Client = nil
+			}
+			if sftp, ok := uploader.(*SFTPStore); ok {
+				sftp.KnownHosts = ""
+			}
+			if !reflect.DeepEqual(uploader, tt.want) {
+				t.Fatalf("NewUploader(%q) = %#v, want %#v", tt.name, uploader, tt.want)
+			}
+		})
+	}
+
+	s3, err := NewUploader("s3")
+	if err != nil {
+		t.Fatalf("NewUploader(s3) = %v", err)
+	}
+	store, ok := s3.(*S3Store)
+	if !ok || store.Endpoint.Host != "s3.example.com" || store.Region != "eu-central-1" || store.Bucket != "envelopes" || !store.PathStyle {
+		t.Fatalf("NewUploader(s3) = %#v", s3)
+	}
+
+	if _, err := NewUploader("ftp"); err == nil {
+		t.Fatal("NewUploader accepted an unknown store")
+	}
+}
+
+func TestNewUploaderRequiresConfiguration(t *testing.T) {
+	withEnv(t, func(c *env.Config) { *c = env.Config{} })
+	for _, name := range []string{"local", "s3", "webdav", "sftp"} {
+		if _, err := NewUploader(name); err == nil {
+			t.Errorf("NewUploader(%q) succeeded without configuration", name)
+		}
+	}
+}
+
+func TestNewFetcher(t *testing.T) {
+	dir := t.TempDir()
+	withEnv(t, storeTestEnv(dir))
+
+	tests := []struct {
+		link string
+		want interface{}
+	}{
+		{"file:///mnt/shared/abc-report.json", &LocalStore{}},
+		{"sftp://sftp.example.com/cryptix/abc-report.json", &SFTPStore{}},
+		{"https://drive.google.com/file/d/1a2b3c/view", &DriveStore{}},
+		{"https://cloud.example.com/dav/cryptix/abc-report.json", &WebDAVStore{}},
+		{"https://files.example.com/cryptix/abc-report.json", &LocalStore{}},
+		{"https://envelopes.s3.example.com/cryptix/abc-report.json?X-Amz-Signature=00", &HTTPFetcher{}},
+		// Only links below WEBDAV_URL and STORE_BASE_URL get their credentials or directory.
+		{"https://cloud.example.com/dav/cryptix-other/abc-report.json", &HTTPFetcher{}},
+		{"https://files.example.com/cryptixabc-report.json", &HTTPFetcher{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+			fetcher, err := NewFetcher(tt.link)
+			if err != nil {
+				t.Fatalf("NewFetcher() = %v", err)
+			}
+			if reflect.TypeOf(fetcher) != reflect.TypeOf(tt.want) {
+				t.Fatalf("NewFetcher() = %T, want %T", fetcher, tt.want)
+			}
+			switch f := fetcher.(type) {
+			case *WebDAVStore:
+				if f.User != "alice" || f.Password != "s3cret" {
+					t.Fatal("WebDAV link fetched without the WEBDAV_* credentials")
+				}
+			case *LocalStore:
+				if strings.HasPrefix(tt.link, "https://") && (f.Dir != dir || f.BaseURL != env.Vars.StoreBaseURL) {
+					t.Fatalf("published link fetched from %q below %q", f.Dir, f.BaseURL)
+				}
+			}
+		})
+	}
+
+	for _, link := range []string{"ftp://example.com/abc", "mailto:bob@example.com", "%zz"} {
+		if _, err := NewFetcher(link); err == nil {
+			t.Errorf("NewFetcher(%q) succeeded", link)
+		}
+	}
+}
+
+func TestNewFetcherSFTPRequiresConfiguration(t *testing.T) {
+	withEnv(t, func(c *env.Config) { c.SFTPAddr = "" })
+	if _, err := NewFetcher("sftp://sftp.example.com/cryptix/abc-report.json"); err == nil {
+		t.Fatal("NewFetcher returned an SFTP fetcher without SFTP_ADDR")
+	}
+}
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	for _, baseURL := range []string{"", "https://files.example.com/cryptix"} {
+		t.Run("base "+baseURL, func(t *testing.T) {
+			dir := t.TempDir()
+			withEnv(t, func(c *env.Config) {
+				c.StoreDir = dir
+				c.StoreBaseURL = baseURL
+			})
+			uploader, err := NewUploader(StoreLocal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := testEnvelope(t, 1024)
+
+			share, err := uploader.Upload(context.Background(), "Q3 report.json", data, nil, time.Hour)
+			if err != nil {
+				t.Fatalf("Upload() = %v", err)
+			}
+			wantPrefix := "file://" + filepath.ToSlash(dir) + "/"
+			if baseURL != "" {
+				wantPrefix = baseURL + "/"
+			}
+			if !strings.HasPrefix(share.Link, wantPrefix) {
+				t.Fatalf("link %s, want it below %s", share.Link, wantPrefix)
+			}
+
+			// The link is routed back to the directory, whichever form it takes.
+			fetcher, err := NewFetcher(share.Link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := fetcher.Fetch(context.Background(), share.Link)
+			if err != nil {
+				t.Fatalf("Fetch() = %v", err)
+			}
+			fetched, _ := io.ReadAll(body)
+			body.Close()
+			if string(fetched) != string(data) {
+				t.Fatal("Fetch() returned different bytes than were uploaded")
+			}
+
+			if err := uploader.Revoke(context.Background(), share, ShareActionDelete); err != nil {
+				t.Fatalf("Revoke() = %v", err)
+			}
+			if _, err := fetcher.Fetch(context.Background(), share.Link); err == nil {
+				t.Fatal("file still readable after Revoke()")
+			}
+			if err := uploader.Revoke(context.Background(), share, ShareActionDelete); err != nil {
+				t.Fatalf("Revoke() of a deleted file = %v, want nil", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/static"
+)
+
+// Named email templates selectable with send --template.
+const (
+	TemplateShare      = "share"
+	TemplateInvite     = "invite"
+	TemplateKeyRequest = "key-request"
+)
+
+// commonVars are available to every template.
+var commonVars = []string{"sender", "recipient", "subject", "time"}
+
+// templateSpec describes a named template: its files, the variables the sender
+// provides beyond commonVars, and whether the message carries an envelope.
+type templateSpec struct {
+	html, text string
+	subject    string
+	vars       []string
+	envelope   bool
+}
+
+func templateSpecs() map[string]templateSpec {
+	return map[string]templateSpec{
+		TemplateShare: {
+			html:     env.Vars.HTML_TEMPLATE,
+			text:     env.Vars.TEXT_TEMPLATE,
+			subject:  env.Vars.SUBJECT_DESC,
+			vars:     []string{"filename", "filesize", "sha256", "downloadlink", "smime"},
+			envelope: true,
+		},
+		TemplateInvite: {
+			html:    "invite.html",
+			text:    "invite.txt",
+			subject: "Invitation to exchange encrypted files with Cryptix",
+		},
+		TemplateKeyRequest: {
+			html:    "keyrequest.html",
+			text:    "keyrequest.txt",
+			subject: "Please send me your Cryptix public key",
+		},
+	}
+}
+
+// TemplateNames returns the names accepted by LoadTemplate.
+func TemplateNames() []string {
+	names := make([]string, 0, 3)
+	for name := range templateSpecs() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EmailTemplate is a parsed HTML template with its plain-text companion.
+type EmailTemplate struct {
+	Name string
+	// Envelope reports whether messages using the template carry an envelope.
+	Envelope bool
+
+	spec       templateSpec
+	html       *template.Template
+	text       *texttemplate.Template
+	htmlSource string
+	textSource string
+}
+
+// LoadTemplate parses a named template, or an HTML file given by path, which
+// replaces the share template. A .txt file next to it with the same base name is
+// used as its plain-text companion. Named template files are read from
+// TEMPLATE_DIR when present there and from the embedded defaults otherwise.
+func LoadTemplate(nameOrPath string) (*EmailTemplate, error) {
+	specs := templateSpecs()
+	spec, ok := specs[nameOrPath]
+	name := nameOrPath
+
+	var htmlData, textData []byte
+	var htmlSource, textSource string
+	var err error
+	if ok {
+		if htmlData, htmlSource, err = readTemplateFile(spec.html); err != nil {
+			return nil, err
+		}
+		if textData, textSource, err = readTemplateFile(spec.text); err != nil {
+			return nil, err
+		}
+	} else {
+		if !strings.HasSuffix(strings.ToLower(nameOrPath), ".html") {
+			return nil, fmt.Errorf("unknown template %q, use one of %s or a path to an .html file", nameOrPath, strings.Join(TemplateNames(), ", "))
+		}
+		spec, name = specs[TemplateShare], TemplateShare
+
+		htmlSource = filepath.Clean(nameOrPath)
+		if htmlData, err = os.ReadFile(htmlSource); err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+		textSource = strings.TrimSuffix(htmlSource, filepath.Ext(htmlSource)) + ".txt"
+		if textData, err = os.ReadFile(textSource); errors.Is(err, os.ErrNotExist) {
+			textData, textSource, err = readTemplateFile(spec.text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+	}
+
+	tmpl := &EmailTemplate{
+		Name:       name,
+		Envelope:   spec.envelope,
+		spec:       spec,
+		htmlSource: htmlSource,
+		textSource: textSource,
+	}
+	if tmpl.html, err = template.New(filepath.Base(htmlSource)).Option("missingkey=error").Parse(string(htmlData)); err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", htmlSource, err)
+	}
+	if tmpl.text, err = texttemplate.New(filepath.Base(textSource)).Option("missingkey=error").Parse(string(textData)); err != nil {
+		return nil, fmt.Errorf("failed to parse text template %s: %w", textSource, err)
+	}
+	return tmpl, nil
+}
+
+// readTemplateFile reads a template from TEMPLATE_DIR, falling back to the embedded
+// default, and returns its contents and where it came from.
+func readTemplateFile(file string) ([]byte, string, error) {
+	override := filepath.Join(env.Vars.TemplateDir, file)
+	data, err := os.ReadFile(override)
+	if err == nil {
+		return data, override, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("failed to read template: %w", err)
+	}
+
+	data, err = fs.ReadFile(static.Templates, file)
+	if err != nil {
+		return nil, "", fmt.Errorf("no template %s in %s or the built-in templates", file, env.Vars.TemplateDir)
+	}
+	return data, "built-in " + file, nil
+}
+
+// Validate reports variables the templates use but the sender does not provide.
+// extra names variables provided in addition to the template's own, such as the
+// columns of a batch file.
+func (t *EmailTemplate) Validate(extra ...string) error {
+	available := map[string]bool{}
+	for _, list := range [][]string{commonVars, t.spec.vars, extra} {
+		for _, name := range list {
+			available[name] = true
+		}
+	}
+
+	var problems []string
+	check := func(source string, trees []*parse.Tree) {
+		for _, name := range templateFields(trees) {
+			if !available[name] {
+				problems = append(problems, fmt.Sprintf("%s uses {{.%s}}", source, name))
+			}
+		}
+	}
+
+	var htmlTrees, textTrees []*parse.Tree
+	for _, tmpl := range t.html.Templates() {
+		htmlTrees = append(htmlTrees, tmpl.Tree)
+	}
+	for _, tmpl := range t.text.Templates() {
+		textTrees = append(textTrees, tmpl.Tree)
+	}
+	check(t.htmlSource, htmlTrees)
+	check(t.textSource, textTrees)
+
+	if len(problems) > 0 {
+		names := make([]string, 0, len(available))
+		for name := range available {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("template %s: %s, but only %s are provided", t.Name, strings.Join(problems, "; "), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// Render fills msg's HTML and plain-text bodies. An empty subject falls back to the
+// template's default subject.
+func (t *EmailTemplate) Render(msg *Message, vars map[string]interface{}) error {
+	if msg.Subject == "" {
+		msg.Subject = t.spec.subject
+	}
+
+	data := map[string]interface{}{
+		"sender":    senderName(),
+		"recipient": strings.Join(msg.Recipients(), ", "),
+		"subject":   msg.Subject,
+		"time":      time.Now().Format(time.RFC1123),
+	}
+	for name, value := range vars {
+		data[name] = value
+	}
+
+	var rendered bytes.Buffer
+	if err := t.html.Execute(&rendered, data); err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+	var renderedText bytes.Buffer
+	if err := t.text.Execute(&renderedText, data); err != nil {
+		return fmt.Errorf("failed to render text template: %w", err)
+	}
+
+	msg.HTMLBody = rendered.String()
+	msg.TextBody = renderedText.String()
+	return nil
+}
+
+func senderName() string {
+	if env.Vars.FromName != "" {
+		return env.Vars.FromName
+	}
+	return env.Vars.FromEmail
+}
+
+// templateFields returns the top-level fields ({{.name}}) referenced by the trees.
+// Fields inside range and with blocks refer to another value and are skipped.
+func templateFields(trees []*parse.Tree) []string {
+	seen := map[string]bool{}
+	var fields []string
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.FieldNode:
+			if name := n.Ident[0]; !seen[name] {
+				seen[name] = true
+				fields = append(fields, name)
+			}
+		}
+	}
+
+	for _, tree := range trees {
+		if tree != nil {
+			walk(tree.Root)
+		}
+	}
+	return fields
+}
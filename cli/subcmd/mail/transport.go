@@ -0,0 +1,363 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+)
+
+// Delivery transports selectable with send --via or MAIL_TRANSPORT.
+const (
+	TransportSMTP     = "smtp"
+	TransportSendmail = "sendmail"
+	TransportWebhook  = "webhook"
+	TransportEML      = "eml"
+)
+
+// envelopePEMType is the armor label used when an envelope travels as text.
+const envelopePEMType = "CRYPTIX ENVELOPE"
+
+// Transport delivers a message. Implementations report failures that will not
+// succeed on retry as PermanentError so sendHtmlEmailWithRetry stops early.
+type Transport interface {
+	// Name identifies the transport in logs and status output.
+	Name() string
+	Deliver(msg *Message) error
+}
+
+// NewTransport returns the transport with the given name, configured from the
+// environment. An empty name selects MAIL_TRANSPORT.
+func NewTransport(name string) (Transport, error) {
+	if name == "" {
+		name = env.Vars.MailTransport
+	}
+
+	switch strings.ToLower(name) {
+	case TransportSMTP:
+		cfg, err := SMTPConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return &SMTPTransport{Config: cfg}, nil
+	case TransportSendmail:
+		return &SendmailTransport{Path: env.Vars.SendmailPath}, nil
+	case TransportWebhook:
+		if env.Vars.WebhookURL == "" {
+			return nil, errors.New("the webhook transport requires WEBHOOK_URL")
+		}
+		return &WebhookTransport{
+			URL:     env.Vars.WebhookURL,
+			Token:   env.Vars.WebhookToken,
+			Timeout: time.Duration(env.Vars.WebhookTimeout) * time.Second,
+		}, nil
+	case TransportEML:
+		return &EMLTransport{Dir: env.Vars.EMLDir}, nil
+	default:
+		return nil, fmt.Errorf("unsupported transport %q, use smtp, sendmail, webhook or eml", name)
+	}
+}
+
+// SMTPTransport delivers through an SMTP server.
+type SMTPTransport struct {
+	Config *SMTPConfig
+}
+
+func (t *SMTPTransport) Name() string { return TransportSMTP }
+
+func (t *SMTPTransport) Deliver(msg *Message) error {
+	message, err := msg.Bytes()
+	if err != nil {
+		return &PermanentError{err}
+	}
+	return t.Config.Send(msg.Sender(), msg.Recipients(), message)
+}
+
+// SendmailTransport pipes the message to a sendmail compatible binary such as
+// sendmail, msmtp or the Postfix/Exim wrappers.
+type SendmailTransport struct {
+	Path string
+}
+
+func (t *SendmailTransport) Name() string { return TransportSendmail }
+
+// sysexits codes from sendmail that mean the message can never be delivered.
+var permanentSendmailCodes = map[int]bool{
+	64: true, // EX_USAGE
+	65: true, // EX_DATAERR
+	67: true, // EX_NOUSER
+	68: true, // EX_NOHOST
+	77: true, // EX_NOPERM
+}
+
+func (t *SendmailTransport) Deliver(msg *Message) error {
+	message, err := msg.Bytes()
+	if err != nil {
+		return &PermanentError{err}
+	}
+
+	// -i keeps a lone "." line from ending the message; recipients follow "--" so
+	// an address can never be read as an option.
+	args := append([]string{"-i", "-f", msg.Sender(), "--"}, msg.Recipients()...)
+	cmd := exec.Command(t.Path, args...)
+	cmd.Stdin = bytes.NewReader(message)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		detail := strings.TrimSpace(stderr.String())
+		if detail != "" {
+			err = fmt.Errorf("%w: %s", err, detail)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && permanentSendmailCodes[exitErr.ExitCode()] {
+			return &PermanentError{fmt.Errorf("%s rejected the message: %w", t.Path, err)}
+		}
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return &PermanentError{fmt.Errorf("sendmail binary %s not found: %w", t.Path, err)}
+		}
+		return fmt.Errorf("%s failed: %w", t.Path, err)
+	}
+	return nil
+}
+
+// WebhookTransport POSTs the message as JSON, with each attachment armored as text.
+type WebhookTransport struct {
+	URL string
+	// Token is sent as a bearer token when set.
+	Token   string
+	Timeout time.Duration
+}
+
+// WebhookPayload is the JSON document posted by WebhookTransport.
+type WebhookPayload struct {
+	MessageID   string              `json:"message_id"`
+	Date        time.Time           `json:"date"`
+	From        string              `json:"from"`
+	To          []string            `json:"to"`
+	Cc          []string            `json:"cc,omitempty"`
+	Bcc         []string            `json:"bcc,omitempty"`
+	ReplyTo     string              `json:"reply_to,omitempty"`
+	Subject     string              `json:"subject"`
+	Text        string              `json:"text,omitempty"`
+	HTML        string              `json:"html,omitempty"`
+	Attachments []WebhookAttachment `json:"attachments"`
+}
+
+// WebhookAttachment carries an attachment with its content armored.
+type WebhookAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Armored     string `json:"armored"`
+}
+
+func (t *WebhookTransport) Name() string { return TransportWebhook }
+
+func (t *WebhookTransport) Deliver(msg *Message) error {
+	// The payload carries the body and attachments as they are, which would undo
+	// the S/MIME protection.
+	if msg.SMIME != nil {
+		return &PermanentError{errors.New("the webhook transport cannot deliver S/MIME messages")}
+	}
+	// Bytes validates the message and fills in the Date and Message-ID.
+	if _, err := msg.Bytes(); err != nil {
+		return &PermanentError{err}
+	}
+
+	payload := WebhookPayload{
+		MessageID: msg.MessageID,
+		Date:      msg.Date,
+		From:      msg.From,
+		To:        msg.To,
+		Cc:        msg.Cc,
+		Bcc:       msg.Bcc,
+		ReplyTo:   msg.ReplyTo,
+		Subject:   msg.Subject,
+		Text:      msg.TextBody,
+		HTML:      msg.HTMLBody,
+	}
+	for _, attachment := range msg.Attachments {
+		payload.Attachments = append(payload.Attachments, WebhookAttachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Size:        len(attachment.Data),
+			Armored:     ArmorEnvelope(attachment.Data),
+		})
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &PermanentError{err}
+	}
+
+	ctx := context.Background()
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return &PermanentError{fmt.Errorf("invalid WEBHOOK_URL: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", msg.MessageID)
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	// Client errors other than timeouts and rate limits will not succeed on retry.
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &PermanentError{err}
+	}
+	return err
+}
+
+// ArmorEnvelope wraps an envelope in a PEM style text block.
+func ArmorEnvelope(data []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: envelopePEMType, Bytes: data}))
+}
+
+// EMLTransport drops each message as an .eml file into a directory, for pickup by
+// another process or inspection in CI.
+type EMLTransport struct {
+	Dir string
+}
+
+func (t *EMLTransport) Name() string { return TransportEML }
+
+func (t *EMLTransport) Deliver(msg *Message) error {
+	message, err := msg.Bytes()
+	if err != nil {
+		return &PermanentError{err}
+	}
+
+	if err := os.MkdirAll(t.Dir, 0700); err != nil {
+		return &PermanentError{fmt.Errorf("failed to create drop directory: %w", err)}
+	}
+
+	id := strings.Trim(msg.MessageID, "<>")
+	id = strings.NewReplacer("/", "_", "\\", "_", "@", "_at_").Replace(id)
+	name := fmt.Sprintf("%s-%s.eml", msg.Date.UTC().Format("20060102T150405Z"), id)
+
+	// Write to a temporary name and rename so pickers never see a partial file.
+	tmp, err := os.CreateTemp(t.Dir, ".cryptix-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create drop file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(message); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write drop file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write drop file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(t.Dir, name)); err != nil {
+		return fmt.Errorf("failed to write drop file: %w", err)
+	}
+	return nil
+}
+
+// DryRunTransport writes the exact message that would go on the wire instead of
+// sending it. Path may be a file, an existing directory that receives one .eml
+// per message, or empty or "-" for stdout.
+type DryRunTransport struct {
+	Path string
+}
+
+func (t *DryRunTransport) Name() string { return "dry-run" }
+
+func (t *DryRunTransport) Deliver(msg *Message) error {
+	message, err := msg.Bytes()
+	if err != nil {
+		return &PermanentError{err}
+	}
+
+	if t.Path == "" || t.Path == "-" {
+		_, err := os.Stdout.Write(message)
+		return err
+	}
+
+	path := t.Path
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, msg.Recipients()[0]+".eml")
+	}
+	if err := os.WriteFile(path, message, 0600); err != nil {
+		return &PermanentError{fmt.Errorf("failed to write %s: %w", path, err)}
+	}
+	utility.Info("Message for %s written to %s", strings.Join(msg.Recipients(), ", "), path)
+	return nil
+}
+
+// PreviewTransport renders the HTML part to a temporary file and opens it in the
+// browser instead of sending the message.
+type PreviewTransport struct{}
+
+func (t *PreviewTransport) Name() string { return "preview" }
+
+func (t *PreviewTransport) Deliver(msg *Message) error {
+	f, err := os.CreateTemp("", "cryptix-preview-*.html")
+	if err != nil {
+		return &PermanentError{err}
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(msg.HTMLBody); err != nil {
+		return &PermanentError{err}
+	}
+
+	utility.Info("Preview written to %s", f.Name())
+	if err := utility.OpenInBrowser("file://" + filepath.ToSlash(f.Name())); err != nil {
+		utility.Warning("%s, open the file manually", err)
+	}
+	return nil
+}
+
+// MultiTransport hands each message to every transport in turn.
+type MultiTransport []Transport
+
+func (t MultiTransport) Name() string {
+	names := make([]string, 0, len(t))
+	for _, transport := range t {
+		names = append(names, transport.Name())
+	}
+	return strings.Join(names, "+")
+}
+
+func (t MultiTransport) Deliver(msg *Message) error {
+	for _, transport := range t {
+		if err := transport.Deliver(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/drive/v2"
+)
+
+const (
+	// driveUploadURL starts resumable uploads of the Drive v2 API.
+	driveUploadURL = "https://www.googleapis.com/upload/drive/v2/files?uploadType=resumable"
+	// uploadChunkUnit is the granularity Google requires of every chunk but the last.
+	uploadChunkUnit = 256 << 10
+	// uploadChunkRetries bounds the attempts at one chunk before giving up; the
+	// session stays on disk so a later send picks up from the last stored byte.
+	uploadChunkRetries = 5
+	// uploadSessionLifetime is how long Google keeps a resumable session.
+	uploadSessionLifetime = 7 * 24 * time.Hour
+)
+
+// errUploadSessionExpired is returned when Google no longer knows a session.
+var errUploadSessionExpired = errors.New("upload session expired")
+
+// uploadSession is the state of a resumable upload persisted in UPLOAD_DIR, so an
+// upload interrupted by a network failure or timeout can be continued.
+type uploadSession struct {
+	URI     string    `json:"uri"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	Started time.Time `json:"started"`
+}
+
+// resumableUpload sends data to Drive in chunks with the resumable upload
+// protocol. Each chunk is retried on its own, after asking the session how much
+// of the data it already holds.
+type resumableUpload struct {
+	client    *http.Client
+	endpoint  string
+	chunkSize int64
+	// progress is called after every stored chunk.
+	progress func(sent, total int64)
+}
+
+func newResumableUpload(client *http.Client) *resumableUpload {
+	chunkSize := env.Vars.UploadChunkSize
+	// Every chunk but the last must be a multiple of 256 KiB.
+	if chunkSize < uploadChunkUnit {
+		chunkSize = uploadChunkUnit
+	}
+	chunkSize -= chunkSize % uploadChunkUnit
+
+	return &resumableUpload{
+		client:    client,
+		endpoint:  driveUploadURL,
+		chunkSize: chunkSize,
+		progress:  reportUploadProgress,
+	}
+}
+
+// Upload stores data as a Drive file named name, continuing a session persisted by
+// an earlier interrupted attempt at the same data when there is one.
+func (u *resumableUpload) Upload(ctx context.Context, name string, data []byte) (*drive.File, error) {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	session, err := loadUploadSession(digest)
+	if err != nil {
+		logger.Logger.WithFields(logrus.Fields{"sha256": digest, "err": err}).Warn("Ignoring unreadable upload session")
+	}
+
+	var offset int64
+	if session != nil {
+		offset, err = u.status(ctx, session)
+		switch {
+		case errors.Is(err, errUploadSessionExpired):
+			utility.Warning("The interrupted upload of %s expired, starting over", session.Name)
+			session = nil
+		case err != nil:
+			return nil, err
+		default:
+			utility.Info("Resuming upload of %s at byte %d of %d", session.Name, offset, session.Size)
+			logger.Logger.WithFields(logrus.Fields{
+				"file":   session.Name,
+				"offset": offset,
+				"size":   session.Size,
+			}).Info("Resuming upload session")
+		}
+	}
+
+	if session == nil {
+		uri, err := u.start(ctx, name, int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		session = &uploadSession{URI: uri, Name: name, Size: int64(len(data)), SHA256: digest, Started: time.Now()}
+		offset = 0
+		if err := saveUploadSession(session, data); err != nil {
+			// The upload still works, it just cannot be resumed by a later send.
+			logger.Logger.WithFields(logrus.Fields{"file": name, "err": err}).Warn("Unable to persist upload session")
+		}
+	}
+
+	file, err := u.send(ctx, session, data, offset)
+	if err != nil {
+		if path := uploadSessionData(session); fileExists(path) {
+			utility.Info("The upload can be resumed: rerun send with --source %s", path)
+		}
+		return nil, err
+	}
+	removeUploadSession(digest)
+	return file, nil
+}
+
+// start opens a resumable session for a file of size bytes and returns its URI.
+func (u *resumableUpload) start(ctx context.Context, name string, size int64) (string, error) {
+	metadata, err := json.Marshal(&drive.File{Title: name, Description: name})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.endpoint, bytes.NewReader(metadata))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", "application/octet-stream")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to start upload: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to start upload: %s", responseError(resp))
+	}
+	uri := resp.Header.Get("Location")
+	if uri == "" {
+		return "", errors.New("unable to start upload: no session URI in the response")
+	}
+	return uri, nil
+}
+
+// status asks the session how many bytes it has stored.
+func (u *resumableUpload) status(ctx context.Context, session *uploadSession) (int64, error) {
+	if time.Since(session.Started) > uploadSessionLifetime {
+		return 0, errUploadSessionExpired
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session.URI, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", session.Size))
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("unable to query upload session: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPermanentRedirect:
+		return storedBytes(resp), nil
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		// Everything arrived; the next PUT of an empty range returns the file again.
+		return session.Size, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return 0, errUploadSessionExpired
+	default:
+		return 0, fmt.Errorf("unable to query upload session: %s", responseError(resp))
+	}
+}
+
+// send uploads data from offset on, one chunk at a time, and returns the file once
+// Drive has stored all of it.
+func (u *resumableUpload) send(ctx context.Context, session *uploadSession, data []byte, offset int64) (*drive.File, error) {
+	size := int64(len(data))
+	for {
+		end := offset + u.chunkSize
+		if end > size {
+			end = size
+		}
+
+		var file *drive.File
+		var next int64
+		var err error
+		delay := time.Second
+		for attempt := 1; ; attempt++ {
+			file, next, err = u.putChunk(ctx, session.URI, data[offset:end], offset, size)
+			if err == nil || errors.Is(err, errUploadSessionExpired) || ctx.Err() != nil || attempt == uploadChunkRetries {
+				break
+			}
+			logger.Logger.WithFields(logrus.Fields{
+				"file":    session.Name,
+				"offset":  offset,
+				"attempt": attempt,
+				"err":     err,
+			}).Warn("Upload chunk failed")
+			utility.Warning("Upload interrupted: %s. Retrying in %v...", err, delay)
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			delay *= 2
+			// The failed request may have stored part of the chunk.
+			if stored, statusErr := u.status(ctx, session); statusErr == nil {
+				if stored == size {
+					offset, end = size, size
+				} else if stored != offset {
+					offset = stored
+					end = offset + u.chunkSize
+					if end > size {
+						end = size
+					}
+				}
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("upload did not finish within --upload-timeout: %w", err)
+			}
+			return nil, err
+		}
+
+		if u.progress != nil {
+			u.progress(next, size)
+		}
+		if file != nil {
+			return file, nil
+		}
+		offset = next
+	}
+}
+
+// putChunk sends chunk as the bytes starting at offset. It returns the file when
+// the upload is complete, and otherwise the offset to continue from.
+func (u *resumableUpload) putChunk(ctx context.Context, uri string, chunk []byte, offset, size int64) (*drive.File, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uri, bytes.NewReader(chunk))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.ContentLength = int64(len(chunk))
+	if len(chunk) == 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(chunk))-1, size))
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		file := &drive.File{}
+		if err := json.NewDecoder(resp.Body).Decode(file); err != nil {
+			return nil, 0, fmt.Errorf("invalid upload response: %w", err)
+		}
+		return file, size, nil
+	case http.StatusPermanentRedirect:
+		return nil, storedBytes(resp), nil
+	case http.StatusNotFound, http.StatusGone:
+		return nil, 0, errUploadSessionExpired
+	default:
+		return nil, 0, errors.New(responseError(resp))
+	}
+}
+
+// storedBytes reads the Range header of a 308 reply, "bytes=0-<last byte>". No
+// header means nothing was stored yet.
+func storedBytes(resp *http.Response) int64 {
+	r := resp.Header.Get("Range")
+	i := strings.LastIndex(r, "-")
+	if i < 0 {
+		return 0
+	}
+	last, err := strconv.ParseInt(r[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return last + 1
+}
+
+func responseError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if len(bytes.TrimSpace(body)) == 0 {
+		return resp.Status
+	}
+	return fmt.Sprintf("%s: %s", resp.Status, bytes.TrimSpace(body))
+}
+
+func reportUploadProgress(sent, total int64) {
+	percent := int64(100)
+	if total > 0 {
+		percent = sent * 100 / total
+	}
+	utility.Info("Uploaded %d of %d bytes (%d%%)", sent, total, percent)
+}
+
+// uploadSessionDir holds one directory per interrupted upload, named after the
+// SHA-256 of the data, with the session and a copy of the data to resend.
+func uploadSessionDir(digest string) string {
+	return filepath.Join(env.Vars.UploadDir, digest)
+}
+
+func uploadSessionData(session *uploadSession) string {
+	return filepath.Join(uploadSessionDir(session.SHA256), filepath.Base(session.Name))
+}
+
+// loadUploadSession returns the persisted session for the data with this digest,
+// or nil when there is none.
+func loadUploadSession(digest string) (*uploadSession, error) {
+	data, err := os.ReadFile(filepath.Join(uploadSessionDir(digest), "session.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	session := &uploadSession{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// saveUploadSession persists the session together with the data it uploads. Only
+// envelopes are uploaded, so the copy is ciphertext.
+func saveUploadSession(session *uploadSession, data []byte) error {
+	dir := uploadSessionDir(session.SHA256)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(uploadSessionData(session), data, 0600); err != nil {
+		return err
+	}
+	encoded, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "session.json"), encoded, 0600)
+}
+
+func removeUploadSession(digest string) {
+	if err := os.RemoveAll(uploadSessionDir(digest)); err != nil {
+		logger.Logger.WithFields(logrus.Fields{"sha256": digest, "err": err}).Warn("Unable to remove finished upload session")
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,125 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+)
+
+// WebDAVStore uploads to a WebDAV collection, such as a Nextcloud folder at
+// https://cloud.example.com/remote.php/dav/files/<user>/cryptix. Links point at
+// the file itself, so recipients need access to the collection to download it.
+type WebDAVStore struct {
+	URL      string
+	User     string
+	Password string
+	Client   *http.Client
+}
+
+// WebDAVStoreFromEnv builds the WebDAV store from the WEBDAV_* variables.
+func WebDAVStoreFromEnv() (*WebDAVStore, error) {
+	if env.Vars.WebDAVURL == "" {
+		return nil, errors.New("the webdav store requires WEBDAV_URL")
+	}
+	if _, err := url.Parse(env.Vars.WebDAVURL); err != nil {
+		return nil, fmt.Errorf("invalid WEBDAV_URL: %w", err)
+	}
+	return &WebDAVStore{
+		URL:      strings.TrimSuffix(env.Vars.WebDAVURL, "/"),
+		User:     env.Vars.WebDAVUser,
+		Password: env.Vars.WebDAVPassword,
+		Client:   http.DefaultClient,
+	}, nil
+}
+
+func (s *WebDAVStore) Name() string { return StoreWebDAV }
+
+func (s *WebDAVStore) Upload(ctx context.Context, name string, data []byte, recipients []string, ttl time.Duration) (*Share, error) {
+	object, err := objectName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the collection on first use; 405 means it exists already.
+	resp, err := s.do(ctx, "MKCOL", s.URL+"/", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach WebDAV server: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+		return nil, fmt.Errorf("failed to create WebDAV collection %s: %s", s.URL, resp.Status)
+	}
+
+	link := s.URL + "/" + url.PathEscape(object)
+	resp, err = s.do(ctx, http.MethodPut, link, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to WebDAV: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to upload to WebDAV: %s", responseError(resp))
+	}
+
+	return &Share{
+		ID:         object,
+		Backend:    StoreWebDAV,
+		Name:       name,
+		Link:       link,
+		Recipients: recipients,
+		Created:    time.Now(),
+	}, nil
+}
+
+// Revoke deletes the file; access to the collection is managed by the server.
+func (s *WebDAVStore) Revoke(ctx context.Context, share *Share, action string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.URL+"/"+url.PathEscape(share.ID), nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete from WebDAV: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("failed to delete from WebDAV: %s", responseError(resp))
+	}
+}
+
+func (s *WebDAVStore) Fetch(ctx context.Context, link string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	return doDownload(s.Client, req)
+}
+
+func (s *WebDAVStore) do(ctx context.Context, method, link string, body []byte) (*http.Response, error) {
+	req, err := s.request(ctx, method, link, body)
+	if err != nil {
+		return nil, err
+	}
+	return s.Client.Do(req)
+}
+
+func (s *WebDAVStore) request(ctx context.Context, method, link string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, link, reader)
+	if err != nil {
+		return nil, err
+	}
+	if s.User != "" {
+		req.SetBasicAuth(s.User, s.Password)
+	}
+	return req, nil
+}
@@ -0,0 +1,173 @@
+package mail
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWebDAV stands in for a WebDAV server with basic authentication. PUT requires
+// the parent collection to exist, as RFC 4918 does.
+type fakeWebDAV struct {
+	*httptest.Server
+	t              *testing.T
+	user, password string
+
+	mu          sync.Mutex
+	collections map[string]bool
+	files       map[string][]byte
+	requests    []string
+}
+
+func newFakeWebDAV(t *testing.T) *fakeWebDAV {
+	d := &fakeWebDAV{
+		t:           t,
+		user:        "alice",
+		password:    "s3cret",
+		collections: map[string]bool{"/dav": true},
+		files:       map[string][]byte{},
+	}
+	d.Server = httptest.NewServer(d)
+	t.Cleanup(d.Close)
+	return d
+}
+
+// store returns a WebDAVStore for the collection /dav/cryptix of the stand-in.
+func (d *fakeWebDAV) store() *WebDAVStore {
+	return &WebDAVStore{URL: d.URL + "/dav/cryptix", User: d.user, Password: d.password, Client: d.Client()}
+}
+
+func (d *fakeWebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	user, password, ok := r.BasicAuth()
+	if !ok || user != d.user || password != d.password {
+		w.Header().Set("WWW-Authenticate", `Basic realm="dav"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimSuffix(r.URL.Path, "/")
+	d.requests = append(d.requests, r.Method+" "+name)
+
+	switch r.Method {
+	case "MKCOL":
+		if d.collections[name] {
+			http.Error(w, "collection exists", http.StatusMethodNotAllowed)
+			return
+		}
+		if !d.collections[path.Dir(name)] {
+			http.Error(w, "parent collection missing", http.StatusConflict)
+			return
+		}
+		d.collections[name] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		if !d.collections[path.Dir(name)] {
+			http.Error(w, "parent collection missing", http.StatusConflict)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		d.files[name] = body
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		data, ok := d.files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		if _, ok := d.files[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(d.files, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestWebDAVUploadFetchRevoke(t *testing.T) {
+	d := newFakeWebDAV(t)
+	store := d.store()
+	data := testEnvelope(t, 4096)
+
+	share, err := store.Upload(context.Background(), "Q3 report.json", data, []string{"bob@example.com"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Upload() = %v", err)
+	}
+	if share.Backend != StoreWebDAV || !strings.HasPrefix(share.Link, store.URL+"/") || !strings.HasSuffix(share.Link, "-Q3%20report.json") {
+		t.Fatalf("unexpected share %+v", share)
+	}
+	if got := d.files["/dav/cryptix/"+share.ID]; string(got) != string(data) {
+		t.Fatalf("server holds %d bytes for %s, want the %d uploaded", len(got), share.ID, len(data))
+	}
+
+	// The collection exists now; the 405 of a second MKCOL is not an error.
+	second, err := store.Upload(context.Background(), "Q3 report.json", data, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("second Upload() = %v", err)
+	}
+	if second.ID == share.ID {
+		t.Fatal("second upload of the same name overwrote the first")
+	}
+	wantRequests := []string{"MKCOL /dav/cryptix", "PUT /dav/cryptix/" + share.ID, "MKCOL /dav/cryptix", "PUT /dav/cryptix/" + second.ID}
+	if strings.Join(d.requests, "|") != strings.Join(wantRequests, "|") {
+		t.Fatalf("requests %q, want %q", d.requests, wantRequests)
+	}
+
+	body, err := store.Fetch(context.Background(), share.Link)
+	if err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	}
+	fetched, _ := io.ReadAll(body)
+	body.Close()
+	if string(fetched) != string(data) {
+		t.Fatal("Fetch() returned different bytes than were uploaded")
+	}
+	// Without the credentials the link is of no use.
+	if _, err := (&HTTPFetcher{Client: http.DefaultClient}).Fetch(context.Background(), share.Link); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Fetch() without credentials = %v, want 401", err)
+	}
+
+	if err := store.Revoke(context.Background(), share, ShareActionDelete); err != nil {
+		t.Fatalf("Revoke() = %v", err)
+	}
+	if _, ok := d.files["/dav/cryptix/"+share.ID]; ok {
+		t.Fatal("Revoke() left the file")
+	}
+	if err := store.Revoke(context.Background(), share, ShareActionDelete); err != nil {
+		t.Fatalf("Revoke() of a deleted file = %v, want nil", err)
+	}
+}
+
+func TestWebDAVUploadErrors(t *testing.T) {
+	d := newFakeWebDAV(t)
+
+	badPassword := d.store()
+	badPassword.Password = "wrong"
+	if _, err := badPassword.Upload(context.Background(), "report.json", []byte("{}"), nil, time.Hour); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Upload() with a wrong password = %v, want the 401 reported", err)
+	}
+
+	// MKCOL cannot create intermediate collections, so a missing parent fails.
+	nested := d.store()
+	nested.URL = d.URL + "/dav/missing/cryptix"
+	if _, err := nested.Upload(context.Background(), "report.json", []byte("{}"), nil, time.Hour); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Fatalf("Upload() below a missing collection = %v, want the 409 reported", err)
+	}
+	if len(d.files) != 0 {
+		t.Fatalf("%d files stored by failed uploads", len(d.files))
+	}
+}
@@ -0,0 +1,181 @@
+package outbox
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd/mail"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	via string
+	all bool
+)
+
+// OutboxCmd groups the commands managing messages that could not be delivered.
+var OutboxCmd = &cobra.Command{
+	Use:   "outbox",
+	Short: "Inspect and redeliver messages that 'send' could not deliver.",
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List messages waiting in the outbox.",
+	Run:   runListCmd,
+}
+
+var flushCmd = &cobra.Command{
+	Use:     "flush",
+	Short:   "Redeliver the messages whose next attempt is due.",
+	Example: "cryptix outbox flush\ncryptix outbox flush --via sendmail",
+	Run:     runFlushCmd,
+}
+
+var retryCmd = &cobra.Command{
+	Use:     "retry [id...]",
+	Short:   "Redeliver messages now, ignoring the backoff schedule and permanent failures.",
+	Example: "cryptix outbox retry 20250101T120000-3fa2c1d9e0b7\ncryptix outbox retry --all",
+	Run:     runRetryCmd,
+}
+
+func runListCmd(cmd *cobra.Command, args []string) {
+	entries := listEntries()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTO\tSUBJECT\tATTEMPTS\tNEXT ATTEMPT\tLAST ERROR")
+	for _, entry := range entries {
+		next := entry.NextAttempt.Format(time.DateTime)
+		switch {
+		case entry.Failed:
+			next = utility.Red("failed")
+		case entry.Due(time.Now()):
+			next = utility.Green("due")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", entry.ID, strings.Join(entry.Message.Recipients(), ","),
+			entry.Message.Subject, len(entry.Attempts), next, entry.LastError())
+	}
+	w.Flush()
+}
+
+func runFlushCmd(cmd *cobra.Command, args []string) {
+	via, _ = cmd.Flags().GetString("via")
+
+	var due []*mail.OutboxEntry
+	for _, entry := range listEntries() {
+		if entry.Due(time.Now()) {
+			due = append(due, entry)
+		}
+	}
+	if len(due) == 0 {
+		utility.Info("No messages are due for redelivery")
+		return
+	}
+
+	if !redeliver(due) {
+		os.Exit(1)
+	}
+}
+
+func runRetryCmd(cmd *cobra.Command, args []string) {
+	via, _ = cmd.Flags().GetString("via")
+	all, _ = cmd.Flags().GetBool("all")
+
+	if all == (len(args) > 0) {
+		utility.Error("Give the IDs of the messages to retry, or --all")
+		os.Exit(1)
+	}
+
+	outbox := mail.OpenOutbox()
+	var entries []*mail.OutboxEntry
+	if all {
+		entries = listEntries()
+	} else {
+		for _, id := range args {
+			entry, err := outbox.Get(id)
+			if err != nil {
+				abort("Outbox loading", err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	if !redeliver(entries) {
+		os.Exit(1)
+	}
+}
+
+// redeliver attempts each entry once and reports whether all were delivered.
+func redeliver(entries []*mail.OutboxEntry) bool {
+	outbox := mail.OpenOutbox()
+	transports := map[string]mail.Transport{}
+	signer, err := mail.DefaultDKIMSigner()
+	if err != nil {
+		abort("DKIM configuration", err)
+	}
+
+	delivered := 0
+	for _, entry := range entries {
+		name := via
+		if name == "" {
+			name = entry.Transport
+		}
+		transport, ok := transports[name]
+		if !ok {
+			var err error
+			transport, err = mail.NewTransport(name)
+			if err != nil {
+				abort("Transport configuration", err)
+			}
+			transports[name] = transport
+		}
+
+		entry.Message.DKIM = signer
+		if err := outbox.Deliver(transport, entry); err != nil {
+			utility.Error("%s: %s (attempt %d, next %s)", entry.ID, err, len(entry.Attempts), nextAttempt(entry))
+			continue
+		}
+		delivered++
+		utility.Success("%s delivered via %s", entry.ID, transport.Name())
+	}
+
+	utility.Info("%d of %d messages delivered", delivered, len(entries))
+	return delivered == len(entries)
+}
+
+func nextAttempt(entry *mail.OutboxEntry) string {
+	if entry.Failed {
+		return "only on explicit retry"
+	}
+	return entry.NextAttempt.Format(time.DateTime)
+}
+
+func listEntries() []*mail.OutboxEntry {
+	entries, err := mail.OpenOutbox().List()
+	if err != nil {
+		abort("Outbox loading", err)
+	}
+	return entries
+}
+
+func abort(operation string, err error) {
+	utility.Error("%s", err)
+	utility.Info("Aborting operation: %s", utility.Red(operation))
+	logger.Logger.WithFields(logrus.Fields{"err": err}).Error(operation)
+	os.Exit(1)
+}
+
+func init() {
+	flushCmd.Flags().StringVarP(&via, "via", "", "", "Deliver with this transport instead of the one the message was sent with. [Optional]")
+
+	retryCmd.Flags().StringVarP(&via, "via", "", "", "Deliver with this transport instead of the one the message was sent with. [Optional]")
+	retryCmd.Flags().BoolVarP(&all, "all", "a", false, "Retry every message in the outbox. [Optional]")
+
+	OutboxCmd.AddCommand(listCmd, flushCmd, retryCmd)
+}
@@ -0,0 +1,109 @@
+package subcmd
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/ca"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/contacts"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+)
+
+// ResolveRecipientKey returns the public key to encrypt for email. The contacts
+// address book is consulted first and the team CA second. Once a team CA is set
+// up, a contact only picks among the keys it certified for the address: any other
+// key is refused. A warning is printed when a contact's key changed and has not
+// been verified since.
+func ResolveRecipientKey(email string) (*rsa.PublicKey, error) {
+	store, err := contacts.Open(env.Vars.CONFIG_DIR)
+	if err != nil {
+		utility.Error("%s", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to open contacts")
+		return nil, err
+	}
+
+	contact, err := store.Lookup(email)
+	if errors.Is(err, contacts.ErrNotFound) {
+		return ResolveCertifiedKey(email)
+	}
+
+	pubKey, err := contact.Key()
+	if err != nil {
+		utility.Error("%s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"email": email,
+			"err":   err,
+		}).Error("Invalid contact key")
+		return nil, err
+	}
+
+	if contact.KeyChanged() {
+		previous := contact.PreviousFingerprints[len(contact.PreviousFingerprints)-1]
+		utility.Warning("The key of %s changed on %s (was %s, now %s). Confirm the new fingerprint with them, then run 'cryptix contacts add --email %s --pubkey <key> --trust verified'",
+			contact.Name, contact.KeyChangedAt.Format("2006-01-02"), shortFingerprint(previous), shortFingerprint(contact.Fingerprint), email)
+		logger.Logger.WithFields(logrus.Fields{
+			"email":    email,
+			"previous": previous,
+			"current":  contact.Fingerprint,
+		}).Warn("Contact key changed")
+	}
+	if err := requireCertifiedKey(email, pubKey); err != nil {
+		utility.Error("%s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"email":       email,
+			"fingerprint": contact.Fingerprint,
+			"err":         err,
+		}).Error("Contact key is not certified")
+		return nil, err
+	}
+
+	utility.Success("Using key of contact %s (%s, %s)", contact.Name, shortFingerprint(contact.Fingerprint), contact.Trust)
+	logger.Logger.WithFields(logrus.Fields{
+		"email":       email,
+		"fingerprint": contact.Fingerprint,
+		"trust":       contact.Trust,
+	}).Info("Resolved contact key")
+	return pubKey, nil
+}
+
+// requireCertifiedKey checks that the team CA certified pubKey for email. Without
+// a CA every key is accepted.
+func requireCertifiedKey(email string, pubKey *rsa.PublicKey) error {
+	authority, err := ca.Open(env.Vars.CA_DIR)
+	if errors.Is(err, ca.ErrNotInitialized) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := crypt.KeyFingerprint(pubKey)
+	if err != nil {
+		return err
+	}
+	certs, err := authority.LookupAll(email)
+	if err != nil {
+		return fmt.Errorf("the contact key of %s (%s) is not certified by the team CA: %w", email, shortFingerprint(fingerprint), err)
+	}
+	for _, cert := range certs {
+		if certified, err := crypt.KeyFingerprint(cert.PublicKey); err == nil && certified == fingerprint {
+			return nil
+		}
+	}
+
+	certified, _ := crypt.KeyFingerprint(certs[0].PublicKey)
+	return fmt.Errorf("the team CA certified a different key for %s (%s) than the contact entry (%s); update the contact or have the key certified with 'cryptix ca issue'",
+		email, shortFingerprint(certified), shortFingerprint(fingerprint))
+}
+
+func shortFingerprint(fingerprint string) string {
+	if len(fingerprint) > 16 {
+		return fingerprint[:16]
+	}
+	return fingerprint
+}
@@ -0,0 +1,145 @@
+package shares
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/cli/subcmd/mail"
+	"github.com/Kshitiz-Mhto/cryptix/pkg/env"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	unshare bool
+	action  string
+	all     bool
+)
+
+// SharesCmd groups the commands managing the links 'send' created for uploaded envelopes.
+var SharesCmd = &cobra.Command{
+	Use:   "shares",
+	Short: "List, revoke and expire the uploads 'send' shared with recipients.",
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List uploaded envelopes and who they are shared with.",
+	Run:   runListCmd,
+}
+
+var revokeCmd = &cobra.Command{
+	Use:     "revoke <id...>",
+	Short:   "Delete uploaded envelopes, or with --unshare remove the recipients' access.",
+	Example: "cryptix shares revoke 1AbCdEfGhIjKlMnOpQrStUvWxYz\ncryptix shares revoke 1AbCdEfGhIjKlMnOpQrStUvWxYz --unshare",
+	Args:    cobra.MinimumNArgs(1),
+	Run:     runRevokeCmd,
+}
+
+var expireCmd = &cobra.Command{
+	Use:     "expire",
+	Short:   "Revoke the shares whose TTL has passed; 'send' also does this before each upload.",
+	Example: "cryptix shares expire\ncryptix shares expire --action unshare",
+	Run:     runExpireCmd,
+}
+
+func runListCmd(cmd *cobra.Command, args []string) {
+	all, _ = cmd.Flags().GetBool("all")
+
+	shares, err := mail.OpenShareLedger().List()
+	if err != nil {
+		abort("Shares loading", err)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tRECIPIENTS\tCREATED\tEXPIRES\tSTATUS")
+	for _, share := range shares {
+		status := share.Status(now)
+		if status == "revoked" && !all {
+			continue
+		}
+		expires := "never"
+		if share.Expires != nil {
+			expires = share.Expires.Format(time.DateTime)
+		}
+		switch status {
+		case "revoked":
+			status = utility.Red(share.Action + "d")
+		case "expired":
+			status = utility.Yellow(status)
+		default:
+			status = utility.Green(status)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", share.ID, share.Name, strings.Join(share.Recipients, ","),
+			share.Created.Format(time.DateTime), expires, status)
+	}
+	w.Flush()
+}
+
+func runRevokeCmd(cmd *cobra.Command, args []string) {
+	unshare, _ = cmd.Flags().GetBool("unshare")
+
+	revokeAction := mail.ShareActionDelete
+	if unshare {
+		revokeAction = mail.ShareActionUnshare
+	}
+
+	ledger := mail.OpenShareLedger()
+	revoker := mail.NewShareRevoker()
+	failed := false
+	for _, id := range args {
+		share, err := ledger.Get(id)
+		if err == nil {
+			err = revoker.Revoke(context.Background(), share, revokeAction)
+		}
+		if err != nil {
+			utility.Error("%s: %s", id, err)
+			logger.Logger.WithFields(logrus.Fields{"id": id, "err": err}).Error("Share revocation")
+			failed = true
+			continue
+		}
+		utility.Success("%s %sd, %s can no longer download it", share.Name, revokeAction, strings.Join(share.Recipients, ", "))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func runExpireCmd(cmd *cobra.Command, args []string) {
+	action, _ = cmd.Flags().GetString("action")
+
+	expired, err := mail.NewShareRevoker().Expire(context.Background(), action)
+	for _, share := range expired {
+		utility.Success("%s (%s) expired and was %sd", share.ID, share.Name, share.Action)
+	}
+	if err != nil {
+		abort("Share expiry", err)
+	}
+	if len(expired) == 0 {
+		utility.Info("No shares have expired")
+	}
+}
+
+func abort(operation string, err error) {
+	utility.Error("%s", err)
+	utility.Info("Aborting operation: %s", utility.Red(operation))
+	logger.Logger.WithFields(logrus.Fields{"err": err}).Error(operation)
+	os.Exit(1)
+}
+
+func init() {
+	listCmd.Flags().BoolVarP(&all, "all", "a", false, "Include revoked shares. [Optional]")
+
+	revokeCmd.Flags().BoolVarP(&unshare, "unshare", "", false, "Keep the file but remove the recipients' access. [Optional]")
+
+	expireCmd.Flags().StringVarP(&action, "action", "", env.Vars.ShareExpiryAction, "What to do with expired shares: delete or unshare. [Default: SHARE_EXPIRY_ACTION]")
+
+	SharesCmd.AddCommand(listCmd, revokeCmd, expireCmd)
+}
@@ -0,0 +1,183 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	oidExtensionKeyUsage         = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtensionExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidExtKeyUsageEmailProtect   = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}
+	oidEmailAddress              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+)
+
+// CertificateOptions describes the identity bound to a key by a certificate or CSR.
+type CertificateOptions struct {
+	Subject        pkix.Name
+	EmailAddresses []string
+	Validity       time.Duration
+}
+
+// ParseSubject parses a distinguished name such as "CN=alice,O=Corp,C=NP".
+// Both "," and "/" separated forms are accepted.
+func ParseSubject(subject string) (pkix.Name, error) {
+	var name pkix.Name
+
+	subject = strings.TrimPrefix(strings.TrimSpace(subject), "/")
+	if subject == "" {
+		return name, fmt.Errorf("empty subject")
+	}
+
+	separator := ","
+	if !strings.Contains(subject, ",") && strings.Contains(subject, "/") {
+		separator = "/"
+	}
+
+	for _, part := range strings.Split(subject, separator) {
+		attr, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || value == "" {
+			return name, fmt.Errorf("invalid subject attribute %q", part)
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToUpper(strings.TrimSpace(attr)) {
+		case "CN":
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "STREET":
+			name.StreetAddress = append(name.StreetAddress, value)
+		case "POSTALCODE":
+			name.PostalCode = append(name.PostalCode, value)
+		case "SERIALNUMBER":
+			name.SerialNumber = value
+		case "EMAIL", "EMAILADDRESS", "E":
+			name.ExtraNames = append(name.ExtraNames, pkix.AttributeTypeAndValue{Type: oidEmailAddress, Value: value})
+		default:
+			return name, fmt.Errorf("unsupported subject attribute %q", attr)
+		}
+	}
+
+	return name, nil
+}
+
+// KeyUsageFor returns the key usage suited to a public key: key and data encipherment for
+// RSA keys, which cryptix uses to wrap AES keys, and digital signature for the rest.
+func KeyUsageFor(pub crypto.PublicKey) x509.KeyUsage {
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		return x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+// NewSelfSignedCertificate creates a self-signed end-entity certificate for the key,
+// with the key usage from KeyUsageFor and the email protection extended key usage.
+func NewSelfSignedCertificate(signer crypto.Signer, opts CertificateOptions) (*x509.Certificate, error) {
+	serial, err := NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               opts.Subject,
+		EmailAddresses:        opts.EmailAddresses,
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(opts.Validity),
+		KeyUsage:              KeyUsageFor(signer.Public()),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		utility.Error("failed to create certificate: %v", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to create certificate")
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// NewCertificateRequest creates a PEM encoded PKCS#10 signing request for the key.
+// The key usage extensions are requested so the issuing CA can copy them.
+func NewCertificateRequest(signer crypto.Signer, opts CertificateOptions) ([]byte, error) {
+	keyUsage, err := marshalKeyUsage(KeyUsageFor(signer.Public()))
+	if err != nil {
+		return nil, err
+	}
+	extKeyUsage, err := asn1.Marshal([]asn1.ObjectIdentifier{oidExtKeyUsageEmailProtect})
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.CertificateRequest{
+		Subject:        opts.Subject,
+		EmailAddresses: opts.EmailAddresses,
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidExtensionKeyUsage, Critical: true, Value: keyUsage},
+			{Id: oidExtensionExtendedKeyUsage, Value: extKeyUsage},
+		},
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, signer)
+	if err != nil {
+		utility.Error("failed to create certificate request: %v", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to create certificate request")
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// NewSerialNumber returns a random 128-bit certificate serial number.
+func NewSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		utility.Error("failed to generate certificate serial: %v", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to generate certificate serial")
+		return nil, err
+	}
+	return serial, nil
+}
+
+// marshalKeyUsage encodes a key usage as the DER BIT STRING of the X.509 extension.
+func marshalKeyUsage(ku x509.KeyUsage) ([]byte, error) {
+	var a [2]byte
+	a[0] = bits.Reverse8(byte(ku))
+	a[1] = bits.Reverse8(byte(ku >> 8))
+
+	l := 1
+	if a[1] != 0 {
+		l = 2
+	}
+	bitString := a[:l]
+
+	// Trailing zero bits are not encoded.
+	bitLength := len(bitString)*8 - bits.TrailingZeros8(bitString[len(bitString)-1])
+	return asn1.Marshal(asn1.BitString{Bytes: bitString, BitLength: bitLength})
+}
@@ -0,0 +1,619 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"strings"
+)
+
+// Content encryption algorithms for EncryptCMS. AES-CBC produces EnvelopedData
+// (RFC 5652), which every S/MIME client reads; AES-GCM produces AuthEnvelopedData
+// (RFC 5083), which only recent clients support.
+const (
+	CMSCipherAES256CBC = "aes-256-cbc"
+	CMSCipherAES256GCM = "aes-256-gcm"
+)
+
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAuthEnvelopedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 23}
+	oidRSAEncryption        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAESOAEP            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidSHA1                 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidAES128CBC            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidAES128GCM            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 6}
+	oidAES192GCM            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 26}
+	oidAES256GCM            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+	errNoMatchingRecipient  = errors.New("the message is not encrypted for this private key")
+	errUnsupportedCMSFormat = errors.New("not a CMS/PKCS#7 message")
+)
+
+// aesKeySizes maps AES content encryption OIDs to their key length.
+var aesKeySizes = map[string]int{
+	oidAES128CBC.String(): 16, oidAES192CBC.String(): 24, oidAES256CBC.String(): 32,
+	oidAES128GCM.String(): 16, oidAES192GCM.String(): 24, oidAES256GCM.String(): 32,
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is the [0] EXPLICIT wrapper; its Bytes hold the encoded content.
+	Content asn1.RawValue
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type authEnvelopedData struct {
+	Version                  int
+	RecipientInfos           []keyTransRecipientInfo `asn1:"set"`
+	AuthEncryptedContentInfo encryptedContentInfo
+	// AuthAttrs is the [1] IMPLICIT SET of attributes some clients authenticate
+	// along with the content, such as the content type.
+	AuthAttrs   asn1.RawValue `asn1:"optional,tag:1"`
+	MAC         []byte
+	UnauthAttrs asn1.RawValue `asn1:"optional,tag:2"`
+}
+
+type keyTransRecipientInfo struct {
+	Version int
+	// Rid is an IssuerAndSerialNumber, or a [0] SubjectKeyIdentifier in version 2.
+	Rid                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// rsaesOAEPParams are the RSAES-OAEP parameters of RFC 4055. Absent fields mean SHA-1.
+type rsaesOAEPParams struct {
+	HashFunc    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+}
+
+type gcmParams struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+// EncryptCMS encrypts content, a MIME entity, for every certificate's RSA key with
+// RSA-OAEP (SHA-256) and returns the DER encoded ContentInfo.
+func EncryptCMS(content []byte, recipients []*x509.Certificate, contentCipher string) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipient certificates to encrypt for")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	infos := make([]keyTransRecipientInfo, 0, len(recipients))
+	for _, cert := range recipients {
+		info, err := newKeyTransRecipientInfo(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var contentType asn1.ObjectIdentifier
+	var inner interface{}
+	switch contentCipher {
+	case CMSCipherAES256CBC, "":
+		iv := make([]byte, aes.BlockSize)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, err
+		}
+		padding := aes.BlockSize - len(content)%aes.BlockSize
+		padded := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		ciphertext := make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+		ivParam, err := asn1.Marshal(iv)
+		if err != nil {
+			return nil, err
+		}
+		contentType = oidEnvelopedData
+		inner = envelopedData{
+			Version:        0,
+			RecipientInfos: infos,
+			EncryptedContentInfo: encryptedContentInfo{
+				ContentType:                oidData,
+				ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+				EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext},
+			},
+		}
+	case CMSCipherAES256GCM:
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		sealed := aead.Seal(nil, nonce, content, nil)
+		ciphertext, tag := sealed[:len(content)], sealed[len(content):]
+
+		params, err := asn1.Marshal(gcmParams{Nonce: nonce, ICVLen: aead.Overhead()})
+		if err != nil {
+			return nil, err
+		}
+		contentType = oidAuthEnvelopedData
+		inner = authEnvelopedData{
+			Version:        0,
+			RecipientInfos: infos,
+			AuthEncryptedContentInfo: encryptedContentInfo{
+				ContentType:                oidData,
+				ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256GCM, Parameters: asn1.RawValue{FullBytes: params}},
+				EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext},
+			},
+			MAC: tag,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported S/MIME cipher %q, use %s or %s", contentCipher, CMSCipherAES256CBC, CMSCipherAES256GCM)
+	}
+
+	innerDER, err := asn1.Marshal(inner)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: innerDER},
+	})
+}
+
+func newKeyTransRecipientInfo(cert *x509.Certificate, key []byte) (keyTransRecipientInfo, error) {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return keyTransRecipientInfo{}, fmt.Errorf("certificate of %s does not hold an RSA key", cert.Subject.CommonName)
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return keyTransRecipientInfo{}, err
+	}
+
+	rid, err := asn1.Marshal(issuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber})
+	if err != nil {
+		return keyTransRecipientInfo{}, err
+	}
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	mgfParams, err := asn1.Marshal(sha256Alg)
+	if err != nil {
+		return keyTransRecipientInfo{}, err
+	}
+	oaepParams, err := asn1.Marshal(rsaesOAEPParams{
+		HashFunc:    sha256Alg,
+		MaskGenFunc: pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+	})
+	if err != nil {
+		return keyTransRecipientInfo{}, err
+	}
+
+	return keyTransRecipientInfo{
+		Version:                0,
+		Rid:                    asn1.RawValue{FullBytes: rid},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: asn1.RawValue{FullBytes: oaepParams}},
+		EncryptedKey:           encryptedKey,
+	}, nil
+}
+
+// DecryptCMS opens an EnvelopedData or AuthEnvelopedData message with privKey. When
+// cert is given only the recipient entry issued for it is tried, otherwise every
+// entry is. RSA-OAEP and PKCS#1 v1.5 key transport are accepted.
+func DecryptCMS(data []byte, privKey *rsa.PrivateKey, cert *x509.Certificate) ([]byte, error) {
+	der, err := berToDER(data)
+	if err != nil {
+		return nil, err
+	}
+	var info contentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedCMSFormat, err)
+	}
+
+	var recipients []keyTransRecipientInfo
+	var content encryptedContentInfo
+	var mac, authAttrs []byte
+	switch {
+	case info.ContentType.Equal(oidEnvelopedData):
+		var enveloped envelopedData
+		if _, err := asn1.Unmarshal(info.Content.Bytes, &enveloped); err != nil {
+			return nil, fmt.Errorf("failed to parse EnvelopedData: %w", err)
+		}
+		recipients, content = enveloped.RecipientInfos, enveloped.EncryptedContentInfo
+	case info.ContentType.Equal(oidAuthEnvelopedData):
+		var enveloped authEnvelopedData
+		if _, err := asn1.Unmarshal(info.Content.Bytes, &enveloped); err != nil {
+			return nil, fmt.Errorf("failed to parse AuthEnvelopedData: %w", err)
+		}
+		recipients, content, mac = enveloped.RecipientInfos, enveloped.AuthEncryptedContentInfo, enveloped.MAC
+		if len(enveloped.AuthAttrs.FullBytes) > 0 {
+			// RFC 5083 authenticates the attributes encoded as a SET, not with their [1] tag.
+			authAttrs = append([]byte{0x31}, enveloped.AuthAttrs.FullBytes[1:]...)
+		}
+	default:
+		return nil, fmt.Errorf("CMS content type %s is not an encrypted message", info.ContentType)
+	}
+
+	var key []byte
+	for _, recipient := range recipients {
+		if cert != nil && !recipient.issuedFor(cert) {
+			continue
+		}
+		if key, err = recipient.unwrap(privKey); err == nil {
+			break
+		}
+	}
+	if key == nil {
+		return nil, errNoMatchingRecipient
+	}
+
+	algorithm := content.ContentEncryptionAlgorithm
+	size, ok := aesKeySizes[algorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content encryption algorithm %s", algorithm.Algorithm)
+	}
+	if len(key) != size {
+		return nil, errNoMatchingRecipient
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := octets(content.EncryptedContent)
+	if err != nil {
+		return nil, err
+	}
+
+	if mac != nil {
+		var params gcmParams
+		if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("invalid AES-GCM parameters: %w", err)
+		}
+		aead, err := cipher.NewGCMWithNonceSize(block, len(params.Nonce))
+		if err != nil {
+			return nil, err
+		}
+		if len(mac) != aead.Overhead() {
+			return nil, fmt.Errorf("unsupported AES-GCM tag length %d", len(mac))
+		}
+		plaintext, err := aead.Open(nil, params.Nonce, append(append([]byte{}, ciphertext...), mac...), authAttrs)
+		if err != nil {
+			return nil, errors.New("message authentication failed, the message was modified")
+		}
+		return plaintext, nil
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid AES-CBC parameters")
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid AES-CBC ciphertext length")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("invalid padding, wrong key or corrupted message")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// issuedFor reports whether the recipient entry identifies cert.
+func (r keyTransRecipientInfo) issuedFor(cert *x509.Certificate) bool {
+	if r.Rid.Class == asn1.ClassContextSpecific && r.Rid.Tag == 0 {
+		return bytes.Equal(r.Rid.Bytes, cert.SubjectKeyId)
+	}
+	var ias issuerAndSerial
+	if _, err := asn1.Unmarshal(r.Rid.FullBytes, &ias); err != nil {
+		return false
+	}
+	return bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
+
+// unwrap decrypts the content encryption key.
+func (r keyTransRecipientInfo) unwrap(privKey *rsa.PrivateKey) ([]byte, error) {
+	switch {
+	case r.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAEncryption):
+		return rsa.DecryptPKCS1v15(nil, privKey, r.EncryptedKey)
+	case r.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAESOAEP):
+		var params rsaesOAEPParams
+		if len(r.KeyEncryptionAlgorithm.Parameters.FullBytes) > 0 {
+			if _, err := asn1.Unmarshal(r.KeyEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+				return nil, fmt.Errorf("invalid RSA-OAEP parameters: %w", err)
+			}
+		}
+		h, err := hashFor(params.HashFunc.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return rsa.DecryptOAEP(h, nil, privKey, r.EncryptedKey, nil)
+	default:
+		return nil, fmt.Errorf("unsupported key transport algorithm %s", r.KeyEncryptionAlgorithm.Algorithm)
+	}
+}
+
+// hashFor returns the hash for a digest algorithm OID. An empty OID means SHA-1,
+// the RSAES-OAEP default.
+func hashFor(oid asn1.ObjectIdentifier) (hash.Hash, error) {
+	switch {
+	case len(oid) == 0, oid.Equal(oidSHA1):
+		return sha1.New(), nil
+	case oid.Equal(oidSHA256):
+		return sha256.New(), nil
+	case oid.Equal(oidSHA384):
+		return sha512.New384(), nil
+	case oid.Equal(oidSHA512):
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm %s", oid)
+	}
+}
+
+// ExtractCMS returns the DER CMS structure held in data, which may be raw DER or
+// BER, PEM ("PKCS7" or "CMS"), or a MIME message or entity whose body is base64
+// application/pkcs7-mime, as saved from a mail client.
+func ExtractCMS(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == 0x30 {
+		return berToDER(data)
+	}
+	if block, _ := pem.Decode(trimmed); block != nil {
+		switch block.Type {
+		case "PKCS7", "CMS", "PKCS #7 SIGNED DATA":
+			return berToDER(block.Bytes)
+		}
+		return nil, fmt.Errorf("%w: unexpected PEM block %q", errUnsupportedCMSFormat, block.Type)
+	}
+
+	msg, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, errUnsupportedCMSFormat
+	}
+	mediaType, _, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/pkcs7-mime" && mediaType != "application/x-pkcs7-mime") {
+		return nil, fmt.Errorf("%w: content type is %q", errUnsupportedCMSFormat, mediaType)
+	}
+	if !strings.EqualFold(msg.Header.Get("Content-Transfer-Encoding"), "base64") {
+		return nil, errors.New("S/MIME body is not base64 encoded")
+	}
+	body := new(bytes.Buffer)
+	if _, err := body.ReadFrom(msg.Body); err != nil {
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, body.String()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 in S/MIME body: %w", err)
+	}
+	return berToDER(der)
+}
+
+// IsCMS reports whether data looks like a CMS message accepted by ExtractCMS.
+func IsCMS(data []byte) bool {
+	_, err := ExtractCMS(data)
+	return err == nil
+}
+
+// SignedEntity splits a multipart/signed MIME entity into the signed content, exactly
+// as it was signed, and the DER signature. ok is false when entity is not
+// multipart/signed.
+func SignedEntity(entity []byte) (content, signature []byte, ok bool, err error) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(entity))
+	if err != nil {
+		return nil, nil, false, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/signed" || params["boundary"] == "" {
+		return nil, nil, false, nil
+	}
+
+	// The first part is taken byte for byte from the entity: multipart.Reader would
+	// hand back its body without headers, which is not what was signed.
+	delimiter := []byte("--" + params["boundary"])
+	start := bytes.Index(entity, append(delimiter, '\r', '\n'))
+	if start < 0 {
+		return nil, nil, true, errors.New("multipart/signed entity has no parts")
+	}
+	start += len(delimiter) + 2
+	end := bytes.Index(entity[start:], append([]byte("\r\n"), delimiter...))
+	if end < 0 {
+		return nil, nil, true, errors.New("multipart/signed entity has no signature part")
+	}
+	content = entity[start : start+end]
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	if _, err := reader.NextPart(); err != nil {
+		return nil, nil, true, err
+	}
+	part, err := reader.NextPart()
+	if err != nil {
+		return nil, nil, true, fmt.Errorf("multipart/signed entity has no signature part: %w", err)
+	}
+	encoded := new(bytes.Buffer)
+	if _, err := encoded.ReadFrom(part); err != nil {
+		return nil, nil, true, err
+	}
+	signature, err = base64.StdEncoding.DecodeString(strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, encoded.String()))
+	if err != nil {
+		return nil, nil, true, fmt.Errorf("invalid base64 signature: %w", err)
+	}
+	return content, signature, true, nil
+}
+
+// berToDER rewrites indefinite length encodings, which mail clients emit for
+// streamed CMS, into definite lengths so encoding/asn1 can parse them. Constructed
+// strings are kept as they are; callers that need their value use octets.
+func berToDER(data []byte) ([]byte, error) {
+	out, rest, err := convertBER(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedCMSFormat, err)
+	}
+	if len(bytes.TrimRight(rest, "\x00\r\n ")) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", errUnsupportedCMSFormat)
+	}
+	return out, nil
+}
+
+func convertBER(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("truncated element")
+	}
+	tagEnd := 1
+	if data[0]&0x1f == 0x1f {
+		for tagEnd < len(data) && data[tagEnd]&0x80 != 0 {
+			tagEnd++
+		}
+		tagEnd++
+	}
+	if tagEnd >= len(data) {
+		return nil, nil, errors.New("truncated tag")
+	}
+	tag := data[:tagEnd]
+	constructed := data[0]&0x20 != 0
+	rest := data[tagEnd:]
+
+	lengthByte := rest[0]
+	rest = rest[1:]
+	if lengthByte == 0x80 {
+		if !constructed {
+			return nil, nil, errors.New("indefinite length on a primitive element")
+		}
+		var body []byte
+		for {
+			if len(rest) < 2 {
+				return nil, nil, errors.New("missing end-of-contents")
+			}
+			if rest[0] == 0 && rest[1] == 0 {
+				rest = rest[2:]
+				break
+			}
+			child, remaining, err := convertBER(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			body = append(body, child...)
+			rest = remaining
+		}
+		return append(append(append([]byte{}, tag...), encodeLength(len(body))...), body...), rest, nil
+	}
+
+	length := int(lengthByte)
+	if lengthByte&0x80 != 0 {
+		n := int(lengthByte & 0x7f)
+		if n > 4 || n > len(rest) {
+			return nil, nil, errors.New("invalid length")
+		}
+		length = 0
+		for _, b := range rest[:n] {
+			length = length<<8 | int(b)
+		}
+		rest = rest[n:]
+	}
+	if length > len(rest) {
+		return nil, nil, errors.New("element longer than its data")
+	}
+	content, remaining := rest[:length], rest[length:]
+
+	if !constructed {
+		return append(append(append([]byte{}, tag...), encodeLength(length)...), content...), remaining, nil
+	}
+	var body []byte
+	for len(content) > 0 {
+		child, more, err := convertBER(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = append(body, child...)
+		content = more
+	}
+	return append(append(append([]byte{}, tag...), encodeLength(len(body))...), body...), remaining, nil
+}
+
+// octets returns the value of an implicitly tagged OCTET STRING, joining the
+// segments of a constructed encoding.
+func octets(raw asn1.RawValue) ([]byte, error) {
+	if !raw.IsCompound {
+		return raw.Bytes, nil
+	}
+	var value []byte
+	rest := raw.Bytes
+	for len(rest) > 0 {
+		var segment asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &segment); err != nil {
+			return nil, fmt.Errorf("invalid constructed octet string: %w", err)
+		}
+		part, err := octets(segment)
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, part...)
+	}
+	return value, nil
+}
+
+func encodeLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var digits []byte
+	for l := length; l > 0; l >>= 8 {
+		digits = append([]byte{byte(l)}, digits...)
+	}
+	return append([]byte{0x80 | byte(len(digits))}, digits...)
+}
@@ -0,0 +1,541 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+const cmsTestContent = "Content-Type: text/plain; charset=utf-8\r\n\r\nthe quarterly report\r\n"
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testCertificate issues a certificate for key, self-signed when issuer is nil.
+func testCertificate(t *testing.T, name string, key crypto.Signer, issuer *x509.Certificate, issuerKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		EmailAddresses:        []string{name + "@example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+	if issuer == nil {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		issuer, issuerKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// rewriteCMS decodes the content of a ContentInfo into inner, lets edit change it
+// and encodes the result again.
+func rewriteCMS(t *testing.T, der []byte, inner interface{}, edit func()) []byte {
+	t.Helper()
+	var info contentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, inner); err != nil {
+		t.Fatal(err)
+	}
+	edit()
+	innerDER, err := asn1.Marshal(reflectValue(inner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := asn1.Marshal(contentInfo{
+		ContentType: info.ContentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: innerDER},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// reflectValue dereferences the pointers rewriteCMS is given, as asn1.Marshal
+// encodes a struct rather than a pointer to one.
+func reflectValue(inner interface{}) interface{} {
+	switch v := inner.(type) {
+	case *envelopedData:
+		return *v
+	case *authEnvelopedData:
+		return *v
+	case *signedData:
+		return *v
+	}
+	return inner
+}
+
+func TestCMSEncryptDecrypt(t *testing.T) {
+	bobKey, carolKey := testRSAKey(t), testRSAKey(t)
+	bob := testCertificate(t, "bob", bobKey, nil, nil)
+	carol := testCertificate(t, "carol", carolKey, nil, nil)
+
+	for _, contentCipher := range []string{CMSCipherAES256CBC, CMSCipherAES256GCM} {
+		for _, recipients := range [][]*x509.Certificate{{bob}, {bob, carol}} {
+			t.Run(fmt.Sprintf("%s/%d recipients", contentCipher, len(recipients)), func(t *testing.T) {
+				der, err := EncryptCMS([]byte(cmsTestContent), recipients, contentCipher)
+				if err != nil {
+					t.Fatalf("EncryptCMS() = %v", err)
+				}
+				var info contentInfo
+				if _, err := asn1.Unmarshal(der, &info); err != nil {
+					t.Fatal(err)
+				}
+				wantType := map[string]asn1.ObjectIdentifier{CMSCipherAES256CBC: oidEnvelopedData, CMSCipherAES256GCM: oidAuthEnvelopedData}[contentCipher]
+				if !info.ContentType.Equal(wantType) {
+					t.Fatalf("content type %s, want %s", info.ContentType, wantType)
+				}
+				if bytes.Contains(der, []byte("quarterly report")) {
+					t.Fatal("plaintext visible in the CMS message")
+				}
+
+				keys := map[*x509.Certificate]*rsa.PrivateKey{bob: bobKey, carol: carolKey}
+				for _, cert := range recipients {
+					// With the certificate only its entry is tried, without it every entry is.
+					for _, match := range []*x509.Certificate{cert, nil} {
+						plaintext, err := DecryptCMS(der, keys[cert], match)
+						if err != nil {
+							t.Fatalf("DecryptCMS() for %s = %v", cert.Subject.CommonName, err)
+						}
+						if string(plaintext) != cmsTestContent {
+							t.Fatalf("DecryptCMS() = %q, want %q", plaintext, cmsTestContent)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCMSEncryptRejects(t *testing.T) {
+	if _, err := EncryptCMS([]byte(cmsTestContent), nil, CMSCipherAES256CBC); err == nil {
+		t.Error("EncryptCMS() accepted no recipients")
+	}
+	key := testRSAKey(t)
+	cert := testCertificate(t, "bob", key, nil, nil)
+	if _, err := EncryptCMS([]byte(cmsTestContent), []*x509.Certificate{cert}, "des-ede3-cbc"); err == nil {
+		t.Error("EncryptCMS() accepted an unsupported cipher")
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EncryptCMS([]byte(cmsTestContent), []*x509.Certificate{testCertificate(t, "eve", ecKey, nil, nil)}, CMSCipherAES256CBC); err == nil {
+		t.Error("EncryptCMS() accepted a certificate without an RSA key")
+	}
+}
+
+func TestCMSDecryptWrongKey(t *testing.T) {
+	bobKey, malloryKey := testRSAKey(t), testRSAKey(t)
+	bob := testCertificate(t, "bob", bobKey, nil, nil)
+	mallory := testCertificate(t, "mallory", malloryKey, nil, nil)
+
+	for _, contentCipher := range []string{CMSCipherAES256CBC, CMSCipherAES256GCM} {
+		t.Run(contentCipher, func(t *testing.T) {
+			der, err := EncryptCMS([]byte(cmsTestContent), []*x509.Certificate{bob}, contentCipher)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := DecryptCMS(der, malloryKey, nil); !errors.Is(err, errNoMatchingRecipient) {
+				t.Errorf("DecryptCMS() with another key = %v, want errNoMatchingRecipient", err)
+			}
+			if _, err := DecryptCMS(der, malloryKey, mallory); !errors.Is(err, errNoMatchingRecipient) {
+				t.Errorf("DecryptCMS() with a certificate that is no recipient = %v, want errNoMatchingRecipient", err)
+			}
+			if _, err := DecryptCMS(der, bobKey, mallory); !errors.Is(err, errNoMatchingRecipient) {
+				t.Errorf("DecryptCMS() with the right key but another certificate = %v, want errNoMatchingRecipient", err)
+			}
+		})
+	}
+}
+
+func TestCMSDecryptTamperedGCM(t *testing.T) {
+	key := testRSAKey(t)
+	cert := testCertificate(t, "bob", key, nil, nil)
+	der, err := EncryptCMS([]byte(cmsTestContent), []*x509.Certificate{cert}, CMSCipherAES256GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(*authEnvelopedData)
+	}{
+		{"mac", func(e *authEnvelopedData) { e.MAC[0] ^= 0x01 }},
+		{"ciphertext", func(e *authEnvelopedData) {
+			ciphertext := append([]byte{}, e.AuthEncryptedContentInfo.EncryptedContent.Bytes...)
+			ciphertext[len(ciphertext)/2] ^= 0x01
+			e.AuthEncryptedContentInfo.EncryptedContent = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var enveloped authEnvelopedData
+			tampered := rewriteCMS(t, der, &enveloped, func() { tt.edit(&enveloped) })
+			_, err := DecryptCMS(tampered, key, cert)
+			if err == nil || !strings.Contains(err.Error(), "message authentication failed") {
+				t.Fatalf("DecryptCMS() = %v, want an authentication failure", err)
+			}
+		})
+	}
+}
+
+func TestCMSDecryptBadCBCPadding(t *testing.T) {
+	key := testRSAKey(t)
+	cert := testCertificate(t, "bob", key, nil, nil)
+	der, err := EncryptCMS([]byte(cmsTestContent), []*x509.Certificate{cert}, CMSCipherAES256CBC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-encrypt blocks with the message's own key and IV, so only the padding is wrong.
+	var original envelopedData
+	rewriteCMS(t, der, &original, func() {})
+	contentKey, err := original.RecipientInfos[0].unwrap(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(original.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		t.Fatal(err)
+	}
+	encrypt := func(plaintext []byte) []byte {
+		block, err := aes.NewCipher(contentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext := make([]byte, len(plaintext))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+		return ciphertext
+	}
+
+	block := bytes.Repeat([]byte("x"), aes.BlockSize)
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		wantErr    string
+	}{
+		{"zero padding byte", encrypt(append(append([]byte{}, block...), append(bytes.Repeat([]byte("y"), 15), 0)...)), "invalid padding"},
+		{"padding longer than a block", encrypt(append(append([]byte{}, block...), bytes.Repeat([]byte{17}, 16)...)), "invalid padding"},
+		{"inconsistent padding", encrypt(append(append([]byte{}, block...), append(bytes.Repeat([]byte("y"), 13), 1, 2, 3)...)), "invalid padding"},
+		{"partial block", encrypt(block)[:10], "ciphertext length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var enveloped envelopedData
+			tampered := rewriteCMS(t, der, &enveloped, func() {
+				enveloped.EncryptedContentInfo.EncryptedContent = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: tt.ciphertext}
+			})
+			_, err := DecryptCMS(tampered, key, cert)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("DecryptCMS() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCMSDecryptSegmentedBER(t *testing.T) {
+	key := testRSAKey(t)
+	cert := testCertificate(t, "bob", key, nil, nil)
+	der, err := EncryptCMS([]byte(cmsTestContent), []*x509.Certificate{cert}, CMSCipherAES256CBC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Streaming clients split the ciphertext into a constructed OCTET STRING and
+	// use indefinite lengths.
+	var enveloped envelopedData
+	segmented := rewriteCMS(t, der, &enveloped, func() {
+		ciphertext := enveloped.EncryptedContentInfo.EncryptedContent.Bytes
+		var segments []byte
+		for len(ciphertext) > 0 {
+			n := 16
+			if n > len(ciphertext) {
+				n = len(ciphertext)
+			}
+			segment, err := asn1.Marshal(ciphertext[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			segments = append(segments, segment...)
+			ciphertext = ciphertext[n:]
+		}
+		enveloped.EncryptedContentInfo.EncryptedContent = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: segments}
+	})
+	var info contentInfo
+	if _, err := asn1.Unmarshal(segmented, &info); err != nil {
+		t.Fatal(err)
+	}
+	oid, err := asn1.Marshal(info.ContentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ber := append([]byte{0x30, 0x80}, oid...)
+	ber = append(append(append(ber, 0xa0, 0x80), info.Content.Bytes...), 0, 0, 0, 0)
+
+	plaintext, err := DecryptCMS(ber, key, cert)
+	if err != nil {
+		t.Fatalf("DecryptCMS() of indefinite length BER = %v", err)
+	}
+	if string(plaintext) != cmsTestContent {
+		t.Fatalf("DecryptCMS() = %q, want %q", plaintext, cmsTestContent)
+	}
+}
+
+func TestCMSDecryptAuthenticatedAttributes(t *testing.T) {
+	key := testRSAKey(t)
+	cert := testCertificate(t, "bob", key, nil, nil)
+
+	contentKey := make([]byte, 32)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(contentKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	mustMarshal := func(v interface{}) []byte {
+		der, err := asn1.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	set := func(contents ...[]byte) []byte {
+		return mustMarshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(contents, nil)})
+	}
+	// implicit replaces the SET tag with a context-specific constructed one.
+	implicit := func(tag byte, setDER []byte) []byte {
+		return append([]byte{0xa0 | tag}, setDER[1:]...)
+	}
+
+	// authAttrs as Outlook sends them: the content type of the encrypted content.
+	attrs := set(mustMarshal(attribute{Type: oidAttrContentType, Values: asn1.RawValue{FullBytes: set(mustMarshal(oidData))}}))
+	unauthAttrs := set(mustMarshal(attribute{Type: oidAttrSigningTime, Values: asn1.RawValue{FullBytes: set(mustMarshal(time.Now().UTC()))}}))
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 5083 section 2.1: the DER SET of the attributes is the additional data.
+	sealed := aead.Seal(nil, nonce, []byte(cmsTestContent), attrs)
+	ciphertext, mac := sealed[:len(cmsTestContent)], sealed[len(cmsTestContent):]
+
+	recipient, err := newKeyTransRecipientInfo(cert, contentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentInfoDER := mustMarshal(encryptedContentInfo{
+		ContentType: oidData,
+		ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidAES256GCM,
+			Parameters: asn1.RawValue{FullBytes: mustMarshal(gcmParams{Nonce: nonce, ICVLen: 16})},
+		},
+		EncryptedContent: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext},
+	})
+
+	// build encodes AuthEnvelopedData field by field in the order of RFC 5083.
+	build := func(authAttrs []byte) []byte {
+		fields := bytes.Join([][]byte{
+			mustMarshal(0),
+			set(mustMarshal(recipient)),
+			contentInfoDER,
+			authAttrs,
+			mustMarshal(mac),
+			implicit(2, unauthAttrs),
+		}, nil)
+		return mustMarshal(contentInfo{
+			ContentType: oidAuthEnvelopedData,
+			Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+				Bytes: mustMarshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: fields})},
+		})
+	}
+
+	plaintext, err := DecryptCMS(build(implicit(1, attrs)), key, cert)
+	if err != nil {
+		t.Fatalf("DecryptCMS() with authAttrs and unauthAttrs = %v", err)
+	}
+	if string(plaintext) != cmsTestContent {
+		t.Fatalf("DecryptCMS() = %q, want %q", plaintext, cmsTestContent)
+	}
+
+	// The attributes are authenticated: changing or dropping them fails.
+	changed := implicit(1, attrs)
+	changed[len(changed)-1] ^= 0x03 // id-data becomes id-signedData
+	for name, authAttrs := range map[string][]byte{"changed": changed, "dropped": nil} {
+		if _, err := DecryptCMS(build(authAttrs), key, cert); err == nil || !strings.Contains(err.Error(), "message authentication failed") {
+			t.Errorf("DecryptCMS() with %s authAttrs = %v, want an authentication failure", name, err)
+		}
+	}
+}
+
+func TestCMSSignVerifyDetached(t *testing.T) {
+	caKey := testRSAKey(t)
+	ca := testCertificate(t, "ca", caKey, nil, nil)
+	rsaKey := testRSAKey(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		cert  *x509.Certificate
+		key   crypto.Signer
+		chain []*x509.Certificate
+	}{
+		{"rsa", testCertificate(t, "alice", rsaKey, ca, caKey), rsaKey, []*x509.Certificate{ca}},
+		{"ecdsa", testCertificate(t, "alice", ecKey, nil, nil), ecKey, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature, err := SignCMSDetached([]byte(cmsTestContent), tt.cert, tt.key, tt.chain)
+			if err != nil {
+				t.Fatalf("SignCMSDetached() = %v", err)
+			}
+			signer, err := VerifyCMSDetached(signature, []byte(cmsTestContent))
+			if err != nil {
+				t.Fatalf("VerifyCMSDetached() = %v", err)
+			}
+			// The chain travels with the signature, but the signer is the leaf.
+			if !signer.Equal(tt.cert) {
+				t.Fatalf("VerifyCMSDetached() returned %s, want the signer's certificate", signer.Subject.CommonName)
+			}
+
+			modified := strings.Replace(cmsTestContent, "quarterly", "annual", 1)
+			if _, err := VerifyCMSDetached(signature, []byte(modified)); err == nil || !strings.Contains(err.Error(), "the message was modified") {
+				t.Fatalf("VerifyCMSDetached() of modified content = %v, want the message digest mismatch", err)
+			}
+
+			var signed signedData
+			forged := rewriteCMS(t, signature, &signed, func() {
+				signed.SignerInfos[0].Signature[len(signed.SignerInfos[0].Signature)/2] ^= 0x01
+			})
+			if _, err := VerifyCMSDetached(forged, []byte(cmsTestContent)); err == nil || !strings.Contains(err.Error(), "signature is invalid") {
+				t.Fatalf("VerifyCMSDetached() of a changed signature = %v, want it rejected", err)
+			}
+
+			var stripped signedData
+			withoutCerts := rewriteCMS(t, signature, &stripped, func() { stripped.Certificates = asn1.RawValue{} })
+			if _, err := VerifyCMSDetached(withoutCerts, []byte(cmsTestContent)); !errors.Is(err, errNoSignerCertificate) {
+				t.Fatalf("VerifyCMSDetached() without certificates = %v, want errNoSignerCertificate", err)
+			}
+		})
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SignCMSDetached([]byte(cmsTestContent), tests[0].cert, edKey, nil); err == nil {
+		t.Fatal("SignCMSDetached() accepted an Ed25519 key")
+	}
+}
+
+func TestBERToDER(t *testing.T) {
+	// SEQUENCE { INTEGER 5, [0] { constructed OCTET STRING { "ab", "cd" } } } with
+	// indefinite lengths throughout.
+	ber := []byte{
+		0x30, 0x80,
+		0x02, 0x01, 0x05,
+		0xa0, 0x80,
+		0x24, 0x80,
+		0x04, 0x02, 'a', 'b',
+		0x04, 0x02, 'c', 'd',
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+	}
+	want := []byte{
+		0x30, 0x0f,
+		0x02, 0x01, 0x05,
+		0xa0, 0x0a,
+		0x24, 0x08,
+		0x04, 0x02, 'a', 'b',
+		0x04, 0x02, 'c', 'd',
+	}
+	der, err := berToDER(ber)
+	if err != nil {
+		t.Fatalf("berToDER() = %v", err)
+	}
+	if !bytes.Equal(der, want) {
+		t.Fatalf("berToDER() = % x, want % x", der, want)
+	}
+
+	// The constructed string is kept; octets joins its segments.
+	var outer struct {
+		N       int
+		Wrapped asn1.RawValue `asn1:"tag:0"`
+	}
+	if _, err := asn1.Unmarshal(der, &outer); err != nil {
+		t.Fatal(err)
+	}
+	var value asn1.RawValue
+	if _, err := asn1.Unmarshal(outer.Wrapped.Bytes, &value); err != nil {
+		t.Fatal(err)
+	}
+	if joined, err := octets(value); err != nil || string(joined) != "abcd" {
+		t.Fatalf("octets() = %q, %v, want abcd", joined, err)
+	}
+
+	// DER input and long definite lengths pass through unchanged.
+	long := append([]byte{0x04, 0x81, 0xc8}, bytes.Repeat([]byte{'z'}, 200)...)
+	if der, err := berToDER(long); err != nil || !bytes.Equal(der, long) {
+		t.Fatalf("berToDER() changed DER input: %v", err)
+	}
+
+	for name, bad := range map[string][]byte{
+		"missing end-of-contents":      {0x30, 0x80, 0x02, 0x01, 0x05},
+		"indefinite primitive":         {0x04, 0x80, 'a', 0x00, 0x00},
+		"element longer than its data": {0x30, 0x05, 0x02, 0x01},
+		"trailing data":                {0x02, 0x01, 0x05, 0x02},
+		"truncated":                    {0x30},
+	} {
+		if _, err := berToDER(bad); !errors.Is(err, errUnsupportedCMSFormat) {
+			t.Errorf("berToDER(%s) = %v, want errUnsupportedCMSFormat", name, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	oidECDSAWithSHA256     = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidAttrContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	errNoSignerCertificate = errors.New("signature does not include the signer's certificate")
+)
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"optional,explicit,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	Sid                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// SignCMSDetached signs content with a detached SHA-256 CMS SignedData, as carried in
+// the application/pkcs7-signature part of a multipart/signed message. The signer's
+// certificate and chain are included so recipients can verify without a directory.
+func SignCMSDetached(content []byte, cert *x509.Certificate, key crypto.Signer, chain []*x509.Certificate) ([]byte, error) {
+	var signatureAlgorithm pkix.AlgorithmIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T, use RSA or ECDSA", key.Public())
+	}
+
+	digest := sha256.Sum256(content)
+	attrs, err := marshalSignedAttributes(digest[:], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	// The signature covers the attributes encoded as a SET, not with their [0] tag.
+	attrsDigest := sha256.Sum256(append([]byte{0x31}, attrs.FullBytes[1:]...))
+	signature, err := key.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	sid, err := asn1.Marshal(issuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber})
+	if err != nil {
+		return nil, err
+	}
+	var certs []byte
+	for _, c := range append([]*x509.Certificate{cert}, chain...) {
+		certs = append(certs, c.Raw...)
+	}
+
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	inner, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			Sid:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    sha256Alg,
+			SignedAttrs:        attrs,
+			SignatureAlgorithm: signatureAlgorithm,
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
+
+// marshalSignedAttributes encodes the content type, message digest and signing
+// time attributes as the [0] IMPLICIT SET of a SignerInfo, sorted as DER requires.
+func marshalSignedAttributes(digest []byte, signingTime time.Time) (asn1.RawValue, error) {
+	values := []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidAttrContentType, oidData},
+		{oidAttrMessageDigest, digest},
+		{oidAttrSigningTime, signingTime.UTC()},
+	}
+
+	var encoded [][]byte
+	for _, v := range values {
+		value, err := asn1.Marshal(v.value)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		attr, err := asn1.Marshal(attribute{
+			Type:   v.oid,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		encoded = append(encoded, attr)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+
+	raw, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(encoded, nil)})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	return asn1.RawValue{FullBytes: raw}, nil
+}
+
+// VerifyCMSDetached checks a detached SignedData over content and returns the
+// certificate of the first signer whose signature is valid. Only the signature is
+// checked; whether the certificate is trusted is up to the caller.
+func VerifyCMSDetached(signature, content []byte) (*x509.Certificate, error) {
+	der, err := berToDER(signature)
+	if err != nil {
+		return nil, err
+	}
+	var info contentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedCMSFormat, err)
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("CMS content type %s is not a signature", info.ContentType)
+	}
+	var signed signedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return nil, fmt.Errorf("failed to parse SignedData: %w", err)
+	}
+
+	certs, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate in signature: %w", err)
+	}
+
+	err = errors.New("signature has no signers")
+	for _, signer := range signed.SignerInfos {
+		var cert *x509.Certificate
+		for _, candidate := range certs {
+			if (keyTransRecipientInfo{Rid: signer.Sid}).issuedFor(candidate) {
+				cert = candidate
+				break
+			}
+		}
+		if cert == nil {
+			err = errNoSignerCertificate
+			continue
+		}
+		if err = signer.verify(cert, content); err == nil {
+			return cert, nil
+		}
+	}
+	return nil, err
+}
+
+func (s signerInfo) verify(cert *x509.Certificate, content []byte) error {
+	h, err := hashFor(s.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	h.Write(content)
+	contentDigest := h.Sum(nil)
+
+	var hashID crypto.Hash
+	switch {
+	case s.DigestAlgorithm.Algorithm.Equal(oidSHA256):
+		hashID = crypto.SHA256
+	case s.DigestAlgorithm.Algorithm.Equal(oidSHA384):
+		hashID = crypto.SHA384
+	case s.DigestAlgorithm.Algorithm.Equal(oidSHA512):
+		hashID = crypto.SHA512
+	default:
+		hashID = crypto.SHA1
+	}
+
+	signedBytes := content
+	if len(s.SignedAttrs.FullBytes) > 0 {
+		var attrs []attribute
+		if _, err := asn1.UnmarshalWithParams(s.SignedAttrs.FullBytes, &attrs, "set,tag:0"); err != nil {
+			return fmt.Errorf("invalid signed attributes: %w", err)
+		}
+		var messageDigest []byte
+		for _, attr := range attrs {
+			if attr.Type.Equal(oidAttrMessageDigest) {
+				if _, err := asn1.Unmarshal(attr.Values.Bytes, &messageDigest); err != nil {
+					return fmt.Errorf("invalid message digest attribute: %w", err)
+				}
+			}
+		}
+		if !bytes.Equal(messageDigest, contentDigest) {
+			return errors.New("signed content does not match the signature, the message was modified")
+		}
+		signedBytes = append([]byte{0x31}, s.SignedAttrs.FullBytes[1:]...)
+	}
+
+	h, _ = hashFor(s.DigestAlgorithm.Algorithm)
+	h.Write(signedBytes)
+	digest := h.Sum(nil)
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, hashID, digest, s.Signature); err != nil {
+			return errors.New("signature is invalid")
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, s.Signature) {
+			return errors.New("signature is invalid")
+		}
+	default:
+		return fmt.Errorf("unsupported signer key type %T", cert.PublicKey)
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -22,11 +23,35 @@ import (
 
 type EncryptedData struct {
 	EncryptedMessage []byte `json:"encrypted_message"`
-	EncryptedAESKey  []byte `json:"encrypted_aes_key"`
+	EncryptedAESKey  []byte `json:"encrypted_aes_key,omitempty"`
+	// Recipients holds one wrapped AES key per recipient in a multi-recipient
+	// envelope, in which case EncryptedAESKey is empty.
+	Recipients []RecipientKey `json:"recipients,omitempty"`
 }
 
 func HybridEncryption(plaintext []byte, pub *rsa.PublicKey) ([]byte, []byte, error) {
 	logger.Logger.Info("Starting hybrid encryption process")
+	aesKey, encryptedMsg, err := sealWithNewKey(plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Encrypt the AES key with RSA-OAEP.
+	encryptedAESKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, aesKey, []byte(""))
+	if err != nil {
+		utility.Error("failed to encrypt AES key with RSA: %v", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to encrypt AES key with RSA")
+		return nil, nil, err
+	}
+
+	utility.Success("Message is encrypted successfully!")
+	logger.Logger.Info("Message is encrypted successfully!")
+	return encryptedMsg, encryptedAESKey, nil
+}
+
+// sealWithNewKey encrypts plaintext with AES-256-GCM under a fresh random key and
+// returns the key and the nonce-prefixed ciphertext.
+func sealWithNewKey(plaintext []byte) ([]byte, []byte, error) {
 	// Generate a random 32-byte AES key.
 	aesKey := make([]byte, 32)
 	if _, err := rand.Read(aesKey); err != nil {
@@ -58,19 +83,7 @@ func HybridEncryption(plaintext []byte, pub *rsa.PublicKey) ([]byte, []byte, err
 		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to generate nonce")
 		return nil, nil, err
 	}
-	encryptedMsg := gcm.Seal(nonce, nonce, plaintext, nil)
-
-	// Encrypt the AES key with RSA-OAEP.
-	encryptedAESKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, aesKey, []byte(""))
-	if err != nil {
-		utility.Error("failed to encrypt AES key with RSA: %v", err)
-		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to encrypt AES key with RSA")
-		return nil, nil, err
-	}
-
-	utility.Success("Message is encrypted successfully!")
-	logger.Logger.Info("Message is encrypted successfully!")
-	return encryptedMsg, encryptedAESKey, nil
+	return aesKey, gcm.Seal(nonce, nonce, plaintext, nil), nil
 }
 
 func EncryptHybridData(encryptedMsg, encryptedAESKey []byte, outputFilePath, outputFileName string) error {
@@ -99,7 +112,7 @@ func EncryptHybridData(encryptedMsg, encryptedAESKey []byte, outputFilePath, out
 	}
 
 	fullPath := filepath.Join(absOutputFilePath, outputFileName+env.Vars.JSON_FORMAT)
-	jsonData, err := json.MarshalIndent(data, "", "  ")
+	jsonData, err := MarshalEnvelope(data.EncryptedMessage, data.EncryptedAESKey)
 	if err != nil {
 		utility.Error("failed to marshal encrypted data: %v", err)
 		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Marshal encryption")
@@ -117,6 +130,34 @@ func EncryptHybridData(encryptedMsg, encryptedAESKey []byte, outputFilePath, out
 	return nil
 }
 
+// MarshalEnvelope encodes an encrypted message and its wrapped AES key as the JSON
+// envelope written by encrypt.
+func MarshalEnvelope(encryptedMsg, encryptedAESKey []byte) ([]byte, error) {
+	return json.MarshalIndent(EncryptedData{
+		EncryptedMessage: encryptedMsg,
+		EncryptedAESKey:  encryptedAESKey,
+	}, "", "  ")
+}
+
+// minCiphertextSize is the AES-GCM nonce plus authentication tag.
+const minCiphertextSize = 12 + 16
+
+// ParseEnvelope decodes a JSON envelope and checks that it is structurally valid,
+// without decrypting it.
+func ParseEnvelope(data []byte) (*EncryptedData, error) {
+	var envelope EncryptedData
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("not a cryptix envelope: %w", err)
+	}
+	if len(envelope.EncryptedAESKey) == 0 && len(envelope.Recipients) == 0 {
+		return nil, errors.New("not a cryptix envelope: missing encrypted_aes_key")
+	}
+	if len(envelope.EncryptedMessage) < minCiphertextSize {
+		return nil, errors.New("not a cryptix envelope: encrypted_message is missing or truncated")
+	}
+	return &envelope, nil
+}
+
 // HybridDecryption decrypts the AES key with RSA-OAEP and then decrypts the message using AES-GCM.
 func HybridDecryption(jsonFilePath string, privKey *rsa.PrivateKey) ([]byte, error) {
 	logger.Logger.Info("Starting hybrid decryption process")
@@ -143,14 +184,41 @@ func HybridDecryption(jsonFilePath string, privKey *rsa.PrivateKey) ([]byte, err
 	}
 
 	logger.Logger.Info("Successfully loaded encrypted data")
+	return openEnvelope(&encryptedData, privKey)
+}
+
+// DecryptEnvelope decodes a JSON envelope as it is read from r, for instance while
+// it downloads, and decrypts it like HybridDecryption.
+func DecryptEnvelope(r io.Reader, privKey *rsa.PrivateKey) ([]byte, error) {
+	logger.Logger.Info("Starting hybrid decryption process")
+
+	var encryptedData EncryptedData
+	if err := json.NewDecoder(r).Decode(&encryptedData); err != nil {
+		utility.Error("Failed to parse encrypted JSON: %s", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to parse encrypted JSON")
+		return nil, fmt.Errorf("failed to parse encrypted JSON: %w", err)
+	}
+
+	logger.Logger.Info("Successfully loaded encrypted data")
+	return openEnvelope(&encryptedData, privKey)
+}
+
+// openEnvelope unwraps the AES key with RSA-OAEP and decrypts the message with AES-GCM.
+func openEnvelope(encryptedData *EncryptedData, privKey *rsa.PrivateKey) ([]byte, error) {
+	if len(encryptedData.Recipients) > 0 {
+		wrappedKey, err := encryptedData.keyFor(&privKey.PublicKey)
+		if err != nil {
+			utility.Error("%s", err)
+			logger.Logger.WithFields(logrus.Fields{"err": err}).Error("No recipient entry for private key")
+			return nil, err
+		}
+		encryptedData.EncryptedAESKey = wrappedKey
+	}
 
 	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privKey, encryptedData.EncryptedAESKey, nil)
 	if err != nil {
 		utility.Error("RSA decryption failed: %s", err)
-		logger.Logger.WithFields(logrus.Fields{
-			"file": jsonFilePath,
-			"err":  err,
-		}).Error("RSA decryption failed")
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("RSA decryption failed")
 		return nil, fmt.Errorf("RSA decryption failed: %w", err)
 	}
 
@@ -234,6 +302,11 @@ func LoadPublicKey(path string) (*rsa.PublicKey, error) {
 		return nil, err
 	}
 
+	// JWK files are detected by content so any extension works.
+	if IsJWK(pubKeyBytes) {
+		return loadJWKPublicKey(pubKeyBytes)
+	}
+
 	// Decode PEM block.
 	block, _ := pem.Decode(pubKeyBytes)
 	if block == nil {
@@ -289,6 +362,43 @@ func LoadPublicKey(path string) (*rsa.PublicKey, error) {
 	return pubKey, nil
 }
 
+func loadJWKPublicKey(data []byte) (*rsa.PublicKey, error) {
+	jwk, err := ParseJWK(data, "")
+	if err != nil {
+		utility.Error("%s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"err": err,
+		}).Error("failed to parse JWK public key")
+		return nil, err
+	}
+
+	key, err := jwk.PublicKey()
+	if err != nil {
+		utility.Error("%s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"kid": jwk.Kid,
+			"err": err,
+		}).Error("failed to parse JWK public key")
+		return nil, err
+	}
+
+	pubKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		utility.Error("not an RSA public key")
+		logger.Logger.WithFields(logrus.Fields{
+			"kid": jwk.Kid,
+			"kty": jwk.Kty,
+		}).Error("not an RSA public key")
+		return nil, errors.New("not an RSA public key")
+	}
+
+	utility.Success("Public key file loaded successfully!")
+	logger.Logger.WithFields(logrus.Fields{
+		"kid": jwk.Kid,
+	}).Info("Public key file loaded successfully!")
+	return pubKey, nil
+}
+
 func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
 	absPathOfKey, err := filepath.Abs(path)
 	if err != nil {
@@ -0,0 +1,349 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a JSON Web Key (RFC 7517) holding an RSA, EC or OKP (Ed25519) key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA parameters.
+	N  string `json:"n,omitempty"`
+	E  string `json:"e,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
+
+	// EC and OKP parameters.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+
+	// Private exponent for RSA, private scalar or seed for EC and OKP.
+	D string `json:"d,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set as served by JWKS endpoints.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// NewJWK converts a public or private key into a JWK. When kid is empty the
+// RFC 7638 thumbprint of the public key is used.
+func NewJWK(key interface{}, kid string) (*JWK, error) {
+	var jwk JWK
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		k.Precompute()
+		jwk = rsaPublicJWK(&k.PublicKey)
+		jwk.D = b64.EncodeToString(k.D.Bytes())
+		if len(k.Primes) == 2 {
+			jwk.P = b64.EncodeToString(k.Primes[0].Bytes())
+			jwk.Q = b64.EncodeToString(k.Primes[1].Bytes())
+			jwk.DP = b64.EncodeToString(k.Precomputed.Dp.Bytes())
+			jwk.DQ = b64.EncodeToString(k.Precomputed.Dq.Bytes())
+			jwk.QI = b64.EncodeToString(k.Precomputed.Qinv.Bytes())
+		}
+	case *rsa.PublicKey:
+		jwk = rsaPublicJWK(k)
+	case *ecdsa.PrivateKey:
+		pub, err := ecPublicJWK(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		jwk = pub
+		jwk.D = b64.EncodeToString(k.D.FillBytes(make([]byte, (k.Curve.Params().BitSize+7)/8)))
+	case *ecdsa.PublicKey:
+		pub, err := ecPublicJWK(k)
+		if err != nil {
+			return nil, err
+		}
+		jwk = pub
+	case ed25519.PrivateKey:
+		jwk = JWK{Kty: "OKP", Crv: "Ed25519", X: b64.EncodeToString(k.Public().(ed25519.PublicKey))}
+		jwk.D = b64.EncodeToString(k.Seed())
+	case ed25519.PublicKey:
+		jwk = JWK{Kty: "OKP", Crv: "Ed25519", X: b64.EncodeToString(k)}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		jwk.Use, jwk.Alg = "enc", "RSA-OAEP-256"
+	case "OKP":
+		jwk.Use, jwk.Alg = "sig", "EdDSA"
+	}
+
+	if kid == "" {
+		thumbprint, err := jwk.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		kid = thumbprint
+	}
+	jwk.Kid = kid
+
+	return &jwk, nil
+}
+
+func rsaPublicJWK(pub *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		N:   b64.EncodeToString(pub.N.Bytes()),
+		E:   b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func ecPublicJWK(pub *ecdsa.PublicKey) (JWK, error) {
+	var crv string
+	switch pub.Curve {
+	case elliptic.P256():
+		crv = "P-256"
+	case elliptic.P384():
+		crv = "P-384"
+	case elliptic.P521():
+		crv = "P-521"
+	default:
+		return JWK{}, errors.New("unsupported elliptic curve")
+	}
+
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	return JWK{
+		Kty: "EC",
+		Crv: crv,
+		X:   b64.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+		Y:   b64.EncodeToString(pub.Y.FillBytes(make([]byte, size))),
+	}, nil
+}
+
+// Thumbprint returns the base64url encoded RFC 7638 SHA-256 thumbprint of the key.
+func (k *JWK) Thumbprint() (string, error) {
+	// The required members in lexicographic order, as the RFC mandates.
+	var members string
+	switch k.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	default:
+		return "", fmt.Errorf("unsupported JWK key type: %s", k.Kty)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return b64.EncodeToString(sum[:]), nil
+}
+
+// IsPrivate reports whether the JWK carries private key material.
+func (k *JWK) IsPrivate() bool {
+	return k.D != ""
+}
+
+// Public returns a copy of the JWK with all private members removed.
+func (k *JWK) Public() *JWK {
+	pub := *k
+	pub.D, pub.P, pub.Q, pub.DP, pub.DQ, pub.QI = "", "", "", "", "", ""
+	return &pub
+}
+
+// Key converts the JWK into a Go crypto key. Private JWKs yield a private key.
+func (k *JWK) Key() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		return k.rsaKey()
+	case "EC":
+		return k.ecKey()
+	case "OKP":
+		return k.okpKey()
+	default:
+		return nil, fmt.Errorf("unsupported JWK key type: %s", k.Kty)
+	}
+}
+
+// PublicKey converts the JWK into a Go crypto public key.
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	return k.Public().Key()
+}
+
+func (k *JWK) rsaKey() (interface{}, error) {
+	n, err := decodeJWKInt(k.N, "n")
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeJWKInt(k.E, "e")
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid JWK: RSA exponent too large")
+	}
+	pub := rsa.PublicKey{N: n, E: int(e.Int64())}
+	if !k.IsPrivate() {
+		return &pub, nil
+	}
+
+	d, err := decodeJWKInt(k.D, "d")
+	if err != nil {
+		return nil, err
+	}
+	priv := &rsa.PrivateKey{PublicKey: pub, D: d}
+	if k.P != "" && k.Q != "" {
+		p, err := decodeJWKInt(k.P, "p")
+		if err != nil {
+			return nil, err
+		}
+		q, err := decodeJWKInt(k.Q, "q")
+		if err != nil {
+			return nil, err
+		}
+		priv.Primes = []*big.Int{p, q}
+	} else {
+		return nil, errors.New("invalid JWK: RSA private key without primes is not supported")
+	}
+
+	if err := priv.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid JWK: %w", err)
+	}
+	priv.Precompute()
+	return priv, nil
+}
+
+func (k *JWK) ecKey() (interface{}, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported JWK curve: %s", k.Crv)
+	}
+
+	x, err := decodeJWKInt(k.X, "x")
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeJWKInt(k.Y, "y")
+	if err != nil {
+		return nil, err
+	}
+	pub := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("invalid JWK: point is not on curve")
+	}
+	if !k.IsPrivate() {
+		return &pub, nil
+	}
+
+	d, err := decodeJWKInt(k.D, "d")
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PrivateKey{PublicKey: pub, D: d}, nil
+}
+
+func (k *JWK) okpKey() (interface{}, error) {
+	if k.Crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported JWK curve: %s", k.Crv)
+	}
+
+	x, err := b64.DecodeString(k.X)
+	if err != nil || len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid JWK: malformed Ed25519 public key")
+	}
+	if !k.IsPrivate() {
+		return ed25519.PublicKey(x), nil
+	}
+
+	seed, err := b64.DecodeString(k.D)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("invalid JWK: malformed Ed25519 private key")
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	if !bytes.Equal(priv.Public().(ed25519.PublicKey), x) {
+		return nil, errors.New("invalid JWK: Ed25519 private key does not match public key")
+	}
+	return priv, nil
+}
+
+func decodeJWKInt(value, member string) (*big.Int, error) {
+	if value == "" {
+		return nil, fmt.Errorf("invalid JWK: missing %q", member)
+	}
+	raw, err := b64.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK: malformed %q: %w", member, err)
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// IsJWK reports whether data looks like a JSON document rather than PEM.
+func IsJWK(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// ParseJWK parses a single JWK or a JWKS document and selects one key from it.
+// With an empty kid a set must contain exactly one key, otherwise the key with
+// the matching kid is returned.
+func ParseJWK(data []byte, kid string) (*JWK, error) {
+	var probe struct {
+		Keys json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse JWK: %w", err)
+	}
+
+	if probe.Keys == nil {
+		var jwk JWK
+		if err := json.Unmarshal(data, &jwk); err != nil {
+			return nil, fmt.Errorf("failed to parse JWK: %w", err)
+		}
+		if kid != "" && jwk.Kid != kid {
+			return nil, fmt.Errorf("JWK kid %q does not match %q", jwk.Kid, kid)
+		}
+		return &jwk, nil
+	}
+
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	if kid == "" {
+		if len(set.Keys) != 1 {
+			return nil, fmt.Errorf("JWKS holds %d keys, select one by kid", len(set.Keys))
+		}
+		return &set.Keys[0], nil
+	}
+
+	for i := range set.Keys {
+		if set.Keys[i].Kid == kid {
+			return &set.Keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no key with kid %q in JWKS", kid)
+}
@@ -0,0 +1,177 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+)
+
+// EncodePrivateKeyPEM encodes an RSA private key as a PKCS#1 "RSA PRIVATE KEY" block,
+// the same encoding written by the gen command.
+func EncodePrivateKeyPEM(privKey *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privKey),
+	})
+}
+
+// EncodePublicKeyPEM encodes an RSA public key as a PKCS#1 "RSA PUBLIC KEY" block.
+func EncodePublicKeyPEM(pubKey *rsa.PublicKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(pubKey),
+	})
+}
+
+// EncodeCertificatePEM encodes a DER certificate as a "CERTIFICATE" block.
+func EncodeCertificatePEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: cert.Raw,
+	})
+}
+
+// LoadCertificate reads the first PEM encoded certificate from path.
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	certBytes, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		utility.Error("Failed to read certificate file: %s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"path": path,
+			"err":  err,
+		}).Error("Failed to read certificate file")
+		return nil, err
+	}
+
+	block, _ := pem.Decode(certBytes)
+	if block == nil || block.Type != "CERTIFICATE" {
+		utility.Error("Invalid certificate format")
+		logger.Logger.WithFields(logrus.Fields{
+			"path": path,
+		}).Error("Invalid certificate format")
+		return nil, errors.New("invalid certificate format")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		utility.Error("failed to parse certificate: %s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"path": path,
+			"err":  err,
+		}).Error("failed to parse certificate")
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// WriteKeyFile writes data to path, refusing to replace an existing file unless force is set.
+func WriteKeyFile(path string, data []byte, perm os.FileMode, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+		}
+		return err
+	}
+	defer f.Close()
+
+	// OpenFile keeps the mode of a file it truncates, so tighten it explicitly.
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	return err
+}
+
+// ParseKeyPEM parses the first PEM block of data into a public or private key of any
+// type cryptix understands: PKCS#1 and PKCS#8 private keys, SEC 1 EC private keys,
+// and PKCS#1 or SPKI public keys.
+func ParseKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid key format: no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", block.Type)
+	}
+}
+
+// EncodePKCS8PrivateKeyPEM encodes any supported private key as a PKCS#8 "PRIVATE KEY" block.
+func EncodePKCS8PrivateKeyPEM(privKey interface{}) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// EncodeSPKIPublicKeyPEM encodes any supported public key as an SPKI "PUBLIC KEY" block.
+func EncodeSPKIPublicKeyPEM(pubKey interface{}) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// LoadSigner reads a private key of any supported type from a PEM file.
+func LoadSigner(path string) (crypto.Signer, error) {
+	keyBytes, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		utility.Error("Failed to read private key file: %s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"path": path,
+			"err":  err,
+		}).Error("Failed to read private key file")
+		return nil, err
+	}
+
+	key, err := ParseKeyPEM(keyBytes)
+	if err != nil {
+		utility.Error("Failed to parse private key: %s", err)
+		logger.Logger.WithFields(logrus.Fields{
+			"path": path,
+			"err":  err,
+		}).Error("Failed to parse private key")
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		utility.Error("%s does not hold a private key", path)
+		logger.Logger.WithFields(logrus.Fields{
+			"path": path,
+		}).Error("not a private key")
+		return nil, errors.New("not a private key")
+	}
+
+	return signer, nil
+}
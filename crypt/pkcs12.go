@@ -0,0 +1,54 @@
+package crypt
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ImportPKCS12 extracts the RSA private key, its certificate and any CA certificates
+// from a password protected PKCS#12 (.p12/.pfx) bundle.
+func ImportPKCS12(data []byte, password string) (*rsa.PrivateKey, *x509.Certificate, []*x509.Certificate, error) {
+	key, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		utility.Error("failed to decode PKCS#12 bundle: %v", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to decode PKCS#12 bundle")
+		return nil, nil, nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		utility.Error("PKCS#12 bundle does not contain an RSA private key")
+		logger.Logger.Error("PKCS#12 bundle does not contain an RSA private key")
+		return nil, nil, nil, errors.New("PKCS#12 bundle does not contain an RSA private key")
+	}
+
+	logger.Logger.WithFields(logrus.Fields{
+		"subject": cert.Subject.String(),
+		"ca":      len(caCerts),
+	}).Info("PKCS#12 bundle decoded")
+	return rsaKey, cert, caCerts, nil
+}
+
+// ExportPKCS12 bundles an RSA private key and its certificate into a password protected
+// PKCS#12 file. The legacy encoder uses 3DES/RC2 for consumers that cannot read AES bundles.
+func ExportPKCS12(privKey *rsa.PrivateKey, cert *x509.Certificate, caCerts []*x509.Certificate, password string, legacy bool) ([]byte, error) {
+	encoder := pkcs12.Modern2023
+	if legacy {
+		encoder = pkcs12.LegacyDES
+	}
+
+	data, err := encoder.Encode(privKey, cert, caCerts, password)
+	if err != nil {
+		utility.Error("failed to encode PKCS#12 bundle: %v", err)
+		logger.Logger.WithFields(logrus.Fields{"err": err}).Error("Failed to encode PKCS#12 bundle")
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,91 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/utility"
+	"github.com/sirupsen/logrus"
+)
+
+// RecipientKey is the AES key of a multi-recipient envelope wrapped for one
+// recipient, identified by the fingerprint of their public key.
+type RecipientKey struct {
+	KeyID           string `json:"kid"`
+	EncryptedAESKey []byte `json:"encrypted_aes_key"`
+}
+
+// KeyFingerprint returns the hex SHA-256 of the key's DER encoded
+// SubjectPublicKeyInfo.
+func KeyFingerprint(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// HybridEncryptionForRecipients encrypts plaintext once and wraps the AES key with
+// RSA-OAEP for each public key, so every recipient can open the same envelope.
+func HybridEncryptionForRecipients(plaintext []byte, pubs []*rsa.PublicKey) ([]byte, []RecipientKey, error) {
+	logger.Logger.WithFields(logrus.Fields{"recipients": len(pubs)}).Info("Starting multi-recipient hybrid encryption process")
+	if len(pubs) == 0 {
+		return nil, nil, errors.New("no recipient keys given")
+	}
+
+	aesKey, encryptedMsg, err := sealWithNewKey(plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recipients := make([]RecipientKey, 0, len(pubs))
+	for _, pub := range pubs {
+		keyID, err := KeyFingerprint(pub)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		encryptedAESKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, aesKey, []byte(""))
+		if err != nil {
+			utility.Error("failed to encrypt AES key with RSA: %v", err)
+			logger.Logger.WithFields(logrus.Fields{"kid": keyID, "err": err}).Error("Failed to encrypt AES key with RSA")
+			return nil, nil, err
+		}
+		recipients = append(recipients, RecipientKey{KeyID: keyID, EncryptedAESKey: encryptedAESKey})
+	}
+
+	utility.Success("Message is encrypted successfully for %d recipients!", len(recipients))
+	logger.Logger.Info("Message is encrypted successfully!")
+	return encryptedMsg, recipients, nil
+}
+
+// MarshalMultiEnvelope encodes an encrypted message and its per-recipient keys.
+func MarshalMultiEnvelope(encryptedMsg []byte, recipients []RecipientKey) ([]byte, error) {
+	return json.MarshalIndent(EncryptedData{
+		EncryptedMessage: encryptedMsg,
+		Recipients:       recipients,
+	}, "", "  ")
+}
+
+// keyFor returns the wrapped AES key for the holder of pub.
+func (e *EncryptedData) keyFor(pub *rsa.PublicKey) ([]byte, error) {
+	keyID, err := KeyFingerprint(pub)
+	if err != nil {
+		return nil, err
+	}
+	for _, recipient := range e.Recipients {
+		if recipient.KeyID == keyID {
+			return recipient.EncryptedAESKey, nil
+		}
+	}
+	return nil, fmt.Errorf("envelope is not encrypted for this private key (kid %s)", keyID)
+}
@@ -0,0 +1,473 @@
+// Package ca implements a small certificate authority that binds teammates'
+// cryptix public keys to their email addresses.
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/cli/logger"
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	caKeyFile   = "ca.key"
+	caCertFile  = "ca.crt"
+	indexFile   = "index.json"
+	crlFile     = "crl.pem"
+	issuedDir   = "issued"
+	crlValidity = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrNotInitialized is returned when the CA directory holds no CA certificate.
+	ErrNotInitialized = errors.New("certificate authority is not initialised, run 'cryptix ca init'")
+	// ErrNoCertificate is returned when no valid certificate is issued for an address.
+	ErrNoCertificate = errors.New("no valid certificate issued for this address")
+)
+
+// Entry records a certificate issued by the CA.
+type Entry struct {
+	Serial      string    `json:"serial"`
+	Email       string    `json:"email"`
+	Subject     string    `json:"subject"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"not_after"`
+	Revoked     bool      `json:"revoked"`
+	RevokedAt   time.Time `json:"revoked_at,omitempty"`
+}
+
+// Authority is a certificate authority stored in a directory.
+type Authority struct {
+	Dir   string
+	Cert  *x509.Certificate
+	Index []Entry
+}
+
+// Init creates a new CA key and self-signed CA certificate in dir.
+func Init(dir string, subject pkix.Name, validity time.Duration, force bool) (*Authority, error) {
+	if _, err := os.Stat(filepath.Join(dir, caCertFile)); err == nil && !force {
+		return nil, fmt.Errorf("a certificate authority already exists in %s (use --force to replace it)", dir)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, issuedDir), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create CA directory: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	serial, err := crypt.NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := crypt.EncodePKCS8PrivateKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := crypt.WriteKeyFile(filepath.Join(dir, caKeyFile), keyPEM, 0600, true); err != nil {
+		return nil, fmt.Errorf("failed to write CA key: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, caCertFile), crypt.EncodeCertificatePEM(cert), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write CA certificate: %w", err)
+	}
+
+	authority := &Authority{Dir: dir, Cert: cert}
+	if err := authority.saveIndex(); err != nil {
+		return nil, err
+	}
+	if err := authority.WriteCRL(); err != nil {
+		return nil, err
+	}
+
+	logger.Logger.WithFields(logrus.Fields{
+		"dir":     dir,
+		"subject": subject.String(),
+	}).Info("Certificate authority initialised")
+	return authority, nil
+}
+
+// Open loads the CA certificate and issuance index from dir. The CA private key is
+// only read when signing, so a copy of the directory without ca.key can verify.
+func Open(dir string) (*Authority, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, caCertFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotInitialized
+		}
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("invalid CA certificate format")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	authority := &Authority{Dir: dir, Cert: cert}
+
+	indexBytes, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read CA index: %w", err)
+	}
+	if len(indexBytes) > 0 {
+		if err := json.Unmarshal(indexBytes, &authority.Index); err != nil {
+			return nil, fmt.Errorf("failed to parse CA index: %w", err)
+		}
+	}
+
+	return authority, nil
+}
+
+func (a *Authority) signer() (crypto.Signer, error) {
+	signer, err := crypt.LoadSigner(filepath.Join(a.Dir, caKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("CA private key is not available: %w", err)
+	}
+	return signer, nil
+}
+
+func (a *Authority) saveIndex() error {
+	if a.Index == nil {
+		a.Index = []Entry{}
+	}
+	data, err := json.MarshalIndent(a.Index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(a.Dir, indexFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to write CA index: %w", err)
+	}
+	return nil
+}
+
+// Issue signs a certificate binding pub to the email address.
+func (a *Authority) Issue(pub crypto.PublicKey, email, commonName string, validity time.Duration) (*x509.Certificate, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, fmt.Errorf("invalid email address %q", email)
+	}
+	if commonName == "" {
+		commonName = email
+	}
+
+	signer, err := a.signer()
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := crypt.NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(a.Cert.NotAfter) {
+		notAfter = a.Cert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		EmailAddresses:        []string{email},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              crypt.KeyUsageFor(pub),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.Cert, pub, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	serialHex := SerialString(cert.SerialNumber)
+	if err := os.WriteFile(filepath.Join(a.Dir, issuedDir, serialHex+".crt"), crypt.EncodeCertificatePEM(cert), 0644); err != nil {
+		return nil, fmt.Errorf("failed to store issued certificate: %w", err)
+	}
+
+	a.Index = append(a.Index, Entry{
+		Serial:      serialHex,
+		Email:       email,
+		Subject:     cert.Subject.String(),
+		Fingerprint: Fingerprint(cert),
+		NotAfter:    cert.NotAfter,
+	})
+	if err := a.saveIndex(); err != nil {
+		return nil, err
+	}
+
+	logger.Logger.WithFields(logrus.Fields{
+		"serial": serialHex,
+		"email":  email,
+	}).Info("Certificate issued")
+	return cert, nil
+}
+
+// Revoke marks the certificate with the given serial as revoked and refreshes the CRL.
+func (a *Authority) Revoke(serial string) (*Entry, error) {
+	serial = strings.ToLower(strings.TrimSpace(serial))
+	for i := range a.Index {
+		entry := &a.Index[i]
+		if entry.Serial != serial {
+			continue
+		}
+		if entry.Revoked {
+			return nil, fmt.Errorf("certificate %s is already revoked", serial)
+		}
+
+		entry.Revoked = true
+		entry.RevokedAt = time.Now().UTC()
+		if err := a.saveIndex(); err != nil {
+			return nil, err
+		}
+		if err := a.WriteCRL(); err != nil {
+			return nil, err
+		}
+
+		logger.Logger.WithFields(logrus.Fields{
+			"serial": serial,
+			"email":  entry.Email,
+		}).Info("Certificate revoked")
+		return entry, nil
+	}
+	return nil, fmt.Errorf("no certificate with serial %s", serial)
+}
+
+// WriteCRL signs a fresh certificate revocation list covering all revoked certificates.
+func (a *Authority) WriteCRL() error {
+	signer, err := a.signer()
+	if err != nil {
+		return err
+	}
+
+	var revoked []x509.RevocationListEntry
+	for _, entry := range a.Index {
+		if !entry.Revoked {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(entry.Serial, 16)
+		if !ok {
+			return fmt.Errorf("invalid serial %q in CA index", entry.Serial)
+		}
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: entry.RevokedAt,
+		})
+	}
+
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+		RevokedCertificateEntries: revoked,
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, a.Cert, signer)
+	if err != nil {
+		return fmt.Errorf("failed to create CRL: %w", err)
+	}
+
+	crlPEM := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	if err := os.WriteFile(filepath.Join(a.Dir, crlFile), crlPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write CRL: %w", err)
+	}
+	return nil
+}
+
+// CRL loads the current revocation list and checks that the CA signed it.
+func (a *Authority) CRL() (*x509.RevocationList, []byte, error) {
+	crlPEM, err := os.ReadFile(filepath.Join(a.Dir, crlFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CRL: %w", err)
+	}
+
+	block, _ := pem.Decode(crlPEM)
+	if block == nil || block.Type != "X509 CRL" {
+		return nil, nil, errors.New("invalid CRL format")
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CRL: %w", err)
+	}
+	if err := crl.CheckSignatureFrom(a.Cert); err != nil {
+		return nil, nil, fmt.Errorf("CRL signature is invalid: %w", err)
+	}
+	return crl, crlPEM, nil
+}
+
+// Bundle returns the trust bundle: the CA certificate followed by the current CRL.
+func (a *Authority) Bundle() ([]byte, error) {
+	_, crlPEM, err := a.CRL()
+	if err != nil {
+		return nil, err
+	}
+	return append(crypt.EncodeCertificatePEM(a.Cert), crlPEM...), nil
+}
+
+// Verify checks that cert was issued by this CA for email protection, is within its
+// validity period and is not listed on the CRL.
+func (a *Authority) Verify(cert *x509.Certificate) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(a.Cert)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}); err != nil {
+		return fmt.Errorf("certificate is not trusted by the CA: %w", err)
+	}
+
+	crl, _, err := a.CRL()
+	if err != nil {
+		return err
+	}
+	if crl.NextUpdate.Before(time.Now()) {
+		return errors.New("CRL has expired, run 'cryptix ca crl' to refresh it")
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return fmt.Errorf("certificate %s has been revoked", SerialString(cert.SerialNumber))
+		}
+	}
+	return nil
+}
+
+// Lookup returns the newest certificate issued for email that still verifies, names
+// email and certifies a key for encryption.
+func (a *Authority) Lookup(email string) (*x509.Certificate, error) {
+	certs, err := a.LookupAll(email)
+	if err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// LookupAll returns every certificate Lookup would accept for email, newest first,
+// so a key picked by other means can be checked against all of them.
+func (a *Authority) LookupAll(email string) ([]*x509.Certificate, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	var candidates []Entry
+	for _, entry := range a.Index {
+		if entry.Email == email && !entry.Revoked && entry.NotAfter.After(time.Now()) {
+			candidates = append(candidates, entry)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].NotAfter.After(candidates[j].NotAfter)
+	})
+
+	var certs []*x509.Certificate
+	for _, entry := range candidates {
+		cert, err := crypt.LoadCertificate(filepath.Join(a.Dir, issuedDir, entry.Serial+".crt"))
+		if err != nil {
+			continue
+		}
+		if err := a.Verify(cert); err != nil {
+			logger.Logger.WithFields(logrus.Fields{
+				"serial": entry.Serial,
+				"email":  email,
+				"err":    err,
+			}).Warn("Skipping certificate that does not verify")
+			continue
+		}
+		// The index is only a cache: a misfiled or swapped file must not hand out
+		// someone else's key, nor a signing-only key.
+		if err := certifiesEncryptionKey(cert, email); err != nil {
+			logger.Logger.WithFields(logrus.Fields{
+				"serial": entry.Serial,
+				"email":  email,
+				"err":    err,
+			}).Warn("Skipping certificate that does not certify an encryption key for the address")
+			continue
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoCertificate, email)
+	}
+	return certs, nil
+}
+
+// certifiesEncryptionKey checks that cert binds its key to email and allows it to
+// encrypt content keys.
+func certifiesEncryptionKey(cert *x509.Certificate, email string) error {
+	bound := false
+	for _, address := range cert.EmailAddresses {
+		if strings.EqualFold(address, email) {
+			bound = true
+			break
+		}
+	}
+	if !bound {
+		return fmt.Errorf("certificate %s is issued for %s, not %s",
+			SerialString(cert.SerialNumber), strings.Join(cert.EmailAddresses, ", "), email)
+	}
+	if cert.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+		return fmt.Errorf("certificate %s does not allow key encipherment", SerialString(cert.SerialNumber))
+	}
+	return nil
+}
+
+// SerialString formats a certificate serial number as lowercase hex.
+func SerialString(serial *big.Int) string {
+	return fmt.Sprintf("%x", serial)
+}
+
+// Fingerprint returns the hex SHA-256 fingerprint of a certificate.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
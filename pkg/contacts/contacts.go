@@ -0,0 +1,233 @@
+// Package contacts implements the address book that maps email addresses to the
+// public keys messages are encrypted for.
+package contacts
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Kshitiz-Mhto/cryptix/crypt"
+)
+
+const contactsFile = "contacts.json"
+
+// Trust levels of a contact's key.
+const (
+	// TrustUnverified keys were added without checking the fingerprint.
+	TrustUnverified = "unverified"
+	// TrustVerified keys had their fingerprint confirmed with the owner.
+	TrustVerified = "verified"
+)
+
+// ErrNotFound is returned when no contact holds an address or name.
+var ErrNotFound = errors.New("no contact found")
+
+// Contact is a person, their email addresses and their public key.
+type Contact struct {
+	Name        string    `json:"name"`
+	Emails      []string  `json:"emails"`
+	PublicKey   string    `json:"public_key"`
+	Fingerprint string    `json:"fingerprint"`
+	Trust       string    `json:"trust"`
+	Added       time.Time `json:"added"`
+	// KeyChangedAt is set when the key is replaced, until the new key is verified.
+	KeyChangedAt         time.Time `json:"key_changed_at,omitempty"`
+	PreviousFingerprints []string  `json:"previous_fingerprints,omitempty"`
+}
+
+// Key returns the contact's RSA public key.
+func (c *Contact) Key() (*rsa.PublicKey, error) {
+	key, err := crypt.ParseKeyPEM([]byte(c.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key for contact %s: %w", c.Name, err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key for contact %s is not an RSA public key", c.Name)
+	}
+	return pub, nil
+}
+
+// KeyChanged reports whether the key was replaced and the new one is not yet verified.
+func (c *Contact) KeyChanged() bool {
+	return !c.KeyChangedAt.IsZero() && c.Trust != TrustVerified
+}
+
+// HasEmail reports whether email is one of the contact's addresses.
+func (c *Contact) HasEmail(email string) bool {
+	email = normalizeEmail(email)
+	for _, address := range c.Emails {
+		if address == email {
+			return true
+		}
+	}
+	return false
+}
+
+// Store is the address book, kept as a JSON file in the config directory.
+type Store struct {
+	Path     string
+	Contacts []*Contact
+}
+
+// Open loads the address book from dir. A missing file is an empty address book.
+func Open(dir string) (*Store, error) {
+	store := &Store{Path: filepath.Join(dir, contactsFile)}
+
+	data, err := os.ReadFile(store.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+		return nil, fmt.Errorf("failed to read contacts: %w", err)
+	}
+	if err := json.Unmarshal(data, &store.Contacts); err != nil {
+		return nil, fmt.Errorf("failed to parse contacts: %w", err)
+	}
+	return store, nil
+}
+
+// Save writes the address book, replacing the file atomically.
+func (s *Store) Save() error {
+	if s.Contacts == nil {
+		s.Contacts = []*Contact{}
+	}
+	sort.Slice(s.Contacts, func(i, j int) bool {
+		return strings.ToLower(s.Contacts[i].Name) < strings.ToLower(s.Contacts[j].Name)
+	})
+
+	data, err := json.MarshalIndent(s.Contacts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write contacts: %w", err)
+	}
+	if err := os.Rename(tmp, s.Path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write contacts: %w", err)
+	}
+	return nil
+}
+
+// Lookup returns the contact holding email.
+func (s *Store) Lookup(email string) (*Contact, error) {
+	for _, contact := range s.Contacts {
+		if contact.HasEmail(email) {
+			return contact, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, email)
+}
+
+// Find returns the contact with the given email address or name.
+func (s *Store) Find(query string) (*Contact, error) {
+	if contact, err := s.Lookup(query); err == nil {
+		return contact, nil
+	}
+	for _, contact := range s.Contacts {
+		if strings.EqualFold(contact.Name, strings.TrimSpace(query)) {
+			return contact, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, query)
+}
+
+// Add records a contact, or updates the contact already holding one of the
+// addresses. Replacing an existing key records the old fingerprint and resets the
+// trust level to trust, which the caller should default to unverified. The
+// previous fingerprint is returned when the key changed.
+func (s *Store) Add(name string, emails []string, pub *rsa.PublicKey, trust string) (*Contact, string, error) {
+	if trust != TrustUnverified && trust != TrustVerified {
+		return nil, "", fmt.Errorf("unsupported trust level %q, use %s or %s", trust, TrustUnverified, TrustVerified)
+	}
+	if len(emails) == 0 {
+		return nil, "", errors.New("a contact needs at least one email address")
+	}
+
+	fingerprint, err := crypt.KeyFingerprint(pub)
+	if err != nil {
+		return nil, "", err
+	}
+	keyPEM := crypt.EncodePublicKeyPEM(pub)
+
+	var existing *Contact
+	for i := range emails {
+		emails[i] = normalizeEmail(emails[i])
+		contact, err := s.Lookup(emails[i])
+		if err != nil {
+			continue
+		}
+		if existing != nil && existing != contact {
+			return nil, "", fmt.Errorf("the addresses belong to different contacts, %s and %s", existing.Name, contact.Name)
+		}
+		existing = contact
+	}
+
+	if existing == nil {
+		if name == "" {
+			name = emails[0]
+		}
+		contact := &Contact{
+			Name:        name,
+			Emails:      emails,
+			PublicKey:   string(keyPEM),
+			Fingerprint: fingerprint,
+			Trust:       trust,
+			Added:       time.Now(),
+		}
+		s.Contacts = append(s.Contacts, contact)
+		return contact, "", nil
+	}
+
+	if name != "" {
+		existing.Name = name
+	}
+	for _, email := range emails {
+		if !existing.HasEmail(email) {
+			existing.Emails = append(existing.Emails, email)
+		}
+	}
+
+	previous := ""
+	if existing.Fingerprint != fingerprint {
+		previous = existing.Fingerprint
+		existing.PreviousFingerprints = append(existing.PreviousFingerprints, previous)
+		existing.PublicKey = string(keyPEM)
+		existing.Fingerprint = fingerprint
+		existing.KeyChangedAt = time.Now()
+	}
+	existing.Trust = trust
+	return existing, previous, nil
+}
+
+// Remove deletes the contact with the given email address or name.
+func (s *Store) Remove(query string) (*Contact, error) {
+	contact, err := s.Find(query)
+	if err != nil {
+		return nil, err
+	}
+	for i, candidate := range s.Contacts {
+		if candidate == contact {
+			s.Contacts = append(s.Contacts[:i], s.Contacts[i+1:]...)
+			break
+		}
+	}
+	return contact, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -2,7 +2,9 @@ package env
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,15 +13,62 @@ type Config struct {
 	CLI_VERSION     string
 	CLI_NAME        string
 	CLI_BINARY_PATH string
+	CONFIG_DIR      string
+	CA_DIR          string
 
 	FromEmail              string
+	FromName               string
 	FromEmailPassword      string
 	FromEmailSMTP          string
 	SMTPAddress            string
+	SMTPTLSMode            string
+	SMTPCAFile             string
+	SMTPAuth               string
+	SMTPConnectTimeout     int64
+	SMTPCommandTimeout     int64
+	MailTransport          string
+	SendmailPath           string
+	WebhookURL             string
+	WebhookToken           string
+	WebhookTimeout         int64
+	EMLDir                 string
+	OutboxDir              string
+	DKIMDomain             string
+	DKIMSelector           string
+	DKIMPrivateKey         string
+	SMIMECert              string
+	SMIMEKey               string
 	OWNER_EMAIL            string
 	SUBJECT_DESC           string
 	HTML_TEMPLATE          string
+	TEXT_TEMPLATE          string
+	TemplateDir            string
+	AttachmentLimit        int64
+	UploadChunkSize        int64
+	UploadDir              string
+	SharesDir              string
+	ShareTTL               time.Duration
+	ShareExpiryAction      string
+	Store                  string
+	StoreDir               string
+	StoreBaseURL           string
+	S3Endpoint             string
+	S3Region               string
+	S3Bucket               string
+	S3AccessKey            string
+	S3SecretKey            string
+	S3PathStyle            bool
+	WebDAVURL              string
+	WebDAVUser             string
+	WebDAVPassword         string
+	SFTPAddr               string
+	SFTPUser               string
+	SFTPPassword           string
+	SFTPKey                string
+	SFTPKnownHosts         string
+	SFTPDir                string
 	OAUTH_CREDENTIALS_PATH string
+	OAuthTokenPath         string
 
 	JPEG_FORMAT string
 	JPG_FORMAT  string
@@ -31,17 +80,65 @@ var Vars = initConfig()
 
 func initConfig() Config {
 	godotenv.Load()
+	configDir := GetEnv("CONFIG_DIR", defaultConfigDir())
 	return Config{
 		CLI_NAME:               GetEnv("CLI_NAME", "cryptix"),
 		CLI_BINARY_PATH:        GetEnv("CLI_BINARY_PATH", "bin/cryptix"),
+		CONFIG_DIR:             configDir,
+		CA_DIR:                 GetEnv("CA_DIR", filepath.Join(configDir, "ca")),
 		CLI_VERSION:            GetEnv("CLI_VERSION", "1.0.0-stable"),
 		FromEmail:              GetEnv("FROM_EMAIL", ""),
+		FromName:               GetEnv("FROM_NAME", ""),
 		FromEmailPassword:      GetEnv("FROM_EMAIL_PASSWORD", ""),
 		FromEmailSMTP:          GetEnv("FROM_EMAIL_SMTP", "smtp.gmail.com"),
 		SMTPAddress:            GetEnv("SMTP_ADDR", "smtp.gmail.com:587"),
+		SMTPTLSMode:            GetEnv("SMTP_TLS_MODE", "starttls-required"),
+		SMTPCAFile:             GetEnv("SMTP_CA_FILE", ""),
+		SMTPAuth:               GetEnv("SMTP_AUTH", "plain"),
+		SMTPConnectTimeout:     GetEnvAsInt("SMTP_CONNECT_TIMEOUT", 10),
+		SMTPCommandTimeout:     GetEnvAsInt("SMTP_COMMAND_TIMEOUT", 30),
+		MailTransport:          GetEnv("MAIL_TRANSPORT", "smtp"),
+		SendmailPath:           GetEnv("SENDMAIL_PATH", "/usr/sbin/sendmail"),
+		WebhookURL:             GetEnv("WEBHOOK_URL", ""),
+		WebhookToken:           GetEnv("WEBHOOK_TOKEN", ""),
+		WebhookTimeout:         GetEnvAsInt("WEBHOOK_TIMEOUT", 30),
+		EMLDir:                 GetEnv("EML_DIR", filepath.Join(configDir, "drop")),
+		OutboxDir:              GetEnv("OUTBOX_DIR", filepath.Join(configDir, "outbox")),
+		DKIMDomain:             GetEnv("DKIM_DOMAIN", ""),
+		DKIMSelector:           GetEnv("DKIM_SELECTOR", "cryptix"),
+		DKIMPrivateKey:         GetEnv("DKIM_PRIVATE_KEY", ""),
+		SMIMECert:              GetEnv("SMIME_CERT", ""),
+		SMIMEKey:               GetEnv("SMIME_KEY", ""),
 		SUBJECT_DESC:           GetEnv("SUBJECT_DESC", "Hey smthg for you!!"),
 		OAUTH_CREDENTIALS_PATH: GetEnv("CREDENTIALS_PATH", ""),
+		OAuthTokenPath:         GetEnv("OAUTH_TOKEN_PATH", filepath.Join(configDir, "google-token.json")),
 		HTML_TEMPLATE:          GetEnv("HTML_TEMPLATE", "email.html"),
+		TEXT_TEMPLATE:          GetEnv("TEXT_TEMPLATE", "email.txt"),
+		TemplateDir:            GetEnv("TEMPLATE_DIR", filepath.Join(configDir, "templates")),
+		AttachmentLimit:        GetEnvAsInt("ATTACHMENT_LIMIT", 10<<20),
+		UploadChunkSize:        GetEnvAsInt("UPLOAD_CHUNK_SIZE", 8<<20),
+		UploadDir:              GetEnv("UPLOAD_DIR", filepath.Join(configDir, "uploads")),
+		SharesDir:              GetEnv("SHARES_DIR", filepath.Join(configDir, "shares")),
+		ShareTTL:               GetEnvAsDuration("SHARE_TTL", 7*24*time.Hour),
+		ShareExpiryAction:      GetEnv("SHARE_EXPIRY_ACTION", "delete"),
+		Store:                  GetEnv("STORE", "drive"),
+		StoreDir:               GetEnv("STORE_DIR", ""),
+		StoreBaseURL:           GetEnv("STORE_BASE_URL", ""),
+		S3Endpoint:             GetEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+		S3Region:               GetEnv("S3_REGION", "us-east-1"),
+		S3Bucket:               GetEnv("S3_BUCKET", ""),
+		S3AccessKey:            GetEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:            GetEnv("S3_SECRET_KEY", ""),
+		S3PathStyle:            GetEnv("S3_PATH_STYLE", "false") == "true",
+		WebDAVURL:              GetEnv("WEBDAV_URL", ""),
+		WebDAVUser:             GetEnv("WEBDAV_USER", ""),
+		WebDAVPassword:         GetEnv("WEBDAV_PASSWORD", ""),
+		SFTPAddr:               GetEnv("SFTP_ADDR", ""),
+		SFTPUser:               GetEnv("SFTP_USER", ""),
+		SFTPPassword:           GetEnv("SFTP_PASSWORD", ""),
+		SFTPKey:                GetEnv("SFTP_KEY", ""),
+		SFTPKnownHosts:         GetEnv("SFTP_KNOWN_HOSTS", defaultKnownHosts()),
+		SFTPDir:                GetEnv("SFTP_DIR", "cryptix"),
 		JPEG_FORMAT:            GetEnv("JPEG_FORMAT", ".jpeg"),
 		JPG_FORMAT:             GetEnv("JPG_FORMAT", ".jpg"),
 		TXT_FORMAT:             GetEnv("TXT_FORMAT", ".txt"),
@@ -49,8 +146,29 @@ func initConfig() Config {
 	}
 }
 
+// defaultConfigDir returns the per-user cryptix config directory, falling back to
+// a .cryptix directory in the working directory when the OS does not define one.
+func defaultConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".cryptix"
+	}
+	return filepath.Join(dir, "cryptix")
+}
+
+// defaultKnownHosts returns the user's OpenSSH known_hosts file.
+func defaultKnownHosts() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "known_hosts"
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// GetEnv returns the value of key, or fallback when it is unset or empty, so an
+// empty assignment copied from an example .env keeps the default.
 func GetEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
 		return value
 	}
 	return fallback
@@ -66,3 +184,14 @@ func GetEnvAsInt(key string, fallback int64) int64 {
 	}
 	return fallback
 }
+
+func GetEnvAsDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fallback
+		}
+		return d
+	}
+	return fallback
+}
@@ -0,0 +1,10 @@
+// Package static embeds the default email templates so the binary works from any
+// directory.
+package static
+
+import "embed"
+
+// Templates holds the default HTML templates and their plain-text companions.
+//
+//go:embed *.html *.txt
+var Templates embed.FS